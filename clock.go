@@ -0,0 +1,41 @@
+package dnet
+
+import "time"
+
+// Clock tells the time and runs the functions after a duration.
+// The sessions use it for the read and write timeouts, so that the tests
+// can replace it by a fake clock and advance the time by hand.
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+
+	// AfterFunc calls f in its own goroutine after the duration d.
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// Timer is a function waiting to be called by a Clock.
+type Timer interface {
+	// Stop prevents the function from being called.
+	// It returns false if the function has been called or the timer has been stopped.
+	Stop() bool
+
+	// Reset changes the timer to call the function after the duration d.
+	// It returns true if the timer had been active.
+	Reset(d time.Duration) bool
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) AfterFunc(d time.Duration, f func()) Timer {
+	return time.AfterFunc(d, f)
+}
+
+// SystemClock is the Clock of the system time.
+var SystemClock Clock = systemClock{}
+
+// aLongTimeAgo is a deadline in the past, which wakes up the blocked I/O at once.
+var aLongTimeAgo = time.Unix(1, 0)
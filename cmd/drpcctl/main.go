@@ -0,0 +1,303 @@
+// Command drpcctl lists and calls the methods of a running drpc server.
+// The server must use the codec of drpc/codec, with the protobuf or JSON marshaler.
+//
+//	drpcctl -addr 127.0.0.1:7756 list
+//	drpcctl -addr 127.0.0.1:7756 -descriptor echo.pb call echo '{"msg": "hello"}'
+//	drpcctl -ws -addr 127.0.0.1:7757 -codec json -type main.Echo call echo '{"Msg": "hello"}'
+//
+// The methods are listed by the reflection of server, which is enabled by
+// drpc.WithReflection(true). The request type of a method is listed if it is
+// registered by RegisterTyped, or set by -type. The protobuf messages are looked
+// up in the types compiled in and the descriptor set made by
+// protoc --include_imports --descriptor_set_out=FILE.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/yddeng/dnet"
+	"github.com/yddeng/dnet/drpc"
+	"github.com/yddeng/dnet/drpc/codec"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+const usageText = `usage: drpcctl [flags] list
+       drpcctl [flags] call <method> [json|-]
+
+The argument of call is read from stdin if it is "-", and is {} if it is omitted.
+
+flags:
+`
+
+// dialTCP and dialWS connect to the server, they are replaced in the tests.
+var (
+	dialTCP = dnet.DialTCP
+	dialWS  = dnet.DialWS
+)
+
+// options are the flags and arguments of the command line.
+type options struct {
+	addr       string
+	ws         bool
+	timeout    time.Duration
+	codecName  string
+	descriptor string
+	typeName   string
+
+	cmd    string   // list or call
+	method string   // method of call
+	arg    []string // argument of call
+}
+
+// parseArgs parses the command line without the program name, the usage is
+// written to output if the command line is invalid.
+func parseArgs(args []string, output io.Writer) (*options, error) {
+	opts := &options{}
+	fs := flag.NewFlagSet("drpcctl", flag.ContinueOnError)
+	fs.SetOutput(output)
+	fs.StringVar(&opts.addr, "addr", "127.0.0.1:7756", "address of the server")
+	fs.BoolVar(&opts.ws, "ws", false, "dial by WebSocket instead of TCP")
+	fs.DurationVar(&opts.timeout, "timeout", 5*time.Second, "timeout of dial and call")
+	fs.StringVar(&opts.codecName, "codec", "protobuf", "marshaler of the codec: protobuf or json")
+	fs.StringVar(&opts.descriptor, "descriptor", "", "protobuf FileDescriptorSet of the messages")
+	fs.StringVar(&opts.typeName, "type", "", "type name of the request, instead of the one listed by the server")
+	fs.Usage = func() {
+		fmt.Fprint(output, usageText)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	usageErr := func(format string, a ...interface{}) (*options, error) {
+		err := fmt.Errorf(format, a...)
+		fmt.Fprintln(output, err)
+		fs.Usage()
+		return nil, err
+	}
+	args = fs.Args()
+	if len(args) == 0 {
+		return usageErr("drpcctl: no command")
+	}
+	opts.cmd = args[0]
+	switch opts.cmd {
+	case "list":
+		if len(args) != 1 {
+			return usageErr("drpcctl: list takes no argument")
+		}
+	case "call":
+		if len(args) < 2 || len(args) > 3 {
+			return usageErr("drpcctl: call takes a method and an optional argument")
+		}
+		opts.method, opts.arg = args[1], args[2:]
+	default:
+		return usageErr("drpcctl: unknown command %s", opts.cmd)
+	}
+	switch opts.codecName {
+	case "protobuf", "json":
+	default:
+		return usageErr("drpcctl: unknown codec %s", opts.codecName)
+	}
+	return opts, nil
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// run runs the command line, and returns the exit code: 2 if the command line
+// is invalid, 1 if the command fails.
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	opts, err := parseArgs(args, stderr)
+	if err == flag.ErrHelp {
+		return 0
+	} else if err != nil {
+		return 2
+	}
+	if err := opts.run(stdin, stdout, stderr); err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	return 0
+}
+
+func (opts *options) run(stdin io.Reader, stdout, stderr io.Writer) error {
+	descs, err := loadDescriptors(opts.descriptor)
+	if err != nil {
+		return err
+	}
+
+	var marshaler codec.Marshaler
+	if opts.codecName == "json" {
+		marshaler = jsonMarshaler{}
+	} else {
+		marshaler = protoMarshaler{descriptors: descs}
+	}
+
+	ep, err := opts.dial(marshaler)
+	if err != nil {
+		return err
+	}
+	defer ep.Session().Close(nil)
+
+	if opts.cmd == "list" {
+		return opts.list(ep, stdout)
+	}
+	return opts.call(ep, descs, stdin, stdout, stderr)
+}
+
+// dial connects to the server, and returns the endpoint to call.
+func (opts *options) dial(marshaler codec.Marshaler) (*drpc.Endpoint, error) {
+	ep := drpc.NewEndpoint(nil)
+	options := append(ep.Options(), dnet.WithCodec(codec.NewCodec(marshaler)))
+
+	var conn net.Conn
+	var err error
+	if opts.ws {
+		if conn, err = dialWS(opts.addr, opts.timeout); err != nil {
+			return nil, err
+		}
+		ep.Attach(dnet.NewWSSession(conn, options...))
+	} else {
+		if conn, err = dialTCP(opts.addr, opts.timeout); err != nil {
+			return nil, err
+		}
+		ep.Attach(dnet.NewTCPSession(conn, options...))
+	}
+	return ep, nil
+}
+
+func (opts *options) listMethods(ep *drpc.Endpoint) ([]drpc.MethodInfo, error) {
+	ret, err := ep.Call(drpc.ReflectListMethods, nil, opts.timeout)
+	if err != nil {
+		return nil, fmt.Errorf("drpcctl: list methods: %v", err)
+	}
+	return drpc.DecodeMethodList(ret)
+}
+
+func (opts *options) list(ep *drpc.Endpoint, stdout io.Writer) error {
+	methods, err := opts.listMethods(ep)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "METHOD\tKIND\tREQUEST\tRESPONSE")
+	for _, m := range methods {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", m.Name, m.Kind, m.Request, m.Response)
+	}
+	return w.Flush()
+}
+
+func (opts *options) call(ep *drpc.Endpoint, descs *descriptors, stdin io.Reader, stdout, stderr io.Writer) error {
+	arg := []byte("{}")
+	if len(opts.arg) > 0 {
+		if opts.arg[0] == "-" {
+			data, err := ioutil.ReadAll(stdin)
+			if err != nil {
+				return err
+			}
+			arg = data
+		} else {
+			arg = []byte(opts.arg[0])
+		}
+	}
+
+	name := opts.typeName
+	if name == "" {
+		methods, err := opts.listMethods(ep)
+		if err != nil {
+			return err
+		}
+		for _, m := range methods {
+			if m.Name == opts.method {
+				name = m.Request
+			}
+		}
+		if name == "" {
+			return fmt.Errorf("drpcctl: the request type of %s is not listed by the server, set -type", opts.method)
+		}
+	}
+
+	req, err := opts.newRequest(descs, name, arg)
+	if err != nil {
+		return err
+	}
+
+	start := time.Now()
+	ret, err := ep.Call(opts.method, req, opts.timeout)
+	latency := time.Since(start)
+	if err != nil {
+		code := drpc.CodeOf(err)
+		data, _ := json.MarshalIndent(map[string]interface{}{
+			"code":    code.String(),
+			"value":   uint32(code),
+			"message": err.Error(),
+		}, "", "  ")
+		fmt.Fprintln(stdout, string(data))
+		fmt.Fprintf(stderr, "latency: %s\n", latency)
+		return fmt.Errorf("drpcctl: call %s: %s", opts.method, code)
+	}
+
+	out, err := format(ret)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(stdout, string(out))
+	fmt.Fprintf(stderr, "latency: %s\n", latency)
+	return nil
+}
+
+// newRequest converts the JSON arg to the request of type name.
+func (opts *options) newRequest(descs *descriptors, name string, arg []byte) (interface{}, error) {
+	if opts.codecName == "json" {
+		if !json.Valid(arg) {
+			return nil, fmt.Errorf("drpcctl: argument is not valid JSON")
+		}
+		return &jsonMessage{name: name, data: arg}, nil
+	}
+
+	mt, err := descs.messageType(name)
+	if err != nil {
+		return nil, err
+	}
+	msg := mt.New().Interface()
+	if err := protojson.Unmarshal(arg, msg); err != nil {
+		return nil, fmt.Errorf("drpcctl: argument to %s: %v", name, err)
+	}
+	return msg, nil
+}
+
+// format returns the response as JSON.
+func format(ret interface{}) ([]byte, error) {
+	switch v := ret.(type) {
+	case nil:
+		return []byte("null"), nil
+	case proto.Message:
+		return protojson.MarshalOptions{Multiline: true, Indent: "  "}.Marshal(v)
+	case *jsonMessage:
+		var buf bytes.Buffer
+		if err := json.Indent(&buf, v.data, "", "  "); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case []byte:
+		if json.Valid(v) {
+			var buf bytes.Buffer
+			_ = json.Indent(&buf, v, "", "  ")
+			return buf.Bytes(), nil
+		}
+		return json.Marshal(v)
+	default:
+		return json.MarshalIndent(v, "", "  ")
+	}
+}
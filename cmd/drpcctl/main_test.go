@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/yddeng/dnet"
+	"github.com/yddeng/dnet/dnettest"
+	"github.com/yddeng/dnet/drpc"
+	"github.com/yddeng/dnet/drpc/codec"
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseArgs(t *testing.T) {
+	for _, test := range []struct {
+		args   []string
+		ok     bool
+		cmd    string
+		method string
+	}{
+		{[]string{"list"}, true, "list", ""},
+		{[]string{"-addr", "127.0.0.1:1", "-codec", "json", "call", "echo"}, true, "call", "echo"},
+		{[]string{"call", "echo", "{}"}, true, "call", "echo"},
+		{[]string{}, false, "", ""},
+		{[]string{"list", "echo"}, false, "", ""},
+		{[]string{"call"}, false, "", ""},
+		{[]string{"call", "echo", "{}", "{}"}, false, "", ""},
+		{[]string{"get"}, false, "", ""},
+		{[]string{"-codec", "xml", "list"}, false, "", ""},
+		{[]string{"-bad", "list"}, false, "", ""},
+	} {
+		opts, err := parseArgs(test.args, ioutil.Discard)
+		if (err == nil) != test.ok {
+			t.Fatalf("parse %v: %v", test.args, err)
+		}
+		if err == nil && (opts.cmd != test.cmd || opts.method != test.method) {
+			t.Fatalf("parse %v: %+v", test.args, opts)
+		}
+	}
+}
+
+// the invalid command line exits before dialing
+func TestRunInvalidArgs(t *testing.T) {
+	dialTCP = func(address string, timeout time.Duration) (net.Conn, error) {
+		t.Fatal("dial with the invalid command line")
+		return nil, nil
+	}
+	defer func() { dialTCP = dnet.DialTCP }()
+
+	var stderr bytes.Buffer
+	if code := run([]string{"get"}, nil, ioutil.Discard, &stderr); code != 2 {
+		t.Fatalf("exit code %d", code)
+	}
+	if !strings.Contains(stderr.String(), "usage:") {
+		t.Fatalf("stderr %q", stderr.String())
+	}
+	if code := run([]string{"-h"}, nil, ioutil.Discard, ioutil.Discard); code != 0 {
+		t.Fatalf("exit code %d of -h", code)
+	}
+}
+
+type echoReq struct {
+	Msg string
+}
+
+func TestRunCall(t *testing.T) {
+	registry := codec.NewRegistry()
+	registry.RegisterName("test.Echo", &echoReq{})
+	marshaler := codec.NewJSONMarshaler(registry)
+	server := drpc.NewServer(drpc.WithReflection(true), drpc.WithTypeNamer(codec.TypeNamer(marshaler)))
+	server.RegisterTyped("echo", func(req *echoReq) (*echoReq, error) {
+		if req.Msg == "" {
+			return nil, drpc.NewError(drpc.CodeInvalidArgument, "drpc: empty msg")
+		}
+		return req, nil
+	})
+
+	acceptor := dnettest.NewAcceptor("drpc")
+	go acceptor.ServeFunc(func(conn net.Conn) {
+		ep := drpc.NewEndpoint(server)
+		ep.Attach(dnet.NewTCPSession(conn, append(ep.Options(), dnet.WithCodec(codec.NewCodec(marshaler)))...))
+	})
+	defer acceptor.Stop()
+	dialTCP = func(address string, timeout time.Duration) (net.Conn, error) {
+		return acceptor.Dial(timeout)
+	}
+	defer func() { dialTCP = dnet.DialTCP }()
+
+	var stdout bytes.Buffer
+	if code := run([]string{"-codec", "json", "call", "echo", "-"}, strings.NewReader(`{"Msg": "hello"}`), &stdout, ioutil.Discard); code != 0 {
+		t.Fatalf("exit code %d", code)
+	}
+	var resp echoReq
+	if err := json.Unmarshal(stdout.Bytes(), &resp); err != nil || resp.Msg != "hello" {
+		t.Fatalf("response %q %v", stdout.String(), err)
+	}
+
+	// the error of call exits with 1
+	stdout.Reset()
+	if code := run([]string{"-codec", "json", "call", "echo"}, nil, &stdout, ioutil.Discard); code != 1 {
+		t.Fatalf("exit code %d", code)
+	}
+	if !strings.Contains(stdout.String(), drpc.CodeInvalidArgument.String()) {
+		t.Fatalf("stdout %q", stdout.String())
+	}
+
+	stdout.Reset()
+	if code := run([]string{"-codec", "json", "list"}, nil, &stdout, ioutil.Discard); code != 0 {
+		t.Fatalf("exit code %d", code)
+	}
+	if !strings.Contains(stdout.String(), "test.Echo") {
+		t.Fatalf("list %q", stdout.String())
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/yddeng/dnet/drpc/codec"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"io/ioutil"
+)
+
+// descriptors finds the protobuf messages by full name, in the registry
+// of the types compiled in, or in the files of a descriptor set.
+type descriptors struct {
+	files *protoregistry.Files
+}
+
+// loadDescriptors reads a FileDescriptorSet made by
+// protoc --include_imports --descriptor_set_out=FILE. path may be empty.
+func loadDescriptors(path string) (*descriptors, error) {
+	d := &descriptors{files: new(protoregistry.Files)}
+	if path == "" {
+		return d, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	set := new(descriptorpb.FileDescriptorSet)
+	if err := proto.Unmarshal(data, set); err != nil {
+		return nil, fmt.Errorf("drpcctl: descriptor set %s: %v", path, err)
+	}
+	if d.files, err = protodesc.NewFiles(set); err != nil {
+		return nil, fmt.Errorf("drpcctl: descriptor set %s: %v", path, err)
+	}
+	return d, nil
+}
+
+// messageType returns the type of the message named name.
+func (d *descriptors) messageType(name string) (protoreflect.MessageType, error) {
+	if mt, err := protoregistry.GlobalTypes.FindMessageByName(protoreflect.FullName(name)); err == nil {
+		return mt, nil
+	}
+	desc, err := d.files.FindDescriptorByName(protoreflect.FullName(name))
+	if err != nil {
+		return nil, fmt.Errorf("drpcctl: message %s is not found, set -descriptor", name)
+	}
+	md, ok := desc.(protoreflect.MessageDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("drpcctl: %s is not a message", name)
+	}
+	return dynamicpb.NewMessageType(md), nil
+}
+
+// protoMarshaler is a codec.Marshaler of protobuf messages, which
+// unmarshals the messages not compiled in by the descriptors.
+type protoMarshaler struct {
+	descriptors *descriptors
+}
+
+func (m protoMarshaler) Marshal(v interface{}) (string, []byte, error) {
+	msg, ok := v.(proto.Message)
+	if !ok {
+		return "", nil, fmt.Errorf("drpcctl: Marshal %T is not proto.Message", v)
+	}
+	data, err := proto.Marshal(msg)
+	if err != nil {
+		return "", nil, err
+	}
+	return string(msg.ProtoReflect().Descriptor().FullName()), data, nil
+}
+
+func (m protoMarshaler) Unmarshal(name string, data []byte) (interface{}, error) {
+	mt, err := m.descriptors.messageType(name)
+	if err != nil {
+		return nil, err
+	}
+	msg := mt.New().Interface()
+	if err := proto.Unmarshal(data, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// jsonMessage is a JSON value with the name of its type on the wire.
+type jsonMessage struct {
+	name string
+	data json.RawMessage
+}
+
+// jsonMarshaler is a codec.Marshaler of the JSON codec, which keeps
+// the values as raw JSON instead of the registered types.
+type jsonMarshaler struct{}
+
+func (jsonMarshaler) Marshal(v interface{}) (string, []byte, error) {
+	msg, ok := v.(*jsonMessage)
+	if !ok {
+		return "", nil, fmt.Errorf("drpcctl: Marshal %T is not json", v)
+	}
+	return msg.name, msg.data, nil
+}
+
+func (jsonMarshaler) Unmarshal(name string, data []byte) (interface{}, error) {
+	return &jsonMessage{name: name, data: append(json.RawMessage{}, data...)}, nil
+}
+
+var (
+	_ codec.Marshaler = protoMarshaler{}
+	_ codec.Marshaler = jsonMarshaler{}
+)
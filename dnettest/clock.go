@@ -0,0 +1,124 @@
+// Package dnettest provides utilities for testing the sessions of dnet and drpc.
+package dnettest
+
+import (
+	"github.com/yddeng/dnet"
+	"sort"
+	"sync"
+	"time"
+)
+
+// FakeClock is a dnet.Clock whose time only moves by Advance.
+// The timers are fired by Advance in the order of their deadlines.
+//
+//	clock := dnettest.NewFakeClock(time.Now())
+//	client := drpc.NewClient(drpc.WithClock(clock))
+//	...
+//	clock.Advance(drpc.DefaultRPCTimeout) // the pending calls fail with ErrRPCTimeout
+type FakeClock struct {
+	mtx    sync.Mutex
+	now    time.Time
+	seq    int // the order of timers with the same deadline
+	timers map[*fakeTimer]struct{}
+}
+
+// NewFakeClock returns a FakeClock at the time now.
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now, timers: map[*fakeTimer]struct{}{}}
+}
+
+func (c *FakeClock) Now() time.Time {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.now
+}
+
+// AfterFunc calls f after the clock is advanced by d.
+// Unlike the system clock, f is called in the goroutine of Advance.
+func (c *FakeClock) AfterFunc(d time.Duration, f func()) dnet.Timer {
+	t := &fakeTimer{clock: c, f: f}
+	c.mtx.Lock()
+	c.schedule(t, d)
+	c.mtx.Unlock()
+	return t
+}
+
+func (c *FakeClock) schedule(t *fakeTimer, d time.Duration) {
+	c.seq++
+	t.when, t.seq = c.now.Add(d), c.seq
+	c.timers[t] = struct{}{}
+}
+
+// Advance moves the time forward by d, and calls the functions of the timers due.
+// The timers made by the functions are fired too if they are due.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mtx.Lock()
+	end := c.now.Add(d)
+	for {
+		t := c.next(end)
+		if t == nil {
+			break
+		}
+		delete(c.timers, t)
+		if t.when.After(c.now) {
+			c.now = t.when
+		}
+		c.mtx.Unlock()
+		t.f()
+		c.mtx.Lock()
+	}
+	c.now = end
+	c.mtx.Unlock()
+}
+
+// next returns the first timer due before end.
+func (c *FakeClock) next(end time.Time) *fakeTimer {
+	var due []*fakeTimer
+	for t := range c.timers {
+		if !t.when.After(end) {
+			due = append(due, t)
+		}
+	}
+	if len(due) == 0 {
+		return nil
+	}
+	sort.Slice(due, func(i, j int) bool {
+		if due[i].when.Equal(due[j].when) {
+			return due[i].seq < due[j].seq
+		}
+		return due[i].when.Before(due[j].when)
+	})
+	return due[0]
+}
+
+// Timers returns the number of timers waiting.
+func (c *FakeClock) Timers() int {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return len(c.timers)
+}
+
+type fakeTimer struct {
+	clock *FakeClock
+	f     func()
+	when  time.Time
+	seq   int
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mtx.Lock()
+	defer t.clock.mtx.Unlock()
+	_, ok := t.clock.timers[t]
+	delete(t.clock.timers, t)
+	return ok
+}
+
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	t.clock.mtx.Lock()
+	defer t.clock.mtx.Unlock()
+	_, ok := t.clock.timers[t]
+	t.clock.schedule(t, d)
+	return ok
+}
+
+var _ dnet.Clock = (*FakeClock)(nil)
@@ -0,0 +1,196 @@
+package dnettest
+
+import (
+	"github.com/yddeng/dnet"
+	"github.com/yddeng/dnet/drpc"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestFakeClock(t *testing.T) {
+	start := time.Unix(1000, 0)
+	clock := NewFakeClock(start)
+
+	var fired []int
+	clock.AfterFunc(2*time.Second, func() { fired = append(fired, 2) })
+	clock.AfterFunc(time.Second, func() {
+		fired = append(fired, 1)
+		clock.AfterFunc(time.Second/2, func() { fired = append(fired, 15) })
+	})
+	stopped := clock.AfterFunc(time.Second, func() { fired = append(fired, -1) })
+	if !stopped.Stop() || stopped.Stop() {
+		t.Fatal("Stop of a waiting timer")
+	}
+
+	clock.Advance(3 * time.Second)
+	if len(fired) != 3 || fired[0] != 1 || fired[1] != 15 || fired[2] != 2 {
+		t.Fatalf("fired %v", fired)
+	}
+	if !clock.Now().Equal(start.Add(3*time.Second)) || clock.Timers() != 0 {
+		t.Fatalf("now %v timers %d", clock.Now(), clock.Timers())
+	}
+
+	reset := clock.AfterFunc(time.Second, func() { fired = append(fired, 4) })
+	clock.Advance(time.Second / 2)
+	if !reset.Reset(time.Second) {
+		t.Fatal("Reset of a waiting timer")
+	}
+	clock.Advance(time.Second * 3 / 4)
+	if len(fired) != 3 {
+		t.Fatalf("fired %v after Reset", fired)
+	}
+	clock.Advance(time.Second / 4)
+	if len(fired) != 4 {
+		t.Fatalf("fired %v", fired)
+	}
+}
+
+// waitTimers waits for n timers made by another goroutine.
+func waitTimers(t *testing.T, clock *FakeClock, n int) {
+	deadline := time.Now().Add(time.Second)
+	for clock.Timers() < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("timers %d, want %d", clock.Timers(), n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestSessionReadTimeout(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	c1, c2 := Pipe()
+	defer c2.Close()
+
+	events := NewEvents()
+	dnet.NewTCPSession(c1, append(events.Options(),
+		dnet.WithClock(clock),
+		dnet.WithTimeout(10*time.Second, 0))...)
+
+	waitTimers(t, clock, 1)
+	clock.Advance(9 * time.Second)
+	if reason, err := events.WaitClose(10 * time.Millisecond); err == nil {
+		t.Fatalf("closed before the timeout: %v", reason)
+	}
+
+	clock.Advance(time.Second)
+	if reason, err := events.WaitClose(time.Second); err != nil || reason != dnet.ErrReadTimeout {
+		t.Fatalf("closed by %v %v, want %v", reason, err, dnet.ErrReadTimeout)
+	}
+}
+
+type nopChannel struct{}
+
+func (nopChannel) SendRequest(req *drpc.Request) error    { return nil }
+func (nopChannel) SendResponse(resp *drpc.Response) error { return nil }
+
+func TestRPCTimeout(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	client := drpc.NewClient(drpc.WithClock(clock))
+
+	done := make(chan error, 1)
+	if err := client.Go(nopChannel{}, "echo", "hello", 5*time.Second, func(_ interface{}, err error) {
+		done <- err
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	clock.Advance(5*time.Second - time.Millisecond)
+	select {
+	case err := <-done:
+		t.Fatalf("done before the timeout: %v", err)
+	default:
+	}
+
+	clock.Advance(time.Millisecond)
+	select {
+	case err := <-done:
+		if err != drpc.ErrRPCTimeout {
+			t.Fatalf("done by %v, want %v", err, drpc.ErrRPCTimeout)
+		}
+	default:
+		t.Fatal("not done after the timeout")
+	}
+}
+
+// serveSYNACK answers the SYN of DialUDP on a raw socket, then only reads the
+// packets, which are sent to the returned channel by cmd.
+func serveSYNACK(t *testing.T) (string, <-chan byte) {
+	sock, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = sock.Close() })
+	cmds := make(chan byte, 64)
+	go func() {
+		buf := make([]byte, 65536)
+		for {
+			n, addr, err := sock.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			// cmd(1) conv(4) seq(4) una(4) wnd(2), the SYN is 1
+			if n < 15 {
+				continue
+			}
+			if buf[0] == 1 {
+				synack := make([]byte, 15)
+				synack[0] = 2
+				copy(synack[1:5], buf[1:5])
+				synack[14] = 128
+				_, _ = sock.WriteToUDP(synack, addr)
+				continue
+			}
+			select {
+			case cmds <- buf[0]:
+			default:
+			}
+		}
+	}()
+	return sock.LocalAddr().String(), cmds
+}
+
+func TestUDPKeepAlive(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	addr, cmds := serveSYNACK(t)
+	conn, err := dnet.DialUDP(addr, time.Second,
+		dnet.WithUDPKeepAlive(time.Second, 3*time.Second), dnet.WithUDPClock(clock))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	waitTimers(t, clock, 1)
+
+	// the keepalive is sent after KeepAlive on the clock
+	clock.Advance(900 * time.Millisecond)
+	select {
+	case cmd := <-cmds:
+		t.Fatalf("packet %d before the keepalive", cmd)
+	case <-time.After(20 * time.Millisecond):
+	}
+	clock.Advance(100 * time.Millisecond)
+	select {
+	case <-cmds:
+	case <-time.After(time.Second):
+		t.Fatal("the keepalive is not sent")
+	}
+
+	// the link is dead after DeadLinkTimeout on the clock, the peer never answers
+	done := make(chan error, 1)
+	go func() {
+		_, err := conn.Read(make([]byte, 1))
+		done <- err
+	}()
+	for i := 0; i < 25; i++ {
+		clock.Advance(100 * time.Millisecond)
+		time.Sleep(time.Millisecond)
+	}
+	select {
+	case err := <-done:
+		if err != dnet.ErrUDPDeadLink {
+			t.Fatalf("read %v, want %v", err, dnet.ErrUDPDeadLink)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the dead link is not found")
+	}
+}
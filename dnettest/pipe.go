@@ -0,0 +1,130 @@
+package dnettest
+
+import (
+	"errors"
+	"fmt"
+	"github.com/yddeng/dnet"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	ErrAcceptorStopped = errors.New("dnettest: acceptor is stopped. ")
+	ErrDialTimeout     = errors.New("dnettest: dial timeout. ")
+)
+
+// Addr is the address of an in-memory connection.
+type Addr string
+
+func (a Addr) Network() string { return "memory" }
+func (a Addr) String() string  { return string(a) }
+
+// pipeConn is a side of net.Pipe with the addresses.
+type pipeConn struct {
+	net.Conn
+	local, remote net.Addr
+}
+
+func (c *pipeConn) LocalAddr() net.Addr  { return c.local }
+func (c *pipeConn) RemoteAddr() net.Addr { return c.remote }
+
+// Acceptor is a dnet.Acceptor of the connections made by net.Pipe, without the network stack.
+// The connections are dialed by Dial, and handled by the handler of Serve.
+//
+//	acceptor := dnettest.NewAcceptor("echo")
+//	go acceptor.ServeFunc(func(conn net.Conn) {
+//		dnet.NewTCPSession(conn, ...)
+//	})
+//	conn, err := acceptor.Dial(time.Second)
+type Acceptor struct {
+	addr    Addr
+	connCh  chan net.Conn
+	stopCh  chan struct{}
+	once    sync.Once
+	started int32
+	dialN   int32
+}
+
+// NewAcceptor returns an Acceptor whose address is name.
+func NewAcceptor(name string) *Acceptor {
+	return &Acceptor{
+		addr:   Addr(name),
+		connCh: make(chan net.Conn),
+		stopCh: make(chan struct{}),
+	}
+}
+
+// Serve handles the connections dialed until the acceptor is stopped, it returns io.EOF then.
+func (this *Acceptor) Serve(handler dnet.AcceptorHandler) error {
+	if handler == nil {
+		return errors.New("dnettest: Serve handler is nil. ")
+	}
+	if !atomic.CompareAndSwapInt32(&this.started, 0, 1) {
+		return errors.New("dnettest: Serve acceptor is already started. ")
+	}
+
+	for {
+		select {
+		case conn := <-this.connCh:
+			go handler.OnConnection(conn)
+		case <-this.stopCh:
+			return io.EOF
+		}
+	}
+}
+
+// ServeFunc handles the connections dialed by the function.
+func (this *Acceptor) ServeFunc(handler dnet.AcceptorHandlerFunc) error {
+	return this.Serve(handler)
+}
+
+// Stop stops the acceptor, the connections accepted are not closed.
+func (this *Acceptor) Stop() {
+	this.once.Do(func() { close(this.stopCh) })
+}
+
+// Addr returns the address of the acceptor.
+func (this *Acceptor) Addr() net.Addr {
+	return this.addr
+}
+
+// Dial connects to the acceptor, it waits for the acceptor to accept the connection
+// in timeout. The zero timeout means no timeout.
+func (this *Acceptor) Dial(timeout time.Duration) (net.Conn, error) {
+	client := Addr(fmt.Sprintf("%s-client-%d", this.addr, atomic.AddInt32(&this.dialN, 1)))
+	c1, c2 := net.Pipe()
+	conn := &pipeConn{Conn: c1, local: client, remote: this.addr}
+	peer := &pipeConn{Conn: c2, local: this.addr, remote: client}
+
+	var timeoutCh <-chan time.Time
+	if timeout > 0 {
+		t := time.NewTimer(timeout)
+		defer t.Stop()
+		timeoutCh = t.C
+	}
+
+	var err error
+	select {
+	case this.connCh <- peer:
+		return conn, nil
+	case <-this.stopCh:
+		err = ErrAcceptorStopped
+	case <-timeoutCh:
+		err = ErrDialTimeout
+	}
+	_ = c1.Close()
+	_ = c2.Close()
+	return nil, err
+}
+
+// Pipe returns the two sides of an in-memory connection, without an acceptor.
+func Pipe() (net.Conn, net.Conn) {
+	c1, c2 := net.Pipe()
+	a1, a2 := Addr("pipe-1"), Addr("pipe-2")
+	return &pipeConn{Conn: c1, local: a1, remote: a2}, &pipeConn{Conn: c2, local: a2, remote: a1}
+}
+
+var _ dnet.Acceptor = (*Acceptor)(nil)
@@ -0,0 +1,89 @@
+package dnettest
+
+import (
+	"bytes"
+	"github.com/yddeng/dnet"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestAcceptor(t *testing.T) {
+	acceptor := NewAcceptor("echo")
+	served := make(chan error, 1)
+	go func() {
+		served <- acceptor.ServeFunc(func(conn net.Conn) {
+			dnet.NewTCPSession(conn, dnet.WithMessageCallback(func(session dnet.Session, message interface{}) {
+				_ = session.Send(message)
+			}))
+		})
+	}()
+
+	conn, err := acceptor.Dial(time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conn.RemoteAddr().String() != "echo" || conn.LocalAddr().String() != "echo-client-1" {
+		t.Fatalf("addr %s -> %s", conn.LocalAddr(), conn.RemoteAddr())
+	}
+
+	events := NewEvents()
+	session := dnet.NewTCPSession(conn, events.Options()...)
+	for _, data := range [][]byte{[]byte("hello"), []byte("world")} {
+		if err := session.Send(data); err != nil {
+			t.Fatal(err)
+		}
+		msg, err := events.WaitMessage(time.Second)
+		if err != nil || !bytes.Equal(msg.([]byte), data) {
+			t.Fatalf("message %v %v, want %s", msg, err, data)
+		}
+	}
+
+	session.Close(nil)
+	if _, err := events.WaitClose(time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	acceptor.Stop()
+	if err := <-served; err != io.EOF {
+		t.Fatalf("Serve returns %v", err)
+	}
+	if _, err := acceptor.Dial(time.Second); err != ErrAcceptorStopped {
+		t.Fatalf("Dial after Stop returns %v", err)
+	}
+}
+
+func TestDialTimeout(t *testing.T) {
+	acceptor := NewAcceptor("idle")
+	if _, err := acceptor.Dial(10 * time.Millisecond); err != ErrDialTimeout {
+		t.Fatalf("Dial returns %v, want %v", err, ErrDialTimeout)
+	}
+}
+
+func TestRecorder(t *testing.T) {
+	recorder := NewRecorder()
+	go func() {
+		_ = recorder.Send("a")
+		_ = recorder.Send("b")
+		recorder.Close(io.EOF)
+	}()
+
+	for _, want := range []string{"a", "b"} {
+		if msg, err := recorder.Next(time.Second); err != nil || msg != want {
+			t.Fatalf("Next %v %v, want %s", msg, err, want)
+		}
+	}
+	if reason, err := recorder.WaitClose(time.Second); err != nil || reason != io.EOF {
+		t.Fatalf("WaitClose %v %v", reason, err)
+	}
+	if _, err := recorder.Next(10 * time.Millisecond); err != ErrWaitTimeout {
+		t.Fatalf("Next returns %v, want %v", err, ErrWaitTimeout)
+	}
+	if err := recorder.Send("c"); err != dnet.ErrSessionClosed {
+		t.Fatalf("Send after Close returns %v", err)
+	}
+	if sent := recorder.Sent(); len(sent) != 2 {
+		t.Fatalf("Sent %v", sent)
+	}
+}
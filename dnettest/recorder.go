@@ -0,0 +1,193 @@
+package dnettest
+
+import (
+	"errors"
+	"github.com/yddeng/dnet"
+	"net"
+	"sync"
+	"time"
+)
+
+var ErrWaitTimeout = errors.New("dnettest: wait timeout. ")
+
+// Recorder is a dnet.Session which records the messages sent,
+// to test the handlers which reply to a session.
+//
+//	recorder := dnettest.NewRecorder()
+//	handler(recorder, req)
+//	resp, err := recorder.Next(time.Second)
+type Recorder struct {
+	local, remote net.Addr
+
+	mtx     sync.Mutex
+	ctx     interface{}
+	sent    []interface{}
+	next    int
+	notify  chan struct{}
+	closed  bool
+	reason  error
+	closeCh chan struct{}
+}
+
+// NewRecorder returns a Recorder.
+func NewRecorder() *Recorder {
+	return &Recorder{
+		local:   Addr("recorder-local"),
+		remote:  Addr("recorder-remote"),
+		notify:  make(chan struct{}),
+		closeCh: make(chan struct{}),
+	}
+}
+
+func (r *Recorder) NetConn() interface{} { return nil }
+func (r *Recorder) RemoteAddr() net.Addr { return r.remote }
+func (r *Recorder) LocalAddr() net.Addr  { return r.local }
+
+// Send records the message.
+func (r *Recorder) Send(o interface{}) error {
+	if o == nil {
+		return dnet.ErrSendMsgNil
+	}
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	if r.closed {
+		return dnet.ErrSessionClosed
+	}
+	r.sent = append(r.sent, o)
+	close(r.notify)
+	r.notify = make(chan struct{})
+	return nil
+}
+
+func (r *Recorder) SetContext(ctx interface{}) {
+	r.mtx.Lock()
+	r.ctx = ctx
+	r.mtx.Unlock()
+}
+
+func (r *Recorder) Context() interface{} {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	return r.ctx
+}
+
+// Close records the reason, the messages are not sent after it.
+func (r *Recorder) Close(reason error) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	if !r.closed {
+		r.closed, r.reason = true, reason
+		close(r.closeCh)
+	}
+}
+
+func (r *Recorder) IsClosed() bool {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	return r.closed
+}
+
+// Sent returns all the messages sent.
+func (r *Recorder) Sent() []interface{} {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	return append([]interface{}{}, r.sent...)
+}
+
+// Next returns the next message sent, which is not returned by Next before.
+// It waits for the message in timeout.
+func (r *Recorder) Next(timeout time.Duration) (interface{}, error) {
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	for {
+		r.mtx.Lock()
+		if r.next < len(r.sent) {
+			o := r.sent[r.next]
+			r.next++
+			r.mtx.Unlock()
+			return o, nil
+		}
+		notify := r.notify
+		r.mtx.Unlock()
+
+		select {
+		case <-notify:
+		case <-deadline.C:
+			return nil, ErrWaitTimeout
+		}
+	}
+}
+
+// WaitClose waits for the recorder to be closed in timeout, and returns the reason.
+func (r *Recorder) WaitClose(timeout time.Duration) (reason error, err error) {
+	select {
+	case <-r.closeCh:
+		r.mtx.Lock()
+		defer r.mtx.Unlock()
+		return r.reason, nil
+	case <-time.After(timeout):
+		return nil, ErrWaitTimeout
+	}
+}
+
+// Events records the messages received by a session and the close of it,
+// by the callbacks of Options.
+//
+//	events := dnettest.NewEvents()
+//	session := dnet.NewTCPSession(conn, events.Options()...)
+//	msg, err := events.WaitMessage(time.Second)
+type Events struct {
+	msgCh   chan interface{}
+	closeCh chan error
+}
+
+// NewEvents returns an Events.
+func NewEvents() *Events {
+	return &Events{
+		msgCh:   make(chan interface{}, 1024),
+		closeCh: make(chan error, 1),
+	}
+}
+
+// Options returns the message and close callbacks to record the events.
+func (e *Events) Options() []dnet.Option {
+	return []dnet.Option{
+		dnet.WithMessageCallback(e.OnMessage),
+		dnet.WithCloseCallback(e.OnClose),
+	}
+}
+
+// OnMessage records a message. It is the message callback of Options.
+func (e *Events) OnMessage(session dnet.Session, message interface{}) {
+	e.msgCh <- message
+}
+
+// OnClose records the close. It is the close callback of Options.
+func (e *Events) OnClose(session dnet.Session, reason error) {
+	select {
+	case e.closeCh <- reason:
+	default:
+	}
+}
+
+// WaitMessage waits for the next message in timeout.
+func (e *Events) WaitMessage(timeout time.Duration) (interface{}, error) {
+	select {
+	case msg := <-e.msgCh:
+		return msg, nil
+	case <-time.After(timeout):
+		return nil, ErrWaitTimeout
+	}
+}
+
+// WaitClose waits for the close in timeout, and returns the reason.
+func (e *Events) WaitClose(timeout time.Duration) (reason error, err error) {
+	select {
+	case reason = <-e.closeCh:
+		return reason, nil
+	case <-time.After(timeout):
+		return nil, ErrWaitTimeout
+	}
+}
+
+var _ dnet.Session = (*Recorder)(nil)
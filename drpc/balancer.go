@@ -0,0 +1,198 @@
+package drpc
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+var ErrNoAvailableClient = NewError(CodeUnavailable, "drpc: no available client. ")
+
+// Balancer spreads the calls over a set of ChannelClient, one per backend,
+// picking one by the Policy for each call.
+// A client is removed from the set when it is closed.
+//
+//	balancer := drpc.NewBalancer(drpc.LeastPending())
+//	balancer.Add(drpc.NewChannelClient(session))
+//	ret, err := balancer.Call(method, arg, drpc.DefaultRPCTimeout, drpc.WithIdempotent())
+type Balancer struct {
+	policy  Policy
+	mtx     sync.Mutex
+	clients []*ChannelClient // copy on write
+}
+
+// NewBalancer returns a Balancer which picks the clients by policy.
+// policy is RoundRobin if it is nil.
+func NewBalancer(policy Policy) *Balancer {
+	if policy == nil {
+		policy = RoundRobin()
+	}
+	return &Balancer{policy: policy}
+}
+
+// Add adds the client to the set. It is removed when it is closed.
+func (b *Balancer) Add(c *ChannelClient) {
+	b.mtx.Lock()
+	for _, v := range b.clients {
+		if v == c {
+			b.mtx.Unlock()
+			return
+		}
+	}
+	clients := make([]*ChannelClient, 0, len(b.clients)+1)
+	clients = append(clients, b.clients...)
+	clients = append(clients, c)
+	b.clients = clients
+	b.policy.Update(clients)
+	b.mtx.Unlock()
+
+	c.OnClose(func(reason error) {
+		b.Remove(c)
+	})
+}
+
+// Remove removes the client from the set, the client is not closed.
+func (b *Balancer) Remove(c *ChannelClient) bool {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	for i, v := range b.clients {
+		if v == c {
+			clients := make([]*ChannelClient, 0, len(b.clients)-1)
+			clients = append(clients, b.clients[:i]...)
+			clients = append(clients, b.clients[i+1:]...)
+			b.clients = clients
+			b.policy.Update(clients)
+			return true
+		}
+	}
+	return false
+}
+
+// Clients returns the clients in the set.
+func (b *Balancer) Clients() []*ChannelClient {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	return b.clients
+}
+
+// Len returns the number of clients in the set.
+func (b *Balancer) Len() int {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	return len(b.clients)
+}
+
+// pick returns a client which is open and not tried.
+func (b *Balancer) pick(key string, tried map[*ChannelClient]bool) *ChannelClient {
+	return b.policy.Pick(key, func(c *ChannelClient) bool {
+		return tried[c] || c.IsClosed()
+	})
+}
+
+// Call invokes the method synchronous on a client of the set.
+func (b *Balancer) Call(method string, data interface{}, timeout time.Duration, options ...CallOption) (result interface{}, err error) {
+	waitC := make(chan struct{})
+	f := func(ret_ interface{}, err_ error) {
+		result = ret_
+		err = err_
+		close(waitC)
+	}
+	if err := b.Go(method, data, timeout, f, options...); err != nil {
+		return nil, err
+	}
+	<-waitC
+	return
+}
+
+// Go invokes the method asynchronously on a client of the set.
+// A call which is not sent because the client is closed or its circuit is open
+// is sent on another client.
+// An idempotent call which fails with CodeUnavailable is retried on another client,
+// each try waits for the full timeout. The retry and hedging policies of the call
+// are applied by the client picked for each try.
+func (b *Balancer) Go(method string, data interface{}, timeout time.Duration, callback func(interface{}, error), options ...CallOption) error {
+	if callback == nil {
+		return fmt.Errorf("drpc: Go callback == nil")
+	}
+
+	call := &balancerCall{
+		balancer: b,
+		method:   method,
+		data:     data,
+		timeout:  timeout,
+		callback: callback,
+		opts:     loadCallOptions(options...),
+		options:  options,
+		tried:    map[*ChannelClient]bool{},
+	}
+	return call.do()
+}
+
+// Notify sends a notification to the method on a client of the set.
+func (b *Balancer) Notify(method string, data interface{}, options ...CallOption) error {
+	opts := loadCallOptions(options...)
+	tried := map[*ChannelClient]bool{}
+	for {
+		c := b.pick(opts.HashKey, tried)
+		if c == nil {
+			return ErrNoAvailableClient
+		}
+		tried[c] = true
+		if err := c.Notify(method, data); err != ErrConnectionClosed {
+			return err
+		}
+	}
+}
+
+// NewStream opens a stream to the method on a client of the set.
+// The stream is not moved to another client once it is opened.
+func (b *Balancer) NewStream(method string, meta Metadata, options ...CallOption) (*Stream, error) {
+	opts := loadCallOptions(options...)
+	tried := map[*ChannelClient]bool{}
+	for {
+		c := b.pick(opts.HashKey, tried)
+		if c == nil {
+			return nil, ErrNoAvailableClient
+		}
+		tried[c] = true
+		if stream, err := c.NewStream(method, meta); err != ErrConnectionClosed {
+			return stream, err
+		}
+	}
+}
+
+// balancerCall is a call of the Balancer, it may be tried on several clients in turn.
+type balancerCall struct {
+	balancer *Balancer
+	method   string
+	data     interface{}
+	timeout  time.Duration
+	callback func(interface{}, error)
+	opts     *CallOptions
+	options  []CallOption
+	tried    map[*ChannelClient]bool // only used by one try at a time
+}
+
+// do sends the call on a client not tried.
+func (call *balancerCall) do() error {
+	for {
+		c := call.balancer.pick(call.opts.HashKey, call.tried)
+		if c == nil {
+			return ErrNoAvailableClient
+		}
+		call.tried[c] = true
+		// the request is not sent, it is safe to send it again.
+		if err := c.Go(call.method, call.data, call.timeout, call.onResult, call.options...); err != ErrConnectionClosed && err != ErrCircuitOpen {
+			return err
+		}
+	}
+}
+
+func (call *balancerCall) onResult(result interface{}, err error) {
+	if err != nil && call.opts.Idempotent && CodeOf(err) == CodeUnavailable {
+		if call.do() == nil {
+			return
+		}
+	}
+	call.callback(result, err)
+}
@@ -0,0 +1,143 @@
+package drpc_test
+
+import (
+	"github.com/yddeng/dnet"
+	"github.com/yddeng/dnet/dnettest"
+	"github.com/yddeng/dnet/drpc"
+	"net"
+	"testing"
+	"time"
+)
+
+// startBackend serves the method "id" which replies name, or CodeUnavailable if name is "down".
+func startBackend(t *testing.T, name string) *dnettest.Acceptor {
+	server := drpc.NewServer()
+	server.Register("id", func(replier *drpc.Replier, req interface{}) {
+		if name == "down" {
+			_ = replier.Reply(nil, drpc.NewError(drpc.CodeUnavailable, "drpc: draining"))
+			return
+		}
+		_ = replier.Reply(name, nil)
+	})
+	server.Register("wait", func(replier *drpc.Replier, req interface{}) {})
+
+	acceptor := dnettest.NewAcceptor(name)
+	go acceptor.ServeFunc(func(conn net.Conn) {
+		ep := drpc.NewEndpoint(server)
+		ep.Attach(dnet.NewTCPSession(conn, append(ep.Options(), dnet.WithCodec(newCodec()))...))
+	})
+	t.Cleanup(acceptor.Stop)
+	return acceptor
+}
+
+// newBalancer returns a Balancer of the clients connected to the backends in order.
+func newBalancer(t *testing.T, policy drpc.Policy, names ...string) *drpc.Balancer {
+	balancer := drpc.NewBalancer(policy)
+	for _, name := range names {
+		conn, err := startBackend(t, name).Dial(time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		client := newChannelClient(conn)
+		t.Cleanup(func() { client.Session().Close(nil) })
+		balancer.Add(client)
+	}
+	return balancer
+}
+
+func callID(t *testing.T, balancer *drpc.Balancer, options ...drpc.CallOption) string {
+	t.Helper()
+	ret, err := balancer.Call("id", "", time.Second, options...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ret.(string)
+}
+
+func TestBalancerRoundRobin(t *testing.T) {
+	balancer := newBalancer(t, drpc.RoundRobin(), "a", "b", "c")
+	for i := 0; i < 6; i++ {
+		if id, want := callID(t, balancer), []string{"a", "b", "c"}[i%3]; id != want {
+			t.Fatalf("call %d on %s, want %s", i, id, want)
+		}
+	}
+}
+
+func TestBalancerLeastPending(t *testing.T) {
+	balancer := newBalancer(t, drpc.LeastPending(), "a", "b")
+	// a call waits on a
+	a := balancer.Clients()[0]
+	if err := a.Go("wait", "", 10*time.Second, func(interface{}, error) {}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if id := callID(t, balancer); id != "b" {
+			t.Fatalf("call %d on %s", i, id)
+		}
+	}
+}
+
+func TestBalancerRandom(t *testing.T) {
+	balancer := newBalancer(t, drpc.Random(), "a", "b", "c")
+	seen := map[string]int{}
+	for i := 0; i < 60; i++ {
+		seen[callID(t, balancer)]++
+	}
+	if len(seen) != 3 {
+		t.Fatalf("calls on %v", seen)
+	}
+}
+
+func TestBalancerConsistentHash(t *testing.T) {
+	balancer := newBalancer(t, drpc.ConsistentHash(0), "a", "b", "c")
+	for _, key := range []string{"user-1", "user-2", "user-3"} {
+		id := callID(t, balancer, drpc.WithHashKey(key))
+		for i := 0; i < 5; i++ {
+			if got := callID(t, balancer, drpc.WithHashKey(key)); got != id {
+				t.Fatalf("key %s on %s and %s", key, id, got)
+			}
+		}
+	}
+
+	// the calls of the removed client move to the others, the others stay
+	clients := balancer.Clients()
+	keys := map[string]string{}
+	for i := 0; i < 30; i++ {
+		key := string(rune('a' + i))
+		keys[key] = callID(t, balancer, drpc.WithHashKey(key))
+	}
+	balancer.Remove(clients[0])
+	for key, id := range keys {
+		got := callID(t, balancer, drpc.WithHashKey(key))
+		if got == "a" || (id != "a" && got != id) {
+			t.Fatalf("key %s moved from %s to %s", key, id, got)
+		}
+	}
+}
+
+func TestBalancerFailover(t *testing.T) {
+	balancer := newBalancer(t, drpc.RoundRobin(), "down", "b")
+
+	// the idempotent call is tried on the next client
+	if id := callID(t, balancer, drpc.WithIdempotent()); id != "b" {
+		t.Fatalf("idempotent call on %s", id)
+	}
+	if _, err := balancer.Call("id", "", time.Second); drpc.CodeOf(err) != drpc.CodeUnavailable {
+		t.Fatalf("call on the unavailable client: %v", err)
+	}
+
+	// the closed clients are removed
+	clients := balancer.Clients()
+	clients[1].Session().Close(nil)
+	deadline := time.Now().Add(time.Second)
+	for balancer.Len() != 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("the closed client is not removed, len %d", balancer.Len())
+		}
+		time.Sleep(time.Millisecond)
+	}
+	clients[0].Close()
+	if _, err := balancer.Call("id", "", time.Second); err != drpc.ErrNoAvailableClient {
+		t.Fatalf("call without client: %v", err)
+	}
+}
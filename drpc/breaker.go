@@ -0,0 +1,237 @@
+package drpc
+
+import (
+	"github.com/yddeng/dnet"
+	"sync"
+	"time"
+)
+
+var ErrCircuitOpen = NewError(CodeUnavailable, "drpc: circuit breaker is open. ")
+
+type BreakerState int
+
+const (
+	StateClosed   BreakerState = iota // calls pass, failures are counted
+	StateOpen                         // calls fail with ErrCircuitOpen
+	StateHalfOpen                     // a few probe calls pass
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case StateClosed:
+		return "closed"
+	case StateOpen:
+		return "open"
+	case StateHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+// CircuitBreaker fails the calls fast with ErrCircuitOpen while a backend is degraded.
+// It keeps a circuit per channel and method, which trips open by the ratio of failures
+// or the timeouts in a row, half-opens after the open timeout to let a few probe calls
+// pass, and closes when all of them succeed.
+// The channels must be comparable values, such as pointers.
+//
+//	breaker := drpc.NewCircuitBreaker(
+//		drpc.WithBreakerFailureRatio(0.5, 20),
+//		drpc.WithBreakerConsecutiveTimeouts(5))
+//	client := drpc.NewClient(drpc.WithClientInterceptor(breaker.Interceptor()))
+type CircuitBreaker struct {
+	opts     *BreakerOptions
+	circuits sync.Map //map[circuitKey]*circuit
+}
+
+type circuitKey struct {
+	channel RPCChannel
+	method  string
+}
+
+// NewCircuitBreaker returns a CircuitBreaker with options.
+func NewCircuitBreaker(options ...BreakerOption) *CircuitBreaker {
+	opts := loadBreakerOptions(options...)
+	if opts.Window <= 0 {
+		opts.Window = 10 * time.Second
+	}
+	if opts.OpenTimeout <= 0 {
+		opts.OpenTimeout = 5 * time.Second
+	}
+	if opts.HalfOpenRequests <= 0 {
+		opts.HalfOpenRequests = 1
+	}
+	if opts.Clock == nil {
+		opts.Clock = dnet.SystemClock
+	}
+	return &CircuitBreaker{opts: opts}
+}
+
+// Interceptor returns the ClientInterceptor which applies the breaker to the calls.
+// The call fails with ErrCircuitOpen without being sent if the circuit is open.
+func (cb *CircuitBreaker) Interceptor() ClientInterceptor {
+	return func(channel RPCChannel, req *Request, timeout time.Duration, callback func(interface{}, error), invoker Invoker) error {
+		c := cb.circuit(channel, req.Method)
+		gen, ok := cb.allow(c)
+		if !ok {
+			return ErrCircuitOpen
+		}
+
+		err := invoker(channel, req, timeout, func(result interface{}, err error) {
+			cb.record(c, gen, err)
+			callback(result, err)
+		})
+		if err != nil {
+			// the call is not sent, such as by a closed session, it is not a success
+			cb.count(c, gen, true, false)
+		}
+		return err
+	}
+}
+
+// State returns the state of the circuit of the channel and method.
+func (cb *CircuitBreaker) State(channel RPCChannel, method string) BreakerState {
+	v, ok := cb.circuits.Load(circuitKey{channel: channel, method: method})
+	if !ok {
+		return StateClosed
+	}
+	c := v.(*circuit)
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.state
+}
+
+// Forget drops the circuits of the channel, it should be called when the channel is closed.
+func (cb *CircuitBreaker) Forget(channel RPCChannel) {
+	cb.circuits.Range(func(key, _ interface{}) bool {
+		if key.(circuitKey).channel == channel {
+			cb.circuits.Delete(key)
+		}
+		return true
+	})
+}
+
+func (cb *CircuitBreaker) circuit(channel RPCChannel, method string) *circuit {
+	key := circuitKey{channel: channel, method: method}
+	if v, ok := cb.circuits.Load(key); ok {
+		return v.(*circuit)
+	}
+	v, _ := cb.circuits.LoadOrStore(key, &circuit{key: key, windowStart: cb.opts.Clock.Now()})
+	return v.(*circuit)
+}
+
+// circuit is the state of a channel and method.
+type circuit struct {
+	key         circuitKey
+	mtx         sync.Mutex
+	state       BreakerState
+	gen         uint64 // increased by each state change, the results of former states are dropped
+	windowStart time.Time
+	requests    int
+	failures    int
+	timeouts    int // in a row
+	openedAt    time.Time
+	probes      int // probe calls passed in the half-open state
+	successes   int // probe calls succeeded
+}
+
+// allow reports whether a call can pass, with the generation of the state it passes in.
+func (cb *CircuitBreaker) allow(c *circuit) (uint64, bool) {
+	c.mtx.Lock()
+	now := cb.opts.Clock.Now()
+	var from BreakerState
+	changed := false
+	if c.state == StateOpen && now.Sub(c.openedAt) >= cb.opts.OpenTimeout {
+		from, changed = c.state, true
+		c.setState(StateHalfOpen, now)
+	}
+
+	pass := true
+	switch c.state {
+	case StateClosed:
+		if now.Sub(c.windowStart) >= cb.opts.Window {
+			c.windowStart = now
+			c.requests, c.failures = 0, 0
+		}
+	case StateOpen:
+		pass = false
+	case StateHalfOpen:
+		if c.probes < cb.opts.HalfOpenRequests {
+			c.probes++
+		} else {
+			pass = false
+		}
+	}
+	gen := c.gen
+	c.mtx.Unlock()
+
+	if changed {
+		cb.notify(c, from, StateHalfOpen)
+	}
+	return gen, pass
+}
+
+// record counts the result of a call passed in the generation gen.
+func (cb *CircuitBreaker) record(c *circuit, gen uint64, err error) {
+	failure := err != nil && codeIn(CodeOf(err), cb.opts.FailureCodes,
+		CodeUnavailable, CodeDeadlineExceeded, CodeResourceExhausted, CodeInternal)
+	cb.count(c, gen, failure, CodeOf(err) == CodeDeadlineExceeded)
+}
+
+// count counts a call passed in the generation gen, which fails or times out.
+func (cb *CircuitBreaker) count(c *circuit, gen uint64, failure, timeout bool) {
+	c.mtx.Lock()
+	if c.gen != gen {
+		c.mtx.Unlock()
+		return
+	}
+	from := c.state
+	now := cb.opts.Clock.Now()
+	switch c.state {
+	case StateClosed:
+		c.requests++
+		if failure {
+			c.failures++
+		}
+		if timeout {
+			c.timeouts++
+		} else {
+			c.timeouts = 0
+		}
+		if (cb.opts.ConsecutiveTimeouts > 0 && c.timeouts >= cb.opts.ConsecutiveTimeouts) ||
+			(cb.opts.FailureRatio > 0 && c.requests >= cb.opts.MinRequests &&
+				float64(c.failures) >= cb.opts.FailureRatio*float64(c.requests)) {
+			c.setState(StateOpen, now)
+		}
+	case StateHalfOpen:
+		if failure {
+			c.setState(StateOpen, now)
+		} else if c.successes++; c.successes >= cb.opts.HalfOpenRequests {
+			c.setState(StateClosed, now)
+		}
+	}
+	to := c.state
+	c.mtx.Unlock()
+
+	if from != to {
+		cb.notify(c, from, to)
+	}
+}
+
+func (cb *CircuitBreaker) notify(c *circuit, from, to BreakerState) {
+	if cb.opts.OnStateChange != nil {
+		cb.opts.OnStateChange(c.key.channel, c.key.method, from, to)
+	}
+}
+
+// setState changes the state and resets the counts, it must be called with mtx held.
+func (c *circuit) setState(state BreakerState, now time.Time) {
+	c.state = state
+	c.gen++
+	c.windowStart = now
+	c.requests, c.failures, c.timeouts = 0, 0, 0
+	c.probes, c.successes = 0, 0
+	if state == StateOpen {
+		c.openedAt = now
+	}
+}
@@ -0,0 +1,182 @@
+package drpc
+
+import (
+	"github.com/yddeng/dnet"
+	"github.com/yddeng/dnet/dnettest"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// breakChannel fails to send the requests while broken is set.
+type breakChannel struct {
+	StreamChannel
+	broken int32
+}
+
+func (c *breakChannel) SendRequest(req *Request) error {
+	if atomic.LoadInt32(&c.broken) == 1 {
+		return dnet.ErrSessionClosed
+	}
+	return c.StreamChannel.SendRequest(req)
+}
+
+type stateRecorder struct {
+	mtx     sync.Mutex
+	changes []string
+}
+
+func (r *stateRecorder) onChange(channel RPCChannel, method string, from, to BreakerState) {
+	r.mtx.Lock()
+	r.changes = append(r.changes, from.String()+"->"+to.String())
+	r.mtx.Unlock()
+}
+
+func (r *stateRecorder) get() []string {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	return append([]string{}, r.changes...)
+}
+
+func TestBreaker(t *testing.T) {
+	var fail int32 = 1
+	var calls int32
+	server := NewServer()
+	server.Register("m", func(replier *Replier, req interface{}) {
+		atomic.AddInt32(&calls, 1)
+		if atomic.LoadInt32(&fail) == 1 {
+			_ = replier.Reply(nil, NewError(CodeUnavailable, "drpc: down"))
+			return
+		}
+		_ = replier.Reply("ok", nil)
+	})
+
+	clock := dnettest.NewFakeClock(time.Now())
+	states := &stateRecorder{}
+	breaker := NewCircuitBreaker(WithBreakerFailureRatio(0.5, 4), WithBreakerOpenTimeout(5*time.Second),
+		WithBreakerHalfOpenRequests(2), WithBreakerClock(clock), WithBreakerStateChange(states.onChange))
+	client := NewClient(WithClock(dnet.SystemClock), WithClientInterceptor(breaker.Interceptor()))
+	channel := streamPipe(t, server, client)
+
+	// closed -> open
+	for i := 0; i < 4; i++ {
+		if _, err := client.Call(channel, "m", 1, time.Second); CodeOf(err) != CodeUnavailable {
+			t.Fatalf("call %v", err)
+		}
+	}
+	if state := breaker.State(channel, "m"); state != StateOpen {
+		t.Fatalf("state %s", state)
+	}
+	if _, err := client.Call(channel, "m", 1, time.Second); err != ErrCircuitOpen {
+		t.Fatalf("call on the open circuit: %v", err)
+	}
+	if n := atomic.LoadInt32(&calls); n != 4 {
+		t.Fatalf("calls %d", n)
+	}
+
+	// open -> half-open, the probe fails -> open
+	clock.Advance(5 * time.Second)
+	if _, err := client.Call(channel, "m", 1, time.Second); CodeOf(err) != CodeUnavailable || err == ErrCircuitOpen {
+		t.Fatalf("probe %v", err)
+	}
+	if state := breaker.State(channel, "m"); state != StateOpen {
+		t.Fatalf("state %s after the probe failed", state)
+	}
+
+	// open -> half-open, all probes succeed -> closed
+	clock.Advance(5 * time.Second)
+	atomic.StoreInt32(&fail, 0)
+	for i := 0; i < 2; i++ {
+		if ret, err := client.Call(channel, "m", 1, time.Second); ret != "ok" || err != nil {
+			t.Fatalf("probe %v %v", ret, err)
+		}
+	}
+	if state := breaker.State(channel, "m"); state != StateClosed {
+		t.Fatalf("state %s after the probes succeeded", state)
+	}
+
+	want := []string{"closed->open", "open->half-open", "half-open->open", "open->half-open", "half-open->closed"}
+	if changes := states.get(); !reflect.DeepEqual(changes, want) {
+		t.Fatalf("changes %v, want %v", changes, want)
+	}
+}
+
+func TestBreakerHalfOpenRequests(t *testing.T) {
+	server := NewServer(WithExecutor(GoroutineExecutor()))
+	block := make(chan struct{})
+	server.Register("m", func(replier *Replier, req interface{}) {
+		<-block
+		_ = replier.Reply("ok", nil)
+	})
+	clock := dnettest.NewFakeClock(time.Now())
+	breaker := NewCircuitBreaker(WithBreakerConsecutiveTimeouts(1), WithBreakerOpenTimeout(time.Second), WithBreakerClock(clock))
+	client := NewClient(WithClock(clock), WithClientInterceptor(breaker.Interceptor()))
+	channel := streamPipe(t, server, client)
+
+	// a timeout trips the circuit
+	done := make(chan error, 2)
+	_ = client.Go(channel, "m", 1, time.Second, func(_ interface{}, err error) { done <- err })
+	clock.Advance(time.Second)
+	if err := <-done; err != ErrRPCTimeout {
+		t.Fatalf("call %v", err)
+	}
+	if state := breaker.State(channel, "m"); state != StateOpen {
+		t.Fatalf("state %s", state)
+	}
+
+	// only one probe passes in the half-open state
+	clock.Advance(time.Second)
+	if err := client.Go(channel, "m", 1, time.Minute, func(_ interface{}, err error) { done <- err }); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Go(channel, "m", 1, time.Minute, func(_ interface{}, err error) {}); err != ErrCircuitOpen {
+		t.Fatalf("the second probe: %v", err)
+	}
+	close(block)
+	if err := <-done; err != nil {
+		t.Fatalf("probe %v", err)
+	}
+	if state := breaker.State(channel, "m"); state != StateClosed {
+		t.Fatalf("state %s", state)
+	}
+}
+
+// a probe which is not sent does not close the circuit
+func TestBreakerSendFailure(t *testing.T) {
+	server := NewServer()
+	server.Register("m", func(replier *Replier, req interface{}) {
+		_ = replier.Reply(nil, NewError(CodeUnavailable, "drpc: down"))
+	})
+	clock := dnettest.NewFakeClock(time.Now())
+	breaker := NewCircuitBreaker(WithBreakerFailureRatio(1, 1), WithBreakerOpenTimeout(time.Second), WithBreakerClock(clock))
+	client := NewClient(WithClock(dnet.SystemClock), WithClientInterceptor(breaker.Interceptor()))
+	channel := &breakChannel{StreamChannel: streamPipe(t, server, client)}
+
+	if _, err := client.Call(channel, "m", 1, time.Second); CodeOf(err) != CodeUnavailable {
+		t.Fatalf("call %v", err)
+	}
+	if state := breaker.State(channel, "m"); state != StateOpen {
+		t.Fatalf("state %s", state)
+	}
+
+	clock.Advance(time.Second)
+	atomic.StoreInt32(&channel.broken, 1)
+	if _, err := client.Call(channel, "m", 1, time.Second); err != dnet.ErrSessionClosed {
+		t.Fatalf("probe %v", err)
+	}
+	if state := breaker.State(channel, "m"); state != StateOpen {
+		t.Fatalf("state %s after the probe is not sent", state)
+	}
+
+	// the send failures are counted in the closed state
+	breaker = NewCircuitBreaker(WithBreakerFailureRatio(1, 2), WithBreakerClock(clock))
+	client = NewClient(WithClock(dnet.SystemClock), WithClientInterceptor(breaker.Interceptor()))
+	for i := 0; i < 2; i++ {
+		_, _ = client.Call(channel, "m", 1, time.Second)
+	}
+	if state := breaker.State(channel, "m"); state != StateOpen {
+		t.Fatalf("state %s after the send failures", state)
+	}
+}
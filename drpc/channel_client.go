@@ -0,0 +1,159 @@
+package drpc
+
+import (
+	"github.com/yddeng/dnet"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var ErrConnectionClosed = NewError(CodeUnavailable, "drpc: connection closed. ")
+
+// ChannelClient is a Client bound to a dnet.Session.
+// The calls waiting on the session fail with ErrConnectionClosed
+// as soon as the session is closed, rather than wait for the timeout.
+//
+//	var client *drpc.ChannelClient
+//	session := dnet.NewTCPSession(conn,
+//		dnet.WithCodec(codec),
+//		dnet.WithMessageCallback(func(session dnet.Session, message interface{}) {
+//			if resp, ok := message.(*drpc.Response); ok {
+//				_ = client.OnRPCResponse(resp)
+//			}
+//		}),
+//		dnet.WithCloseCallback(func(session dnet.Session, reason error) {
+//			client.OnSessionClose(reason)
+//		}))
+//	client = drpc.NewChannelClient(session)
+type ChannelClient struct {
+	client  *Client
+	session dnet.Session
+	channel StreamChannel
+	closed  int32
+	mtx     sync.Mutex
+	onClose []func(reason error)
+}
+
+// NewChannelClient returns a ChannelClient which sends the calls by session.
+func NewChannelClient(session dnet.Session, options ...ClientOption) *ChannelClient {
+	return &ChannelClient{
+		client:  NewClient(options...),
+		session: session,
+		channel: NewSessionChannel(session),
+	}
+}
+
+// Session returns the session bound.
+func (c *ChannelClient) Session() dnet.Session {
+	return c.session
+}
+
+// Call invokes the method synchronous on the session.
+func (c *ChannelClient) Call(method string, data interface{}, timeout time.Duration, options ...CallOption) (result interface{}, err error) {
+	waitC := make(chan struct{})
+	f := func(ret_ interface{}, err_ error) {
+		result = ret_
+		err = err_
+		close(waitC)
+	}
+	if err := c.Go(method, data, timeout, f, options...); err != nil {
+		return nil, err
+	}
+	<-waitC
+	return
+}
+
+// Go invokes the method asynchronously on the session.
+func (c *ChannelClient) Go(method string, data interface{}, timeout time.Duration, callback func(interface{}, error), options ...CallOption) error {
+	if c.IsClosed() {
+		return ErrConnectionClosed
+	}
+	if err := c.client.Go(c.channel, method, data, timeout, callback, options...); err != nil {
+		if err == dnet.ErrSessionClosed {
+			c.OnSessionClose(err)
+			return ErrConnectionClosed
+		}
+		return err
+	}
+	if c.IsClosed() {
+		// closed while sending
+		c.client.failAll(ErrConnectionClosed)
+	}
+	return nil
+}
+
+// Notify sends a notification to the method on the session.
+func (c *ChannelClient) Notify(method string, data interface{}) error {
+	if c.IsClosed() {
+		return ErrConnectionClosed
+	}
+	return c.client.Notify(c.channel, method, data)
+}
+
+// NewStream opens a stream to the method on the session.
+func (c *ChannelClient) NewStream(method string, meta Metadata) (*Stream, error) {
+	if c.IsClosed() {
+		return nil, ErrConnectionClosed
+	}
+	return c.client.NewStream(c.channel, method, meta)
+}
+
+// OnRPCResponse
+func (c *ChannelClient) OnRPCResponse(resp *Response) error {
+	return c.client.OnRPCResponse(resp)
+}
+
+// OnStreamFrame
+func (c *ChannelClient) OnStreamFrame(frame *StreamFrame) error {
+	return c.client.OnStreamFrame(frame)
+}
+
+// Pending returns the number of calls waiting for the response.
+func (c *ChannelClient) Pending() int {
+	return c.client.Pending()
+}
+
+// OnSessionClose should be called by the CloseCallback of session.
+// It closes the ChannelClient.
+func (c *ChannelClient) OnSessionClose(reason error) {
+	c.close(reason)
+}
+
+// Close fails all pending calls with ErrConnectionClosed, and the later calls
+// return ErrConnectionClosed. The session is not closed.
+func (c *ChannelClient) Close() {
+	c.close(nil)
+}
+
+func (c *ChannelClient) close(reason error) {
+	if !atomic.CompareAndSwapInt32(&c.closed, 0, 1) {
+		return
+	}
+	c.client.close(ErrConnectionClosed)
+
+	c.mtx.Lock()
+	onClose := c.onClose
+	c.onClose = nil
+	c.mtx.Unlock()
+	for _, f := range onClose {
+		f(reason)
+	}
+}
+
+// IsClosed returns has it been closed
+func (c *ChannelClient) IsClosed() bool {
+	return atomic.LoadInt32(&c.closed) == 1
+}
+
+// OnClose adds a function which will be called when the ChannelClient is closed.
+// f is called at once if it is already closed.
+func (c *ChannelClient) OnClose(f func(reason error)) {
+	c.mtx.Lock()
+	if !c.IsClosed() {
+		c.onClose = append(c.onClose, f)
+		c.mtx.Unlock()
+		return
+	}
+	c.mtx.Unlock()
+	f(nil)
+}
@@ -0,0 +1,168 @@
+package drpc_test
+
+import (
+	"github.com/yddeng/dnet"
+	"github.com/yddeng/dnet/dnettest"
+	"github.com/yddeng/dnet/drpc"
+	"github.com/yddeng/dnet/drpc/codec"
+	"net"
+	"testing"
+	"time"
+)
+
+var types = codec.NewRegistry()
+
+func init() {
+	types.RegisterName("string", "")
+	types.RegisterName("int", 0)
+}
+
+func newCodec() dnet.Codec {
+	return codec.NewCodec(codec.NewJSONMarshaler(types))
+}
+
+// serveEndpoint serves server on a side of a dnettest pipe, and returns the other side.
+func serveEndpoint(t *testing.T, server *drpc.Server) (conn net.Conn, ep *drpc.Endpoint) {
+	c, s := dnettest.Pipe()
+	ep = drpc.NewEndpoint(server, drpc.WithClock(dnet.SystemClock))
+	session := dnet.NewTCPSession(s, append(ep.Options(), dnet.WithCodec(newCodec()))...)
+	ep.Attach(session)
+	t.Cleanup(func() { session.Close(nil) })
+	return c, ep
+}
+
+func newEchoServer() *drpc.Server {
+	server := drpc.NewServer()
+	server.Register("echo", func(replier *drpc.Replier, req interface{}) {
+		_ = replier.Reply(req, nil)
+	})
+	server.Register("wait", func(replier *drpc.Replier, req interface{}) {})
+	return server
+}
+
+func newChannelClient(conn net.Conn) *drpc.ChannelClient {
+	var client *drpc.ChannelClient
+	session := dnet.NewTCPSession(conn,
+		dnet.WithCodec(newCodec()),
+		dnet.WithMessageCallback(func(session dnet.Session, message interface{}) {
+			if resp, ok := message.(*drpc.Response); ok {
+				_ = client.OnRPCResponse(resp)
+			}
+		}),
+		dnet.WithCloseCallback(func(session dnet.Session, reason error) {
+			client.OnSessionClose(reason)
+		}))
+	client = drpc.NewChannelClient(session, drpc.WithClock(dnet.SystemClock))
+	return client
+}
+
+func TestChannelClient(t *testing.T) {
+	conn, ep := serveEndpoint(t, newEchoServer())
+	client := newChannelClient(conn)
+	defer client.Session().Close(nil)
+
+	if ret, err := client.Call("echo", "hello", time.Second); ret != "hello" || err != nil {
+		t.Fatalf("call %v %v", ret, err)
+	}
+
+	closed := make(chan error, 1)
+	client.OnClose(func(reason error) { closed <- reason })
+	done := make(chan error, 1)
+	if err := client.Go("wait", "hello", 10*time.Second, func(_ interface{}, err error) {
+		done <- err
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if n := client.Pending(); n != 1 {
+		t.Fatalf("pending %d", n)
+	}
+
+	// the call fails as soon as the session is closed by the peer
+	ep.Session().Close(nil)
+	select {
+	case err := <-done:
+		if err != drpc.ErrConnectionClosed {
+			t.Fatalf("call done by %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the call waits after the session is closed")
+	}
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("OnClose is not called")
+	}
+	if !client.IsClosed() || client.Pending() != 0 {
+		t.Fatalf("closed %v pending %d", client.IsClosed(), client.Pending())
+	}
+	if _, err := client.Call("echo", "hello", time.Second); err != drpc.ErrConnectionClosed {
+		t.Fatalf("call after close: %v", err)
+	}
+}
+
+func TestChannelClientClose(t *testing.T) {
+	conn, _ := serveEndpoint(t, newEchoServer())
+	client := newChannelClient(conn)
+	defer client.Session().Close(nil)
+
+	done := make(chan error, 1)
+	_ = client.Go("wait", "hello", 10*time.Second, func(_ interface{}, err error) {
+		done <- err
+	})
+	client.Close()
+	if err := <-done; err != drpc.ErrConnectionClosed {
+		t.Fatalf("call done by %v", err)
+	}
+	if client.Session().IsClosed() {
+		t.Fatal("the session is closed by Close")
+	}
+	if err := client.Notify("echo", "hello"); err != drpc.ErrConnectionClosed {
+		t.Fatalf("notify after close: %v", err)
+	}
+	called := make(chan struct{})
+	client.OnClose(func(reason error) { close(called) })
+	select {
+	case <-called:
+	default:
+		t.Fatal("OnClose is not called at once after close")
+	}
+}
+
+// the calls fail at once when the session is closed, even if the read of the
+// session is blocked by a call in the message callback
+func TestChannelClientCloseInCallback(t *testing.T) {
+	conn, peer := serveEndpoint(t, newEchoServer())
+	server := drpc.NewServer()
+	ep := drpc.NewEndpoint(server, drpc.WithClock(dnet.SystemClock))
+	done := make(chan error, 1)
+	server.Register("hook", func(replier *drpc.Replier, req interface{}) {
+		// the handler runs in the read goroutine of session
+		_, err := ep.Call("wait", "", 10*time.Second)
+		done <- err
+	})
+	session := dnet.NewTCPSession(conn, append(ep.Options(), dnet.WithCodec(newCodec()))...)
+	ep.Attach(session)
+	// starts the write goroutine of session before it is closed
+	if _, err := ep.Call("echo", "hello", time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	_ = peer.Go("hook", "", 10*time.Second, func(interface{}, error) {})
+	deadline := time.Now().Add(time.Second)
+	for ep.Client().Pending() != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("hook is not called")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	session.Close(nil)
+	select {
+	case err := <-done:
+		if err != drpc.ErrConnectionClosed {
+			t.Fatalf("call done by %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the call waits for the timeout after the session is closed")
+	}
+}
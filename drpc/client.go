@@ -2,7 +2,7 @@ package drpc
 
 import (
 	"fmt"
-	"github.com/pkg/errors"
+	"github.com/yddeng/dnet"
 	"github.com/yddeng/timer"
 	"sync"
 	"sync/atomic"
@@ -11,13 +11,25 @@ import (
 
 const DefaultRPCTimeout = 8 * time.Second
 
-var ErrRPCTimeout = fmt.Errorf("drpc: rpc timeout. ")
+// ErrRPCTimeout is an *Error of CodeDeadlineExceeded, it is declared as error to keep the API.
+var ErrRPCTimeout error = NewError(CodeDeadlineExceeded, "drpc: rpc timeout. ")
 
 // Call represents an active RPC.
 type Call struct {
 	reqNo    uint64
 	callback func(interface{}, error)
-	timer    timer.Timer
+	mtx      sync.Mutex
+	stop     func() // stops the timer of timeout
+}
+
+// stopTimer stops the timer of timeout if it is set.
+func (c *Call) stopTimer() {
+	c.mtx.Lock()
+	stop := c.stop
+	c.mtx.Unlock()
+	if stop != nil {
+		stop()
+	}
 }
 
 // Client represents an RPC Client.
@@ -27,18 +39,27 @@ type Call struct {
 type Client struct {
 	reqNo    uint64         // serial number
 	timerMgr timer.TimerMgr // timer
+	clock    dnet.Clock     // timer, instead of timerMgr if it is set
 	pending  sync.Map       //map[uint64]*Call
+	pendingN int32          // the number of pending
+	invoker  Invoker        // interceptors + invoke
+	streamNo uint64         // serial number of stream
+	streams  sync.Map       //map[uint64]*Stream
+	window   uint32         // receive window of stream
+	retry    map[string]*RetryPolicy
+	hedging  map[string]*HedgingPolicy
+	closed   int32 // set by the ChannelClient, the calls are not retried after closed
 }
 
 // Call invokes the function synchronous, waits for it to complete, and returns its result and error status.
-func (client *Client) Call(channel RPCChannel, method string, data interface{}, timeout time.Duration) (result interface{}, err error) {
+func (client *Client) Call(channel RPCChannel, method string, data interface{}, timeout time.Duration, options ...CallOption) (result interface{}, err error) {
 	waitC := make(chan struct{})
 	f := func(ret_ interface{}, err_ error) {
 		result = ret_
 		err = err_
 		close(waitC)
 	}
-	if err := client.Go(channel, method, data, timeout, f); err != nil {
+	if err := client.Go(channel, method, data, timeout, f, options...); err != nil {
 		return nil, err
 	}
 	<-waitC
@@ -46,99 +67,217 @@ func (client *Client) Call(channel RPCChannel, method string, data interface{},
 }
 
 // Go invokes the function asynchronously.
-func (client *Client) Go(channel RPCChannel, method string, data interface{}, timeout time.Duration, callback func(interface{}, error)) error {
+// The call is retried or hedged by the policy of the call or the method,
+// each attempt goes through the ClientInterceptor and waits for timeout.
+func (client *Client) Go(channel RPCChannel, method string, data interface{}, timeout time.Duration, callback func(interface{}, error), options ...CallOption) error {
 	if callback == nil {
 		return fmt.Errorf("drpc: Go callback == nil")
 	}
 
+	retry, hedging := client.policy(method, loadCallOptions(options...))
+	switch {
+	case hedging != nil:
+		call := &hedgingCall{client: client, channel: channel, method: method, data: data, timeout: timeout, callback: callback, policy: hedging}
+		return call.start()
+	case retry != nil:
+		call := &retryCall{client: client, channel: channel, method: method, data: data, timeout: timeout, callback: callback, policy: retry}
+		return call.attempt()
+	default:
+		req := &Request{Method: method, Data: data}
+		return client.invoker(channel, req, timeout, callback)
+	}
+}
+
+// policy returns the policy of the call, or of the method if the call has none.
+func (client *Client) policy(method string, opts *CallOptions) (*RetryPolicy, *HedgingPolicy) {
+	if opts.Hedging != nil || opts.Retry != nil {
+		if opts.Hedging != nil {
+			return nil, opts.Hedging
+		}
+		return opts.Retry, nil
+	}
+	if hedging := client.hedging[method]; hedging != nil {
+		return nil, hedging
+	}
+	return client.retry[method], nil
+}
+
+// Notify sends a notification to the method, the server does not reply.
+// It does not go through the ClientInterceptor.
+func (client *Client) Notify(channel RPCChannel, method string, data interface{}) error {
+	return channel.SendRequest(&Request{Method: method, Data: data, NoReply: true})
+}
+
+// invoke sends a copy of req with a new serial number, the number is also set to req.
+// it is the last Invoker of the interceptor chain.
+func (client *Client) invoke(channel RPCChannel, req *Request, timeout time.Duration, callback func(interface{}, error)) error {
 	seq := atomic.AddUint64(&client.reqNo, 1)
 	c := &Call{reqNo: seq, callback: callback}
-	req := &Request{Seq: seq, Method: method, Data: data}
-	client.pending.Store(seq, c)
+	req.Seq = seq
+	r := *req
+	client.addCall(c)
 
-	c.timer = client.timerMgr.OnceTimer(timeout, func() {
-		if v, ok := client.pending.LoadAndDelete(seq); ok {
-			v.(*Call).callback(nil, ErrRPCTimeout)
+	// the response may arrive before the timer is set
+	c.mtx.Lock()
+	c.stop = client.afterFunc(timeout, func() {
+		if call, ok := client.removeCall(seq); ok {
+			call.callback(nil, ErrRPCTimeout)
 		}
 	})
+	c.mtx.Unlock()
 
-	if err := channel.SendRequest(req); err != nil {
-		if v, ok := client.pending.LoadAndDelete(seq); ok {
-			if v.(*Call).timer != nil {
-				v.(*Call).timer.Stop()
-			}
-		}
+	if err := channel.SendRequest(&r); err != nil {
+		client.cancelCall(seq)
 		return err
 	}
 
-	//client.lock.Lock()
-	//seq := client.reqNo
-	//client.reqNo++
-	//c := &Call{reqNo: seq, callback: callback}
-	//client.pending[seq] = c
-	//client.lock.Unlock()
-	//
-	//c.timer = client.timerMgr.OnceTimer(timeout, func() {
-	//	client.lock.Lock()
-	//	if call, ok := client.pending[seq]; ok {
-	//		delete(client.pending, seq)
-	//		call.callback(nil, ErrRPCTimeout)
-	//		call.timer = nil
-	//	}
-	//	client.lock.Unlock()
-	//})
-	//
-	//req := &Request{Seq: seq, Method: method, Data: data}
-	//
-	//// 避免channel 中直接调用 OnRPCResponse, 导致死锁
-	//if err := channel.SendRequest(req); err != nil {
-	//	client.lock.Lock()
-	//	if call, ok := client.pending[seq]; ok {
-	//		delete(client.pending, seq)
-	//		if call.timer != nil {
-	//			call.timer.Stop()
-	//		}
-	//	}
-	//	client.lock.Unlock()
-	//	return err
-	//}
-
 	return nil
 }
 
 // OnRPCResponse
 func (client *Client) OnRPCResponse(resp *Response) error {
-	v, ok := client.pending.LoadAndDelete(resp.Seq)
+	call, ok := client.removeCall(resp.Seq)
 	if !ok {
 		return fmt.Errorf("drpc: OnRPCResponse reqNo:%d is not found", resp.Seq)
 	}
 
-	call := v.(*Call)
 	if resp.Error != "" {
-		call.callback(nil, errors.New(resp.Error))
+		code := resp.Code
+		if code == CodeOK {
+			code = CodeUnknown
+		}
+		call.callback(nil, NewError(code, resp.Error))
 	} else {
 		call.callback(resp.Data, nil)
 	}
 
-	if call.timer != nil {
-		call.timer.Stop()
-	}
+	call.stopTimer()
 	return nil
 
 }
 
+// afterFunc calls f after d by the clock or the timerMgr, it returns the function to stop the timer.
+func (client *Client) afterFunc(d time.Duration, f func()) func() {
+	if client.clock != nil {
+		t := client.clock.AfterFunc(d, f)
+		return func() { t.Stop() }
+	}
+	t := client.timerMgr.OnceTimer(d, f)
+	return func() { t.Stop() }
+}
+
+func (client *Client) addCall(c *Call) {
+	atomic.AddInt32(&client.pendingN, 1)
+	client.pending.Store(c.reqNo, c)
+}
+
+func (client *Client) removeCall(seq uint64) (*Call, bool) {
+	v, ok := client.pending.LoadAndDelete(seq)
+	if !ok {
+		return nil, false
+	}
+	atomic.AddInt32(&client.pendingN, -1)
+	return v.(*Call), true
+}
+
+// cancelCall removes the call waiting for the response, its callback is not called.
+func (client *Client) cancelCall(seq uint64) {
+	if call, ok := client.removeCall(seq); ok {
+		call.stopTimer()
+	}
+}
+
+// cancelRemote removes the call waiting for the response, and tells the server to drop its reply.
+func (client *Client) cancelRemote(channel RPCChannel, seq uint64) {
+	if call, ok := client.removeCall(seq); ok {
+		call.stopTimer()
+		_ = channel.SendRequest(&Request{Seq: seq, Cancel: true})
+	}
+}
+
+// close stops the retries and hedges, and fails all pending calls and streams with err.
+func (client *Client) close(err error) {
+	atomic.StoreInt32(&client.closed, 1)
+	client.failAll(err)
+}
+
+func (client *Client) isClosed() bool {
+	return atomic.LoadInt32(&client.closed) == 1
+}
+
+// Pending returns the number of calls waiting for the response.
+func (client *Client) Pending() int {
+	return int(atomic.LoadInt32(&client.pendingN))
+}
+
+// failAll fails all pending calls and streams with err.
+func (client *Client) failAll(err error) {
+	client.pending.Range(func(key, _ interface{}) bool {
+		if call, ok := client.removeCall(key.(uint64)); ok {
+			call.stopTimer()
+			call.callback(nil, err)
+		}
+		return true
+	})
+	client.streams.Range(func(_, v interface{}) bool {
+		stream := v.(*Stream)
+		if stream.abort(err) {
+			stream.finish()
+		}
+		return true
+	})
+}
+
+// NewStream opens a stream to the method.
+// The stream must be ended by CloseSend, Cancel or the server.
+func (client *Client) NewStream(channel StreamChannel, method string, meta Metadata) (*Stream, error) {
+	id := atomic.AddUint64(&client.streamNo, 1)
+	stream := newStream(channel, id, method, meta, DefaultStreamWindow, client.window, true)
+	stream.onDone = func() { client.streams.Delete(id) }
+	client.streams.Store(id, stream)
+
+	if err := channel.SendStreamFrame(&StreamFrame{ID: id, Flag: StreamOpen, Method: method, Meta: meta, Window: client.window}); err != nil {
+		client.streams.Delete(id)
+		return nil, err
+	}
+	return stream, nil
+}
+
+// OnStreamFrame
+func (client *Client) OnStreamFrame(frame *StreamFrame) error {
+	v, ok := client.streams.Load(frame.ID)
+	if !ok {
+		return fmt.Errorf("drpc: OnStreamFrame stream:%d is not found", frame.ID)
+	}
+	v.(*Stream).onFrame(frame)
+	return nil
+}
+
 // NewClient returns a new Client to handle requests to the
 // set of services at the other end of the connection.
 // It adds a timer manager to
-func NewClient() *Client {
-	return &Client{
-		timerMgr: timer.NewTimeWheelMgr(time.Millisecond*50, 200),
+func NewClient(options ...ClientOption) *Client {
+	opts := loadClientOptions(options...)
+	if opts.TimerMgr == nil && opts.Clock == nil {
+		opts.TimerMgr = timer.NewTimeWheelMgr(time.Millisecond*50, 200)
+	}
+
+	if opts.StreamWindow == 0 {
+		opts.StreamWindow = DefaultStreamWindow
 	}
+
+	client := &Client{
+		timerMgr: opts.TimerMgr,
+		clock:    opts.Clock,
+		window:   opts.StreamWindow,
+		retry:    opts.RetryPolicies,
+		hedging:  opts.HedgingPolicies,
+	}
+	client.invoker = chainClientInterceptors(opts.Interceptors, client.invoke)
+	return client
 }
 
 // NewClientWithTimerMgr is like NewClient but uses the specified timerMgr.
 func NewClientWithTimerMgr(timerMgr timer.TimerMgr) *Client {
-	return &Client{
-		timerMgr: timerMgr,
-	}
+	return NewClient(WithTimerMgr(timerMgr))
 }
@@ -0,0 +1,445 @@
+// Package codec is the wire format of drpc over dnet sessions.
+//
+// A frame is: head(body len + flag + seq), body
+//
+//	| bodyLen uint32 | flag uint8 | seq uint64 | body |
+//
+// seq is the Seq of Request/Response, or the ID of StreamFrame.
+// The high bit of flag is set for the frames sent by the server side of a stream.
+// body depends on flag:
+//
+//	request, notify: method(str) meta(map) payload
+//	cancel:          -
+//	response:        payload
+//	error:           code(uint32) message(rest)
+//	stream open:     method(str) meta(map) window(uint32)
+//	stream data:     payload
+//	stream end:      -
+//	stream cancel:   code(uint32) message(rest)
+//	stream window:   window(uint32)
+//
+// str is len(uint16) + bytes, map is count(uint16) + str pairs,
+// payload is kind(uint8) + name(str) + data(rest), serialized by a Marshaler.
+// []byte payloads are sent raw, without a Marshaler.
+package codec
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/yddeng/dnet/drpc"
+	"github.com/yddeng/utils/buffer"
+	"io"
+	"reflect"
+)
+
+const (
+	lenSize  = 4                            // 消息体长度 //uint32
+	flagSize = 1                            // 消息flag //byte
+	seqSize  = 8                            // 消息的索引 //uint64
+	headSize = lenSize + flagSize + seqSize // 消息头长度
+
+	DefaultMaxBodySize = 16 * 1024 * 1024 // default max length of body
+)
+
+const (
+	flagRequest      byte = 0x01
+	flagNotify       byte = 0x02
+	flagResponse     byte = 0x03
+	flagError        byte = 0x04
+	flagStreamOpen   byte = 0x05
+	flagStreamData   byte = 0x06
+	flagStreamEnd    byte = 0x07
+	flagStreamCancel byte = 0x08
+	flagStreamWindow byte = 0x09
+	flagCancel       byte = 0x0A // cancels the request of seq
+
+	flagServer byte = 0x80 // sent by the server side of stream
+)
+
+const (
+	payloadNil   byte = 0
+	payloadBytes byte = 1 // []byte, sent raw
+	payloadValue byte = 2 // serialized by Marshaler
+)
+
+var errShortBody = errors.New("drpc/codec: body is too short")
+
+// Codec encodes and decodes *drpc.Request, *drpc.Response and *drpc.StreamFrame.
+// A Codec keeps the state of reading, create one for each session.
+type Codec struct {
+	marshaler   Marshaler
+	maxBodySize int
+
+	readBuf  *buffer.Buffer
+	readHead bool
+	bodyLen  uint32
+	flag     byte
+	seq      uint64
+}
+
+// NewCodec returns a Codec whose payloads are serialized by marshaler.
+func NewCodec(marshaler Marshaler) *Codec {
+	if marshaler == nil {
+		panic("drpc/codec: NewCodec marshaler == nil")
+	}
+	return &Codec{
+		marshaler:   marshaler,
+		maxBodySize: DefaultMaxBodySize,
+		readBuf:     buffer.NewBufferWithCap(4096),
+	}
+}
+
+// SetMaxBodySize sets the max length of body, both for encoding and decoding.
+func (c *Codec) SetMaxBodySize(size int) {
+	c.maxBodySize = size
+}
+
+// 解码
+func (c *Codec) Decode(reader io.Reader) (interface{}, error) {
+	for {
+		msg, err := c.unPack()
+
+		if msg != nil {
+			return msg, nil
+
+		} else if err == nil {
+			_, err1 := c.readBuf.ReadFrom(reader)
+			if err1 != nil {
+				return nil, err1
+			}
+		} else {
+			return nil, err
+		}
+	}
+}
+
+func (c *Codec) unPack() (interface{}, error) {
+	if !c.readHead {
+		if c.readBuf.Len() < headSize {
+			return nil, nil
+		}
+
+		head, _ := c.readBuf.ReadBytes(headSize)
+		c.bodyLen = binary.BigEndian.Uint32(head)
+		c.flag = head[lenSize]
+		c.seq = binary.BigEndian.Uint64(head[lenSize+flagSize:])
+		if int64(c.bodyLen) > int64(c.maxBodySize) {
+			return nil, fmt.Errorf("drpc/codec: body is too large, len: %d", c.bodyLen)
+		}
+		c.readHead = true
+	}
+
+	if c.readBuf.Len() < int(c.bodyLen) {
+		return nil, nil
+	}
+
+	body, _ := c.readBuf.ReadBytes(int(c.bodyLen))
+	c.readHead = false
+
+	msg, err := c.decodeBody(c.flag, c.seq, body)
+	if err == nil && msg == nil {
+		err = fmt.Errorf("drpc/codec: unPack nil message, flag is %d", c.flag)
+	}
+	return msg, err
+}
+
+func (c *Codec) decodeBody(flag byte, seq uint64, body []byte) (interface{}, error) {
+	r := &bodyReader{b: body}
+	server := flag&flagServer != 0
+
+	switch flag &^ flagServer {
+	case flagRequest, flagNotify:
+		req := &drpc.Request{Seq: seq, NoReply: flag == flagNotify}
+		req.Method = r.readString()
+		req.Meta = r.readMeta()
+		data, err := c.readPayload(r)
+		if err != nil {
+			return nil, err
+		}
+		req.Data = data
+		return req, nil
+
+	case flagCancel:
+		return &drpc.Request{Seq: seq, Cancel: true}, nil
+
+	case flagResponse:
+		data, err := c.readPayload(r)
+		if err != nil {
+			return nil, err
+		}
+		return &drpc.Response{Seq: seq, Data: data}, nil
+
+	case flagError:
+		code := drpc.Code(r.readUint32())
+		msg := string(r.rest())
+		if r.err != nil {
+			return nil, r.err
+		}
+		return &drpc.Response{Seq: seq, Code: code, Error: msg}, nil
+
+	case flagStreamOpen:
+		frame := &drpc.StreamFrame{ID: seq, Flag: drpc.StreamOpen, Server: server}
+		frame.Method = r.readString()
+		frame.Meta = r.readMeta()
+		frame.Window = r.readUint32()
+		return frame, r.err
+
+	case flagStreamData:
+		data, err := c.readPayload(r)
+		if err != nil {
+			return nil, err
+		}
+		return &drpc.StreamFrame{ID: seq, Flag: drpc.StreamData, Data: data, Server: server}, nil
+
+	case flagStreamEnd:
+		return &drpc.StreamFrame{ID: seq, Flag: drpc.StreamEnd, Server: server}, nil
+
+	case flagStreamCancel:
+		code := drpc.Code(r.readUint32())
+		msg := string(r.rest())
+		if r.err != nil {
+			return nil, r.err
+		}
+		return &drpc.StreamFrame{ID: seq, Flag: drpc.StreamCancel, Code: code, Error: msg, Server: server}, nil
+
+	case flagStreamWindow:
+		frame := &drpc.StreamFrame{ID: seq, Flag: drpc.StreamWindow, Server: server}
+		frame.Window = r.readUint32()
+		return frame, r.err
+
+	default:
+		return nil, fmt.Errorf("drpc/codec: unPack err: flag is %d", flag)
+	}
+}
+
+func (c *Codec) readPayload(r *bodyReader) (interface{}, error) {
+	kind := r.readUint8()
+	name := r.readString()
+	data := r.rest()
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	switch kind {
+	case payloadNil:
+		return nil, nil
+	case payloadBytes:
+		// the body may be reused by the read buffer
+		return append([]byte{}, data...), nil
+	case payloadValue:
+		return c.marshaler.Unmarshal(name, data)
+	default:
+		return nil, fmt.Errorf("drpc/codec: payload kind is %d", kind)
+	}
+}
+
+// 编码
+func (c *Codec) Encode(o interface{}) ([]byte, error) {
+	w := &bodyWriter{b: make([]byte, headSize, 64)}
+	var flag byte
+	var seq uint64
+	var err error
+
+	switch msg := o.(type) {
+	case *drpc.Request:
+		if msg.Cancel {
+			flag, seq = flagCancel, msg.Seq
+			break
+		}
+		flag, seq = flagRequest, msg.Seq
+		if msg.NoReply {
+			flag = flagNotify
+		}
+		w.writeString(msg.Method)
+		w.writeMeta(msg.Meta)
+		err = c.writePayload(w, msg.Data)
+
+	case *drpc.Response:
+		seq = msg.Seq
+		if msg.Error != "" {
+			flag = flagError
+			w.writeUint32(uint32(msg.Code))
+			w.writeBytes([]byte(msg.Error))
+		} else {
+			flag = flagResponse
+			err = c.writePayload(w, msg.Data)
+		}
+
+	case *drpc.StreamFrame:
+		seq = msg.ID
+		switch msg.Flag {
+		case drpc.StreamOpen:
+			flag = flagStreamOpen
+			w.writeString(msg.Method)
+			w.writeMeta(msg.Meta)
+			w.writeUint32(msg.Window)
+		case drpc.StreamData:
+			flag = flagStreamData
+			err = c.writePayload(w, msg.Data)
+		case drpc.StreamEnd:
+			flag = flagStreamEnd
+		case drpc.StreamCancel:
+			flag = flagStreamCancel
+			w.writeUint32(uint32(msg.Code))
+			w.writeBytes([]byte(msg.Error))
+		case drpc.StreamWindow:
+			flag = flagStreamWindow
+			w.writeUint32(msg.Window)
+		default:
+			return nil, fmt.Errorf("drpc/codec: encode stream flag is %d", msg.Flag)
+		}
+		if msg.Server {
+			flag |= flagServer
+		}
+
+	default:
+		return nil, fmt.Errorf("drpc/codec: encode error , o'type is %s", reflect.TypeOf(o))
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	if w.err != nil {
+		return nil, w.err
+	}
+
+	bodyLen := len(w.b) - headSize
+	if bodyLen > c.maxBodySize {
+		return nil, fmt.Errorf("drpc/codec: encode body is too large, len: %d", bodyLen)
+	}
+	binary.BigEndian.PutUint32(w.b, uint32(bodyLen))
+	w.b[lenSize] = flag
+	binary.BigEndian.PutUint64(w.b[lenSize+flagSize:], seq)
+	return w.b, nil
+}
+
+func (c *Codec) writePayload(w *bodyWriter, v interface{}) error {
+	switch b := v.(type) {
+	case nil:
+		w.writeUint8(payloadNil)
+		w.writeString("")
+	case []byte:
+		w.writeUint8(payloadBytes)
+		w.writeString("")
+		w.writeBytes(b)
+	default:
+		name, data, err := c.marshaler.Marshal(v)
+		if err != nil {
+			return err
+		}
+		w.writeUint8(payloadValue)
+		w.writeString(name)
+		w.writeBytes(data)
+	}
+	return nil
+}
+
+type bodyWriter struct {
+	b   []byte
+	err error
+}
+
+func (w *bodyWriter) writeUint8(v uint8) {
+	w.b = append(w.b, v)
+}
+
+func (w *bodyWriter) writeUint16(v uint16) {
+	w.b = append(w.b, byte(v>>8), byte(v))
+}
+
+func (w *bodyWriter) writeUint32(v uint32) {
+	w.b = append(w.b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func (w *bodyWriter) writeBytes(b []byte) {
+	w.b = append(w.b, b...)
+}
+
+func (w *bodyWriter) writeString(s string) {
+	if len(s) > 0xFFFF {
+		w.err = fmt.Errorf("drpc/codec: encode string is too long, len: %d", len(s))
+		return
+	}
+	w.writeUint16(uint16(len(s)))
+	w.b = append(w.b, s...)
+}
+
+func (w *bodyWriter) writeMeta(meta drpc.Metadata) {
+	if len(meta) > 0xFFFF {
+		w.err = fmt.Errorf("drpc/codec: encode meta is too large, len: %d", len(meta))
+		return
+	}
+	w.writeUint16(uint16(len(meta)))
+	for k, v := range meta {
+		w.writeString(k)
+		w.writeString(v)
+	}
+}
+
+type bodyReader struct {
+	b   []byte
+	err error
+}
+
+func (r *bodyReader) next(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if len(r.b) < n {
+		r.err = errShortBody
+		return nil
+	}
+	b := r.b[:n]
+	r.b = r.b[n:]
+	return b
+}
+
+func (r *bodyReader) readUint8() uint8 {
+	if b := r.next(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (r *bodyReader) readUint16() uint16 {
+	if b := r.next(2); b != nil {
+		return binary.BigEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (r *bodyReader) readUint32() uint32 {
+	if b := r.next(4); b != nil {
+		return binary.BigEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (r *bodyReader) readString() string {
+	n := r.readUint16()
+	return string(r.next(int(n)))
+}
+
+func (r *bodyReader) readMeta() drpc.Metadata {
+	n := int(r.readUint16())
+	if n == 0 || r.err != nil {
+		return nil
+	}
+	meta := make(drpc.Metadata, n)
+	for i := 0; i < n; i++ {
+		k := r.readString()
+		v := r.readString()
+		meta[k] = v
+	}
+	return meta
+}
+
+func (r *bodyReader) rest() []byte {
+	if r.err != nil {
+		return nil
+	}
+	b := r.b
+	r.b = nil
+	return b
+}
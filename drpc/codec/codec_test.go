@@ -0,0 +1,157 @@
+package codec
+
+import (
+	"bytes"
+	"github.com/golang/protobuf/proto"
+	"github.com/yddeng/dnet/drpc"
+	"github.com/yddeng/dnet/examples/pb"
+	"io"
+	"reflect"
+	"testing"
+	"testing/iotest"
+)
+
+type echo struct {
+	Msg string
+	Num int
+}
+
+func init() {
+	Register(&echo{})
+}
+
+func roundTrip(t *testing.T, c *Codec, o interface{}) interface{} {
+	data, err := c.Encode(o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := c.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return msg
+}
+
+func TestCodec(t *testing.T) {
+	marshalers := map[string]Marshaler{
+		"json":    NewJSONMarshaler(nil),
+		"msgpack": NewMsgpackMarshaler(nil),
+		"gob":     NewGobMarshaler(nil),
+	}
+
+	for name, m := range marshalers {
+		c := NewCodec(m)
+		frames := []interface{}{
+			&drpc.Request{Seq: 1, Method: "echo", Data: &echo{Msg: "hello", Num: 1}, Meta: drpc.Metadata{"token": "abc"}},
+			&drpc.Request{Method: "event", Data: []byte{1, 2, 3}, NoReply: true},
+			&drpc.Request{Seq: 1, Cancel: true},
+			&drpc.Response{Seq: 1, Data: &echo{Msg: "world", Num: 2}},
+			&drpc.Response{Seq: 2, Code: drpc.CodeNotFound, Error: "not found"},
+			&drpc.StreamFrame{ID: 3, Flag: drpc.StreamOpen, Method: "watch", Window: 16},
+			&drpc.StreamFrame{ID: 3, Flag: drpc.StreamData, Data: &echo{Msg: "data"}, Server: true},
+			&drpc.StreamFrame{ID: 3, Flag: drpc.StreamWindow, Window: 8},
+			&drpc.StreamFrame{ID: 3, Flag: drpc.StreamEnd, Server: true},
+			&drpc.StreamFrame{ID: 3, Flag: drpc.StreamCancel, Code: drpc.CodeCanceled, Error: "canceled"},
+		}
+		for _, o := range frames {
+			if msg := roundTrip(t, c, o); !reflect.DeepEqual(msg, o) {
+				t.Fatalf("%s: %#v != %#v", name, msg, o)
+			}
+		}
+	}
+}
+
+func TestCodecProtobuf(t *testing.T) {
+	c := NewCodec(NewProtobufMarshaler())
+	req := &drpc.Request{Seq: 1, Method: "echo", Data: &pb.EchoToS{Msg: proto.String("hello")}}
+	msg := roundTrip(t, c, req).(*drpc.Request)
+	if msg.Data.(*pb.EchoToS).GetMsg() != "hello" {
+		t.Fatal(msg.Data)
+	}
+
+	if _, err := c.Encode(&drpc.Request{Seq: 2, Method: "echo", Data: &echo{}}); err == nil {
+		t.Fatal("encode non-proto message")
+	}
+}
+
+func TestCodecLargeBody(t *testing.T) {
+	c := NewCodec(NewJSONMarshaler(nil))
+	data := make([]byte, 1024*1024)
+	resp := roundTrip(t, c, &drpc.Response{Seq: 1, Data: data}).(*drpc.Response)
+	if len(resp.Data.([]byte)) != len(data) {
+		t.Fatal(len(resp.Data.([]byte)))
+	}
+
+	c.SetMaxBodySize(1024)
+	if _, err := c.Encode(&drpc.Response{Seq: 1, Data: data}); err == nil {
+		t.Fatal("encode body larger than max")
+	}
+}
+
+func TestCodecPartialRead(t *testing.T) {
+	c := NewCodec(NewJSONMarshaler(nil))
+	req := &drpc.Request{Seq: 1, Method: "echo", Data: &echo{Msg: "hello", Num: 1}}
+	data, err := c.Encode(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the header and the body are split across reads
+	msg, err := c.Decode(iotest.OneByteReader(bytes.NewReader(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(msg, req) {
+		t.Fatalf("%#v != %#v", msg, req)
+	}
+
+	// the frame is not complete
+	if _, err := c.Decode(bytes.NewReader(data[:headSize-1])); err != io.EOF {
+		t.Fatalf("decode the partial header: %v", err)
+	}
+}
+
+func TestCodecFrames(t *testing.T) {
+	c := NewCodec(NewJSONMarshaler(nil))
+	frames := []interface{}{
+		&drpc.Request{Seq: 1, Method: "echo", Data: &echo{Msg: "hello"}},
+		&drpc.Request{Seq: 1, Cancel: true},
+		&drpc.Response{Seq: 2, Data: []byte("world")},
+		&drpc.StreamFrame{ID: 3, Flag: drpc.StreamEnd, Server: true},
+	}
+	var buf bytes.Buffer
+	for _, o := range frames {
+		data, err := c.Encode(o)
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf.Write(data)
+	}
+
+	// the frames in one buffer are decoded one by one
+	r := bytes.NewReader(buf.Bytes())
+	for _, o := range frames {
+		msg, err := c.Decode(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(msg, o) {
+			t.Fatalf("%#v != %#v", msg, o)
+		}
+	}
+	if _, err := c.Decode(r); err != io.EOF {
+		t.Fatalf("decode after the frames: %v", err)
+	}
+}
+
+func TestTypeNamer(t *testing.T) {
+	if name := TypeNamer(NewProtobufMarshaler())(reflect.TypeOf(&pb.EchoToS{})); name != proto.MessageName(&pb.EchoToS{}) {
+		t.Fatal(name)
+	}
+	if name := TypeNamer(NewJSONMarshaler(nil))(reflect.TypeOf(&echo{})); name != "github.com/yddeng/dnet/drpc/codec.echo" {
+		t.Fatal(name)
+	}
+	if name := TypeNamer(NewJSONMarshaler(nil))(reflect.TypeOf(1)); name != "" {
+		t.Fatal(name)
+	}
+}
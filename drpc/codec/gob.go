@@ -0,0 +1,25 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/gob"
+)
+
+// NewGobMarshaler returns a Marshaler of gob, the types must be registered
+// in registry, or in the DefaultRegistry if registry is nil.
+// Each payload carries its own type information.
+func NewGobMarshaler(registry *Registry) Marshaler {
+	return newRegistryMarshaler(registry, gobMarshal, gobUnmarshal)
+}
+
+func gobMarshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func gobUnmarshal(data []byte, ptr interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(ptr)
+}
@@ -0,0 +1,11 @@
+package codec
+
+import (
+	"encoding/json"
+)
+
+// NewJSONMarshaler returns a Marshaler of JSON, the types must be registered
+// in registry, or in the DefaultRegistry if registry is nil.
+func NewJSONMarshaler(registry *Registry) Marshaler {
+	return newRegistryMarshaler(registry, json.Marshal, json.Unmarshal)
+}
@@ -0,0 +1,176 @@
+package codec
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// Marshaler serializes the payload of frames.
+// The name of type is sent along with the data, so that the peer
+// knows which type to unmarshal.
+type Marshaler interface {
+	// Marshal returns the type name and the data of v.
+	Marshal(v interface{}) (name string, data []byte, err error)
+
+	// Unmarshal returns a value of the named type decoded from data.
+	Unmarshal(name string, data []byte) (interface{}, error)
+}
+
+// Registry maps the names to the types, for the marshalers which
+// do not carry type information, such as JSON, msgpack and gob.
+type Registry struct {
+	mtx   sync.RWMutex
+	types map[string]reflect.Type
+	names map[reflect.Type]string
+}
+
+// NewRegistry returns an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{
+		types: map[string]reflect.Type{},
+		names: map[reflect.Type]string{},
+	}
+}
+
+// DefaultRegistry is used by the marshalers created with a nil Registry.
+var DefaultRegistry = NewRegistry()
+
+// Register registers the type of v with the name "package path.type name".
+func (r *Registry) Register(v interface{}) {
+	t := reflect.TypeOf(v)
+	e := t
+	if e.Kind() == reflect.Ptr {
+		e = e.Elem()
+	}
+	r.RegisterName(e.PkgPath()+"."+e.Name(), v)
+}
+
+// RegisterName registers the type of v with name.
+// Values are unmarshaled to the same type as v, pointer or not.
+func (r *Registry) RegisterName(name string, v interface{}) {
+	if name == "" || v == nil {
+		panic("drpc/codec: RegisterName invalid argument")
+	}
+	t := reflect.TypeOf(v)
+
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	if old, ok := r.types[name]; ok && old != t {
+		panic(fmt.Sprintf("drpc/codec: RegisterName duplicate name:%s", name))
+	}
+	r.types[name] = t
+	r.names[t] = name
+}
+
+// Name returns the name of the type of v.
+func (r *Registry) Name(v interface{}) (string, error) {
+	t := reflect.TypeOf(v)
+	r.mtx.RLock()
+	name, ok := r.names[t]
+	r.mtx.RUnlock()
+	if !ok {
+		return "", fmt.Errorf("drpc/codec: type %s is not registered", t)
+	}
+	return name, nil
+}
+
+// New returns a pointer to a new zero value of the named type,
+// and whether the registered type is a pointer.
+func (r *Registry) New(name string) (ptr interface{}, isPtr bool, err error) {
+	r.mtx.RLock()
+	t, ok := r.types[name]
+	r.mtx.RUnlock()
+	if !ok {
+		return nil, false, fmt.Errorf("drpc/codec: type name %s is not registered", name)
+	}
+
+	if t.Kind() == reflect.Ptr {
+		return reflect.New(t.Elem()).Interface(), true, nil
+	}
+	return reflect.New(t).Interface(), false, nil
+}
+
+// Register registers the type of v in the DefaultRegistry.
+func Register(v interface{}) {
+	DefaultRegistry.Register(v)
+}
+
+// RegisterName registers the type of v with name in the DefaultRegistry.
+func RegisterName(name string, v interface{}) {
+	DefaultRegistry.RegisterName(name, v)
+}
+
+// registryMarshaler implements Marshaler by a Registry and the functions of a serialization.
+type registryMarshaler struct {
+	registry  *Registry
+	marshal   func(v interface{}) ([]byte, error)
+	unmarshal func(data []byte, ptr interface{}) error
+}
+
+func newRegistryMarshaler(registry *Registry, marshal func(v interface{}) ([]byte, error), unmarshal func(data []byte, ptr interface{}) error) *registryMarshaler {
+	if registry == nil {
+		registry = DefaultRegistry
+	}
+	return &registryMarshaler{registry: registry, marshal: marshal, unmarshal: unmarshal}
+}
+
+func (m *registryMarshaler) Name(v interface{}) (string, error) {
+	return m.registry.Name(v)
+}
+
+func (m *registryMarshaler) Marshal(v interface{}) (string, []byte, error) {
+	name, err := m.registry.Name(v)
+	if err != nil {
+		return "", nil, err
+	}
+	data, err := m.marshal(v)
+	if err != nil {
+		return "", nil, err
+	}
+	return name, data, nil
+}
+
+func (m *registryMarshaler) Unmarshal(name string, data []byte) (interface{}, error) {
+	ptr, isPtr, err := m.registry.New(name)
+	if err != nil {
+		return nil, err
+	}
+	if err = m.unmarshal(data, ptr); err != nil {
+		return nil, err
+	}
+	if isPtr {
+		return ptr, nil
+	}
+	return reflect.ValueOf(ptr).Elem().Interface(), nil
+}
+
+// TypeNamer returns a function which names the types by marshaler, as they are named on the wire.
+// It returns "" if the type can not be marshaled.
+// The marshalers of this package name the types without marshaling,
+// the others are asked to marshal a zero value.
+//
+//	server := drpc.NewServer(drpc.WithTypeNamer(codec.TypeNamer(marshaler)))
+func TypeNamer(marshaler Marshaler) func(t reflect.Type) string {
+	return func(t reflect.Type) string {
+		var v reflect.Value
+		if t.Kind() == reflect.Ptr {
+			v = reflect.New(t.Elem())
+		} else {
+			v = reflect.New(t).Elem()
+		}
+		var name string
+		var err error
+		if namer, ok := marshaler.(interface {
+			Name(v interface{}) (string, error)
+		}); ok {
+			name, err = namer.Name(v.Interface())
+		} else {
+			name, _, err = marshaler.Marshal(v.Interface())
+		}
+		if err != nil {
+			return ""
+		}
+		return name
+	}
+}
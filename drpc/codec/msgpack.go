@@ -0,0 +1,19 @@
+package codec
+
+import (
+	"github.com/vmihailenco/msgpack"
+)
+
+// NewMsgpackMarshaler returns a Marshaler of msgpack, the types must be registered
+// in registry, or in the DefaultRegistry if registry is nil.
+func NewMsgpackMarshaler(registry *Registry) Marshaler {
+	return newRegistryMarshaler(registry, msgpackMarshal, msgpackUnmarshal)
+}
+
+func msgpackMarshal(v interface{}) ([]byte, error) {
+	return msgpack.Marshal(v)
+}
+
+func msgpackUnmarshal(data []byte, ptr interface{}) error {
+	return msgpack.Unmarshal(data, ptr)
+}
@@ -0,0 +1,47 @@
+package codec
+
+import (
+	"fmt"
+	"github.com/golang/protobuf/proto"
+	"reflect"
+)
+
+type protobufMarshaler struct{}
+
+// NewProtobufMarshaler returns a Marshaler of protobuf messages,
+// the types are looked up in the registry of protobuf by message name.
+func NewProtobufMarshaler() Marshaler {
+	return protobufMarshaler{}
+}
+
+func (protobufMarshaler) Name(v interface{}) (string, error) {
+	msg, ok := v.(proto.Message)
+	if !ok {
+		return "", fmt.Errorf("drpc/codec: Name %s is not proto.Message", reflect.TypeOf(v))
+	}
+	return proto.MessageName(msg), nil
+}
+
+func (protobufMarshaler) Marshal(v interface{}) (string, []byte, error) {
+	msg, ok := v.(proto.Message)
+	if !ok {
+		return "", nil, fmt.Errorf("drpc/codec: Marshal %s is not proto.Message", reflect.TypeOf(v))
+	}
+	data, err := proto.Marshal(msg)
+	if err != nil {
+		return "", nil, err
+	}
+	return proto.MessageName(msg), data, nil
+}
+
+func (protobufMarshaler) Unmarshal(name string, data []byte) (interface{}, error) {
+	tt := proto.MessageType(name)
+	if tt == nil {
+		return nil, fmt.Errorf("drpc/codec: Unmarshal message %s is not registered", name)
+	}
+	msg := reflect.New(tt.Elem()).Interface().(proto.Message)
+	if err := proto.Unmarshal(data, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
@@ -0,0 +1,193 @@
+package drpc
+
+import (
+	"errors"
+	"fmt"
+	"github.com/yddeng/dnet"
+	"reflect"
+	"sync"
+	"time"
+)
+
+var (
+	ErrEndpointNotAttached = errors.New("drpc: endpoint is not attached to a session. ")
+	ErrEndpointSession     = errors.New("drpc: endpoint is attached to another session. ")
+)
+
+// Endpoint makes and serves calls in both directions on one session.
+// It routes *Request to the Server, *Response and *StreamFrame to
+// the Server or the Client, and fails the pending calls when the session is closed.
+// An Endpoint is bound to one session, a new Endpoint is needed for each session.
+//
+//	ep := drpc.NewEndpoint(server)
+//	session := dnet.NewTCPSession(conn, append(ep.Options(), dnet.WithCodec(codec))...)
+//	ep.Attach(session)
+//	ret, err := ep.Call(method, arg, drpc.DefaultRPCTimeout)
+type Endpoint struct {
+	server  *Server
+	options []ClientOption
+
+	mtx     sync.Mutex
+	channel *SessionChannel
+	client  *ChannelClient
+}
+
+// NewEndpoint returns an Endpoint which serves calls by server,
+// and makes calls by a Client with options. server may be nil if
+// the endpoint only makes calls.
+func NewEndpoint(server *Server, options ...ClientOption) *Endpoint {
+	return &Endpoint{
+		server:  server,
+		options: options,
+	}
+}
+
+// Options returns the message and close callbacks of session.
+func (ep *Endpoint) Options() []dnet.Option {
+	return []dnet.Option{
+		dnet.WithMessageCallback(func(session dnet.Session, message interface{}) {
+			_, _ = ep.OnMessage(session, message)
+		}),
+		dnet.WithCloseCallback(ep.OnClose),
+	}
+}
+
+// Attach binds the endpoint to session, it must be called before making calls.
+// The session is also bound when it receives the first message.
+// It is ignored if the endpoint is bound to another session.
+func (ep *Endpoint) Attach(session dnet.Session) {
+	_, _, _ = ep.attach(session)
+}
+
+func (ep *Endpoint) attach(session dnet.Session) (*SessionChannel, *ChannelClient, error) {
+	ep.mtx.Lock()
+	defer ep.mtx.Unlock()
+	if ep.channel == nil {
+		ep.channel = NewSessionChannel(session)
+		ep.client = NewChannelClient(session, ep.options...)
+	} else if !sameSession(ep.channel.Session(), session) {
+		return nil, nil, ErrEndpointSession
+	}
+	return ep.channel, ep.client, nil
+}
+
+// sameSession reports whether a and b are the same session. The session passed to
+// the callbacks may be not the one returned by dnet.NewTCPSession, but they share the connection.
+func sameSession(a, b dnet.Session) bool {
+	if a == b {
+		return true
+	}
+	conn := a.NetConn()
+	return conn != nil && isComparable(conn) && conn == b.NetConn()
+}
+
+func (ep *Endpoint) getClient() (*ChannelClient, error) {
+	ep.mtx.Lock()
+	defer ep.mtx.Unlock()
+	if ep.client == nil {
+		return nil, ErrEndpointNotAttached
+	}
+	return ep.client, nil
+}
+
+// Session returns the session attached, nil if it is not attached.
+func (ep *Endpoint) Session() dnet.Session {
+	ep.mtx.Lock()
+	defer ep.mtx.Unlock()
+	if ep.channel == nil {
+		return nil
+	}
+	return ep.channel.Session()
+}
+
+// Client returns the ChannelClient of the session, nil if it is not attached.
+func (ep *Endpoint) Client() *ChannelClient {
+	client, _ := ep.getClient()
+	return client
+}
+
+// Call invokes the method synchronous on the peer.
+func (ep *Endpoint) Call(method string, data interface{}, timeout time.Duration, options ...CallOption) (interface{}, error) {
+	client, err := ep.getClient()
+	if err != nil {
+		return nil, err
+	}
+	return client.Call(method, data, timeout, options...)
+}
+
+// Go invokes the method asynchronously on the peer.
+func (ep *Endpoint) Go(method string, data interface{}, timeout time.Duration, callback func(interface{}, error), options ...CallOption) error {
+	client, err := ep.getClient()
+	if err != nil {
+		return err
+	}
+	return client.Go(method, data, timeout, callback, options...)
+}
+
+// Notify sends a notification to the method on the peer.
+func (ep *Endpoint) Notify(method string, data interface{}) error {
+	client, err := ep.getClient()
+	if err != nil {
+		return err
+	}
+	return client.Notify(method, data)
+}
+
+// NewStream opens a stream to the method on the peer.
+func (ep *Endpoint) NewStream(method string, meta Metadata) (*Stream, error) {
+	client, err := ep.getClient()
+	if err != nil {
+		return nil, err
+	}
+	return client.NewStream(method, meta)
+}
+
+// OnMessage routes the rpc message of session.
+// It returns false if the message is not a rpc message, and ErrEndpointSession
+// if session is not the session bound.
+func (ep *Endpoint) OnMessage(session dnet.Session, message interface{}) (bool, error) {
+	channel, client, err := ep.attach(session)
+	if err != nil {
+		return false, err
+	}
+
+	switch msg := message.(type) {
+	case *Request:
+		if ep.server == nil {
+			if !msg.NoReply {
+				_ = channel.SendResponse(&Response{Seq: msg.Seq, Code: CodeUnimplemented, Error: "drpc: endpoint without server"})
+			}
+			return true, fmt.Errorf("drpc: OnMessage endpoint without server")
+		}
+		return true, ep.server.OnRPCRequest(channel, msg)
+	case *Response:
+		return true, client.OnRPCResponse(msg)
+	case *StreamFrame:
+		if msg.Server {
+			return true, client.OnStreamFrame(msg)
+		}
+		if ep.server == nil {
+			if msg.Flag == StreamOpen {
+				_ = channel.SendStreamFrame(&StreamFrame{ID: msg.ID, Flag: StreamCancel, Code: CodeUnimplemented,
+					Error: "drpc: endpoint without server", Server: true})
+			}
+			return true, fmt.Errorf("drpc: OnMessage endpoint without server")
+		}
+		return true, ep.server.OnStreamFrame(channel, msg)
+	default:
+		return false, fmt.Errorf("drpc: OnMessage invalid type %s", reflect.TypeOf(message))
+	}
+}
+
+// OnClose fails the pending calls and cancels the streams of session.
+// It is ignored if session is not the session bound.
+func (ep *Endpoint) OnClose(session dnet.Session, reason error) {
+	channel, client, err := ep.attach(session)
+	if err != nil {
+		return
+	}
+	client.OnSessionClose(reason)
+	if ep.server != nil {
+		ep.server.OnChannelClose(channel)
+	}
+}
@@ -0,0 +1,137 @@
+package drpc_test
+
+import (
+	"github.com/yddeng/dnet"
+	"github.com/yddeng/dnet/drpc"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestEndpoint(t *testing.T) {
+	server := newEchoServer()
+	events := make(chan interface{}, 1)
+	server.RegisterNotify("event", func(req interface{}) {
+		events <- req
+	})
+	server.RegisterStream("count", func(stream *drpc.Stream) error {
+		for i := 0; i < 3; i++ {
+			if err := stream.Send(i); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	conn, peer := serveEndpoint(t, server)
+	ep := drpc.NewEndpoint(server, drpc.WithClock(dnet.SystemClock))
+	if _, err := ep.Call("echo", "hello", time.Second); err != drpc.ErrEndpointNotAttached {
+		t.Fatalf("call before attach: %v", err)
+	}
+	session := dnet.NewTCPSession(conn, append(ep.Options(), dnet.WithCodec(newCodec()))...)
+	ep.Attach(session)
+	defer session.Close(nil)
+
+	// calls in both directions on one session
+	if ret, err := ep.Call("echo", "hello", time.Second); ret != "hello" || err != nil {
+		t.Fatalf("call %v %v", ret, err)
+	}
+	if ret, err := peer.Call("echo", "world", time.Second); ret != "world" || err != nil {
+		t.Fatalf("call from the peer %v %v", ret, err)
+	}
+
+	if err := ep.Notify("event", "hello"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case req := <-events:
+		if req != "hello" {
+			t.Fatalf("event %v", req)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("notification timeout")
+	}
+
+	stream, err := ep.NewStream("count", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; ; i++ {
+		v, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil || v != i {
+			t.Fatalf("recv %v %v, want %d", v, err, i)
+		}
+	}
+
+	// the pending calls fail when the session is closed
+	done := make(chan error, 1)
+	_ = ep.Go("wait", "hello", 10*time.Second, func(_ interface{}, err error) {
+		done <- err
+	})
+	session.Close(nil)
+	select {
+	case err := <-done:
+		if err != drpc.ErrConnectionClosed {
+			t.Fatalf("call done by %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the call waits after the session is closed")
+	}
+}
+
+func TestEndpointWithoutServer(t *testing.T) {
+	conn, peer := serveEndpoint(t, newEchoServer())
+	ep := drpc.NewEndpoint(nil, drpc.WithClock(dnet.SystemClock))
+	session := dnet.NewTCPSession(conn, append(ep.Options(), dnet.WithCodec(newCodec()))...)
+	ep.Attach(session)
+	defer session.Close(nil)
+
+	if ret, err := ep.Call("echo", "hello", time.Second); ret != "hello" || err != nil {
+		t.Fatalf("call %v %v", ret, err)
+	}
+	if _, err := peer.Call("echo", "hello", time.Second); drpc.CodeOf(err) != drpc.CodeUnimplemented {
+		t.Fatalf("call the endpoint without server: %v", err)
+	}
+	stream, _ := peer.NewStream("count", nil)
+	if _, err := stream.Recv(); drpc.CodeOf(err) != drpc.CodeUnimplemented {
+		t.Fatalf("open a stream to the endpoint without server: %v", err)
+	}
+}
+
+// the messages and the close of another session are not routed
+func TestEndpointAnotherSession(t *testing.T) {
+	conn, _ := serveEndpoint(t, newEchoServer())
+	ep := drpc.NewEndpoint(newEchoServer(), drpc.WithClock(dnet.SystemClock))
+	session := dnet.NewTCPSession(conn, append(ep.Options(), dnet.WithCodec(newCodec()))...)
+	ep.Attach(session)
+	defer session.Close(nil)
+
+	conn2, _ := serveEndpoint(t, newEchoServer())
+	session2 := dnet.NewTCPSession(conn2, dnet.WithCodec(newCodec()),
+		dnet.WithMessageCallback(func(dnet.Session, interface{}) {}))
+	defer session2.Close(nil)
+	ep.Attach(session2)
+	if ep.Session() != session {
+		t.Fatal("attach another session")
+	}
+	if _, err := ep.OnMessage(session2, &drpc.Request{Seq: 1, Method: "echo", Data: "hello"}); err != drpc.ErrEndpointSession {
+		t.Fatalf("message of another session: %v", err)
+	}
+
+	done := make(chan error, 1)
+	_ = ep.Go("wait", "hello", 10*time.Second, func(_ interface{}, err error) {
+		done <- err
+	})
+	ep.OnClose(session2, nil)
+	if ret, err := ep.Call("echo", "hello", time.Second); ret != "hello" || err != nil {
+		t.Fatalf("call after another session is closed: %v %v", ret, err)
+	}
+	select {
+	case err := <-done:
+		t.Fatalf("the call is failed by another session: %v", err)
+	default:
+	}
+}
@@ -0,0 +1,77 @@
+package drpc
+
+import (
+	"fmt"
+)
+
+// Code is an error code carried by a Response, so that the caller can
+// tell why a call failed without parsing the error text.
+type Code uint32
+
+const (
+	CodeOK                Code = 0  // not an error
+	CodeCanceled          Code = 1  // the call was canceled
+	CodeUnknown           Code = 2  // unknown error, default for plain errors
+	CodeInvalidArgument   Code = 3  // the argument is invalid
+	CodeDeadlineExceeded  Code = 4  // the call timed out
+	CodeNotFound          Code = 5  // the method is not found
+	CodeResourceExhausted Code = 8  // the server is saturated
+	CodeUnimplemented     Code = 12 // the method is not implemented
+	CodeInternal          Code = 13 // the handler panicked or broke an invariant
+	CodeUnavailable       Code = 14 // the connection or server is not available
+)
+
+var codeNames = map[Code]string{
+	CodeOK:                "OK",
+	CodeCanceled:          "Canceled",
+	CodeUnknown:           "Unknown",
+	CodeInvalidArgument:   "InvalidArgument",
+	CodeDeadlineExceeded:  "DeadlineExceeded",
+	CodeNotFound:          "NotFound",
+	CodeResourceExhausted: "ResourceExhausted",
+	CodeUnimplemented:     "Unimplemented",
+	CodeInternal:          "Internal",
+	CodeUnavailable:       "Unavailable",
+}
+
+func (c Code) String() string {
+	if name, ok := codeNames[c]; ok {
+		return name
+	}
+	return fmt.Sprintf("Code(%d)", uint32(c))
+}
+
+// Error is an error with a Code.
+// Errors returned by a remote handler reach the caller as *Error.
+type Error struct {
+	Code    Code
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// NewError returns an *Error with code and message.
+func NewError(code Code, message string) *Error {
+	return &Error{Code: code, Message: message}
+}
+
+// Errorf returns an *Error with code and formatted message.
+func Errorf(code Code, format string, a ...interface{}) *Error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, a...)}
+}
+
+// CodeOf returns the code of err.
+// It returns CodeOK for nil and CodeUnknown for errors which are not *Error.
+func CodeOf(err error) Code {
+	if err == nil {
+		return CodeOK
+	}
+	if e, ok := err.(*Error); ok {
+		return e.Code
+	}
+	return CodeUnknown
+}
+
+var ErrResourceExhausted = NewError(CodeResourceExhausted, "drpc: resource exhausted. ")
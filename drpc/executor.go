@@ -0,0 +1,128 @@
+package drpc
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// Executor runs method handlers for the Server.
+// Execute returns ErrResourceExhausted if the task can not be accepted.
+type Executor interface {
+	Execute(task func()) error
+}
+
+type inlineExecutor struct{}
+
+func (inlineExecutor) Execute(task func()) error {
+	task()
+	return nil
+}
+
+// InlineExecutor runs the handler on the goroutine which calls OnRPCRequest.
+// It is the default executor.
+func InlineExecutor() Executor {
+	return inlineExecutor{}
+}
+
+type goroutineExecutor struct{}
+
+func (goroutineExecutor) Execute(task func()) error {
+	go task()
+	return nil
+}
+
+// GoroutineExecutor runs each handler on a new goroutine.
+func GoroutineExecutor() Executor {
+	return goroutineExecutor{}
+}
+
+// PoolExecutor runs handlers on a fixed number of workers.
+// Tasks wait in a queue when all workers are busy, and are rejected
+// with ErrResourceExhausted when the queue is full.
+type PoolExecutor struct {
+	capacity  int32 // workers + queueSize
+	tasks     int32 // tasks queued or running
+	taskCh    chan func()
+	mtx       sync.RWMutex // no task is put after chClose is closed
+	closed    bool
+	chClose   chan struct{}
+	waitGroup sync.WaitGroup
+}
+
+// NewPoolExecutor returns a PoolExecutor with workers goroutines
+// and a queue of queueSize tasks.
+func NewPoolExecutor(workers, queueSize int) *PoolExecutor {
+	if workers <= 0 {
+		panic("drpc: NewPoolExecutor workers <= 0")
+	}
+	if queueSize < 0 {
+		queueSize = 0
+	}
+
+	pool := &PoolExecutor{
+		capacity: int32(workers + queueSize),
+		taskCh:   make(chan func(), workers+queueSize),
+		chClose:  make(chan struct{}),
+	}
+	pool.waitGroup.Add(workers)
+	for i := 0; i < workers; i++ {
+		go pool.worker()
+	}
+	return pool
+}
+
+func (pool *PoolExecutor) worker() {
+	defer pool.waitGroup.Done()
+	for {
+		select {
+		case task := <-pool.taskCh:
+			task()
+			atomic.AddInt32(&pool.tasks, -1)
+		case <-pool.chClose:
+			// finishes the tasks queued before Stop
+			for {
+				select {
+				case task := <-pool.taskCh:
+					task()
+					atomic.AddInt32(&pool.tasks, -1)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// Execute puts the task into the queue. The task is rejected after Stop.
+func (pool *PoolExecutor) Execute(task func()) error {
+	pool.mtx.RLock()
+	defer pool.mtx.RUnlock()
+	if pool.closed {
+		return ErrResourceExhausted
+	}
+
+	if atomic.AddInt32(&pool.tasks, 1) > pool.capacity {
+		atomic.AddInt32(&pool.tasks, -1)
+		return ErrResourceExhausted
+	}
+	// never blocks, the channel has room for capacity tasks
+	pool.taskCh <- task
+	return nil
+}
+
+// Tasks returns the number of tasks queued or running.
+func (pool *PoolExecutor) Tasks() int {
+	return int(atomic.LoadInt32(&pool.tasks))
+}
+
+// Stop rejects the new tasks, and stops the workers after the
+// tasks queued or running returned.
+func (pool *PoolExecutor) Stop() {
+	pool.mtx.Lock()
+	if !pool.closed {
+		pool.closed = true
+		close(pool.chClose)
+	}
+	pool.mtx.Unlock()
+	pool.waitGroup.Wait()
+}
@@ -0,0 +1,161 @@
+package drpc
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// recordChannel records the requests and responses sent on it.
+type recordChannel struct {
+	reqCh  chan *Request
+	respCh chan *Response
+}
+
+func newRecordChannel() *recordChannel {
+	return &recordChannel{reqCh: make(chan *Request, 64), respCh: make(chan *Response, 64)}
+}
+
+func (c *recordChannel) SendRequest(req *Request) error {
+	c.reqCh <- req
+	return nil
+}
+
+func (c *recordChannel) SendResponse(resp *Response) error {
+	c.respCh <- resp
+	return nil
+}
+
+func (c *recordChannel) response(t *testing.T) *Response {
+	t.Helper()
+	select {
+	case resp := <-c.respCh:
+		return resp
+	case <-time.After(time.Second):
+		t.Fatal("response timeout")
+		return nil
+	}
+}
+
+func TestPoolExecutor(t *testing.T) {
+	pool := NewPoolExecutor(1, 1)
+	block := make(chan struct{})
+	started := make(chan struct{})
+	if err := pool.Execute(func() { close(started); <-block }); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+
+	var done int32
+	if err := pool.Execute(func() { atomic.AddInt32(&done, 1) }); err != nil {
+		t.Fatal(err)
+	}
+	// 1 running and 1 queued, the queue is full
+	if err := pool.Execute(func() {}); err != ErrResourceExhausted {
+		t.Fatalf("execute on the full pool: %v", err)
+	}
+	if n := pool.Tasks(); n != 2 {
+		t.Fatalf("tasks %d", n)
+	}
+
+	// the queued task is finished by Stop, and the new ones are rejected
+	stopped := make(chan struct{})
+	go func() {
+		pool.Stop()
+		close(stopped)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	close(block)
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("stop timeout")
+	}
+	if atomic.LoadInt32(&done) != 1 || pool.Tasks() != 0 {
+		t.Fatalf("the queued task is dropped, done %d tasks %d", done, pool.Tasks())
+	}
+	if err := pool.Execute(func() {}); err != ErrResourceExhausted {
+		t.Fatalf("execute after stop: %v", err)
+	}
+}
+
+func TestMethodConcurrency(t *testing.T) {
+	server := NewServer(WithExecutor(GoroutineExecutor()), WithMethodConcurrency("slow", 1))
+	block := make(chan struct{})
+	server.Register("slow", func(replier *Replier, req interface{}) {
+		<-block
+		_ = replier.Reply(req, nil)
+	})
+
+	channel := newRecordChannel()
+	if err := server.OnRPCRequest(channel, &Request{Seq: 1, Method: "slow", Data: 1}); err != nil {
+		t.Fatal(err)
+	}
+	// the limit is reached, the request is rejected at once
+	if err := server.OnRPCRequest(channel, &Request{Seq: 2, Method: "slow", Data: 2}); err == nil {
+		t.Fatal("no error over the limit")
+	}
+	if resp := channel.response(t); resp.Seq != 2 || resp.Code != CodeResourceExhausted {
+		t.Fatalf("rejected response %+v", resp)
+	}
+
+	close(block)
+	if resp := channel.response(t); resp.Seq != 1 || resp.Error != "" {
+		t.Fatalf("response %+v", resp)
+	}
+	// the slot is released after the handler returned
+	if err := server.OnRPCRequest(channel, &Request{Seq: 3, Method: "slow", Data: 3}); err != nil {
+		t.Fatal(err)
+	}
+	if resp := channel.response(t); resp.Seq != 3 || resp.Data != 3 {
+		t.Fatalf("response %+v", resp)
+	}
+}
+
+func TestExecutorReject(t *testing.T) {
+	pool := NewPoolExecutor(1, 0)
+	defer pool.Stop()
+	server := NewServer(WithExecutor(pool), WithMethodConcurrency("echo", 2))
+	block := make(chan struct{})
+	server.Register("echo", func(replier *Replier, req interface{}) {
+		if req == "block" {
+			<-block
+		}
+		_ = replier.Reply(req, nil)
+	})
+
+	channel := newRecordChannel()
+	if err := server.OnRPCRequest(channel, &Request{Seq: 1, Method: "echo", Data: "block"}); err != nil {
+		t.Fatal(err)
+	}
+	// the pool is full, the request is rejected and its limit slot is released
+	for seq := uint64(2); seq < 5; seq++ {
+		if err := server.OnRPCRequest(channel, &Request{Seq: seq, Method: "echo", Data: "x"}); err == nil {
+			t.Fatal("no error on the full pool")
+		}
+		if resp := channel.response(t); resp.Seq != seq || resp.Code != CodeResourceExhausted {
+			t.Fatalf("rejected response %+v", resp)
+		}
+	}
+	if running := atomic.LoadInt32(&server.limits["echo"].running); running != 1 {
+		t.Fatalf("running %d", running)
+	}
+	close(block)
+	if resp := channel.response(t); resp.Seq != 1 {
+		t.Fatalf("response %+v", resp)
+	}
+}
+
+func TestMethodPanic(t *testing.T) {
+	server := NewServer()
+	server.Register("panic", func(replier *Replier, req interface{}) {
+		panic("boom")
+	})
+	channel := newRecordChannel()
+	if err := server.OnRPCRequest(channel, &Request{Seq: 1, Method: "panic"}); err == nil {
+		t.Fatal("no error of panic")
+	}
+	if resp := channel.response(t); resp.Code != CodeInternal {
+		t.Fatalf("response %+v", resp)
+	}
+}
@@ -0,0 +1,68 @@
+package drpc
+
+import (
+	"time"
+)
+
+// Metadata is the key-value pairs sent along with a request.
+type Metadata map[string]string
+
+// Get returns the value of key.
+func (md Metadata) Get(key string) string {
+	if md == nil {
+		return ""
+	}
+	return md[key]
+}
+
+// ServerInterceptor intercepts the call of a method on the server.
+// It must call handler to continue the chain, or reply by replier to end it.
+// The result of the handler can be observed by replier.OnReply.
+//
+//	func logging(replier *drpc.Replier, req interface{}, handler drpc.MethodHandler) {
+//		start := time.Now()
+//		replier.OnReply(func(ret interface{}, err error) {
+//			log.Println(replier.Method(), time.Since(start), err)
+//		})
+//		handler(replier, req)
+//	}
+type ServerInterceptor func(replier *Replier, req interface{}, handler MethodHandler)
+
+// chainServerInterceptors returns a MethodHandler which calls the interceptors in order, then h.
+func chainServerInterceptors(interceptors []ServerInterceptor, h MethodHandler) MethodHandler {
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptor, next := interceptors[i], h
+		h = func(replier *Replier, req interface{}) {
+			interceptor(replier, req, next)
+		}
+	}
+	return h
+}
+
+// Invoker sends req on the channel, and callback will be called with the result.
+type Invoker func(channel RPCChannel, req *Request, timeout time.Duration, callback func(interface{}, error)) error
+
+// ClientInterceptor intercepts the call on the client.
+// It must call invoker to continue the chain. The result can be observed
+// by wrapping callback, and req.Meta can be set before invoking.
+//
+//	func logging(channel drpc.RPCChannel, req *drpc.Request, timeout time.Duration,
+//		callback func(interface{}, error), invoker drpc.Invoker) error {
+//		start := time.Now()
+//		return invoker(channel, req, timeout, func(ret interface{}, err error) {
+//			log.Println(req.Method, time.Since(start), err)
+//			callback(ret, err)
+//		})
+//	}
+type ClientInterceptor func(channel RPCChannel, req *Request, timeout time.Duration, callback func(interface{}, error), invoker Invoker) error
+
+// chainClientInterceptors returns an Invoker which calls the interceptors in order, then invoker.
+func chainClientInterceptors(interceptors []ClientInterceptor, invoker Invoker) Invoker {
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptor, next := interceptors[i], invoker
+		invoker = func(channel RPCChannel, req *Request, timeout time.Duration, callback func(interface{}, error)) error {
+			return interceptor(channel, req, timeout, callback, next)
+		}
+	}
+	return invoker
+}
@@ -0,0 +1,144 @@
+package drpc
+
+import (
+	"github.com/yddeng/dnet"
+	"github.com/yddeng/dnet/dnettest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// loopChannel sends the requests to server, and the responses to client.
+// The response of an inline handler reaches client before SendRequest returns.
+type loopChannel struct {
+	server *Server
+	client *Client
+}
+
+func (c *loopChannel) SendRequest(req *Request) error {
+	_ = c.server.OnRPCRequest(c, req)
+	return nil
+}
+
+func (c *loopChannel) SendResponse(resp *Response) error {
+	_ = c.client.OnRPCResponse(resp)
+	return nil
+}
+
+func TestInterceptors(t *testing.T) {
+	var (
+		mtx   sync.Mutex
+		order []string
+	)
+	add := func(s string) {
+		mtx.Lock()
+		order = append(order, s)
+		mtx.Unlock()
+	}
+
+	serverInterceptor := func(name string) ServerInterceptor {
+		return func(replier *Replier, req interface{}, handler MethodHandler) {
+			add(name + ":" + replier.Metadata().Get("token"))
+			replier.OnReply(func(ret interface{}, err error) {
+				add(name + " reply")
+			})
+			handler(replier, req)
+		}
+	}
+	server := NewServer(WithServerInterceptor(serverInterceptor("s1"), serverInterceptor("s2")))
+	server.Register("echo", func(replier *Replier, req interface{}) {
+		add("handler")
+		_ = replier.Reply(req, nil)
+	})
+
+	clientInterceptor := func(name string) ClientInterceptor {
+		return func(channel RPCChannel, req *Request, timeout time.Duration, callback func(interface{}, error), invoker Invoker) error {
+			add(name)
+			if req.Meta == nil {
+				req.Meta = Metadata{"token": "abc"}
+			}
+			return invoker(channel, req, timeout, func(ret interface{}, err error) {
+				add(name + " callback")
+				callback(ret, err)
+			})
+		}
+	}
+	client := NewClient(WithClock(dnet.SystemClock), WithClientInterceptor(clientInterceptor("c1"), clientInterceptor("c2")))
+
+	ret, err := client.Call(&loopChannel{server: server, client: client}, "echo", "hello", time.Second)
+	if ret != "hello" || err != nil {
+		t.Fatalf("call %v %v", ret, err)
+	}
+	want := []string{"c1", "c2", "s1:abc", "s2:abc", "handler", "s1 reply", "s2 reply", "c2 callback", "c1 callback"}
+	if !reflect.DeepEqual(order, want) {
+		t.Fatalf("order %v, want %v", order, want)
+	}
+	if n := client.Pending(); n != 0 {
+		t.Fatalf("pending %d", n)
+	}
+}
+
+// the response is handled before the timer of timeout is set
+func TestClientResponseBeforeTimer(t *testing.T) {
+	server := NewServer()
+	server.Register("echo", func(replier *Replier, req interface{}) {
+		_ = replier.Reply(req, nil)
+	})
+	clock := dnettest.NewFakeClock(time.Now())
+	client := NewClient(WithClock(clock))
+	channel := &loopChannel{server: server, client: client}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if ret, err := client.Call(channel, "echo", i, time.Second); ret != i || err != nil {
+				t.Errorf("call %v %v", ret, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	clock.Advance(time.Second)
+	if n := client.Pending(); n != 0 {
+		t.Fatalf("pending %d", n)
+	}
+}
+
+func TestClientTimeoutInterceptor(t *testing.T) {
+	clock := dnettest.NewFakeClock(time.Now())
+	var got error
+	client := NewClient(WithClock(clock), WithClientInterceptor(func(channel RPCChannel, req *Request, timeout time.Duration, callback func(interface{}, error), invoker Invoker) error {
+		return invoker(channel, req, timeout, func(ret interface{}, err error) {
+			got = err
+			callback(ret, err)
+		})
+	}))
+
+	done := make(chan error, 1)
+	if err := client.Go(newRecordChannel(), "echo", "hello", time.Second, func(_ interface{}, err error) {
+		done <- err
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if n := client.Pending(); n != 1 {
+		t.Fatalf("pending %d", n)
+	}
+	clock.Advance(time.Second)
+	select {
+	case err := <-done:
+		if err != ErrRPCTimeout || got != ErrRPCTimeout {
+			t.Fatalf("done by %v, interceptor %v", err, got)
+		}
+		if CodeOf(err) != CodeDeadlineExceeded {
+			t.Fatalf("code %s", CodeOf(err))
+		}
+	default:
+		t.Fatal("not done after the timeout")
+	}
+	if n := client.Pending(); n != 0 {
+		t.Fatalf("pending %d", n)
+	}
+}
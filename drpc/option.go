@@ -0,0 +1,395 @@
+package drpc
+
+import (
+	"github.com/yddeng/dnet"
+	"github.com/yddeng/timer"
+	"reflect"
+	"time"
+)
+
+type ServerOption func(opt *ServerOptions)
+
+// loadServerOptions returns an initialized *ServerOptions with options
+func loadServerOptions(options ...ServerOption) *ServerOptions {
+	opts := new(ServerOptions)
+	for _, option := range options {
+		option(opts)
+	}
+	return opts
+}
+
+// ServerOptions contains all options which will be applied when instantiating a server.
+type ServerOptions struct {
+	// runs the method handlers. default InlineExecutor
+	Executor Executor
+
+	// the max number of running handlers for each method.
+	// the request is rejected with ErrResourceExhausted when it is reached.
+	MethodConcurrency map[string]int
+
+	// intercept the method handlers, the first one is the outermost
+	Interceptors []ServerInterceptor
+
+	// registers the built-in methods of reflection, which list the methods to any client
+	EnableReflection bool
+
+	// returns the name of the request and response types listed by the reflection.
+	// default reflect.Type.String
+	TypeNamer func(t reflect.Type) string
+}
+
+// WithExecutor sets the executor which runs the method handlers.
+func WithExecutor(executor Executor) ServerOption {
+	return func(opt *ServerOptions) {
+		opt.Executor = executor
+	}
+}
+
+// WithMethodConcurrency sets the max number of running handlers for the method.
+func WithMethodConcurrency(method string, limit int) ServerOption {
+	return func(opt *ServerOptions) {
+		if opt.MethodConcurrency == nil {
+			opt.MethodConcurrency = map[string]int{}
+		}
+		opt.MethodConcurrency[method] = limit
+	}
+}
+
+// WithServerInterceptor appends interceptors to the server.
+func WithServerInterceptor(interceptors ...ServerInterceptor) ServerOption {
+	return func(opt *ServerOptions) {
+		opt.Interceptors = append(opt.Interceptors, interceptors...)
+	}
+}
+
+// WithReflection sets whether to register the built-in methods of reflection, it is disabled by default.
+func WithReflection(enabled bool) ServerOption {
+	return func(opt *ServerOptions) {
+		opt.EnableReflection = enabled
+	}
+}
+
+// WithTypeNamer sets the function which names the types listed by the reflection,
+// such as codec.TypeNamer to list the names on the wire.
+func WithTypeNamer(namer func(t reflect.Type) string) ServerOption {
+	return func(opt *ServerOptions) {
+		opt.TypeNamer = namer
+	}
+}
+
+type ClientOption func(opt *ClientOptions)
+
+// loadClientOptions returns an initialized *ClientOptions with options
+func loadClientOptions(options ...ClientOption) *ClientOptions {
+	opts := new(ClientOptions)
+	for _, option := range options {
+		option(opts)
+	}
+	return opts
+}
+
+// ClientOptions contains all options which will be applied when instantiating a client.
+type ClientOptions struct {
+	// the timer of the call timeout. default time wheel of 50ms * 200
+	TimerMgr timer.TimerMgr
+
+	// the clock of the call timeout, instead of TimerMgr if it is set
+	Clock dnet.Clock
+
+	// intercept the calls, the first one is the outermost
+	Interceptors []ClientInterceptor
+
+	// the number of messages a stream can receive before granting more. default DefaultStreamWindow
+	StreamWindow uint32
+
+	// the policies of the methods, hedging takes precedence over retry.
+	RetryPolicies   map[string]*RetryPolicy
+	HedgingPolicies map[string]*HedgingPolicy
+}
+
+// WithTimerMgr sets the timer manager of the client.
+func WithTimerMgr(timerMgr timer.TimerMgr) ClientOption {
+	return func(opt *ClientOptions) {
+		opt.TimerMgr = timerMgr
+	}
+}
+
+// WithClock sets the clock of the client, such as a fake clock of tests.
+func WithClock(clock dnet.Clock) ClientOption {
+	return func(opt *ClientOptions) {
+		opt.Clock = clock
+	}
+}
+
+// WithClientInterceptor appends interceptors to the client.
+func WithClientInterceptor(interceptors ...ClientInterceptor) ClientOption {
+	return func(opt *ClientOptions) {
+		opt.Interceptors = append(opt.Interceptors, interceptors...)
+	}
+}
+
+// WithStreamWindow sets the receive window of the streams opened by the client.
+func WithStreamWindow(window uint32) ClientOption {
+	return func(opt *ClientOptions) {
+		opt.StreamWindow = window
+	}
+}
+
+// WithMethodRetry sets the retry policy of the method.
+func WithMethodRetry(method string, policy *RetryPolicy) ClientOption {
+	return func(opt *ClientOptions) {
+		if opt.RetryPolicies == nil {
+			opt.RetryPolicies = map[string]*RetryPolicy{}
+		}
+		opt.RetryPolicies[method] = policy
+	}
+}
+
+// WithMethodHedging sets the hedging policy of the method.
+func WithMethodHedging(method string, policy *HedgingPolicy) ClientOption {
+	return func(opt *ClientOptions) {
+		if opt.HedgingPolicies == nil {
+			opt.HedgingPolicies = map[string]*HedgingPolicy{}
+		}
+		opt.HedgingPolicies[method] = policy
+	}
+}
+
+type CallOption func(opt *CallOptions)
+
+// loadCallOptions returns an initialized *CallOptions with options
+func loadCallOptions(options ...CallOption) *CallOptions {
+	opts := new(CallOptions)
+	for _, option := range options {
+		option(opts)
+	}
+	return opts
+}
+
+// CallOptions contains all options which will be applied to a call.
+type CallOptions struct {
+	// the call can be sent more than once,
+	// it is retried on another client of the Balancer when it fails with CodeUnavailable.
+	Idempotent bool
+
+	// the key of ConsistentHash policy
+	HashKey string
+
+	// the policies of the call, they take precedence over the policies of the method.
+	Retry   *RetryPolicy
+	Hedging *HedgingPolicy
+}
+
+// WithIdempotent marks the call as idempotent.
+func WithIdempotent() CallOption {
+	return func(opt *CallOptions) {
+		opt.Idempotent = true
+	}
+}
+
+// WithHashKey sets the key by which ConsistentHash picks the client.
+func WithHashKey(key string) CallOption {
+	return func(opt *CallOptions) {
+		opt.HashKey = key
+	}
+}
+
+// WithRetry sets the retry policy of the call.
+func WithRetry(policy *RetryPolicy) CallOption {
+	return func(opt *CallOptions) {
+		opt.Retry = policy
+	}
+}
+
+// WithHedging sets the hedging policy of the call.
+func WithHedging(policy *HedgingPolicy) CallOption {
+	return func(opt *CallOptions) {
+		opt.Hedging = policy
+	}
+}
+
+type BreakerOption func(opt *BreakerOptions)
+
+// loadBreakerOptions returns an initialized *BreakerOptions with options
+func loadBreakerOptions(options ...BreakerOption) *BreakerOptions {
+	opts := new(BreakerOptions)
+	for _, option := range options {
+		option(opts)
+	}
+	return opts
+}
+
+// BreakerOptions contains all options which will be applied when instantiating a circuit breaker.
+type BreakerOptions struct {
+	// trips when the ratio of failures reaches FailureRatio,
+	// with MinRequests calls at least in the window. 0 disables it
+	FailureRatio float64
+	MinRequests  int
+
+	// the calls are counted in the window of time. default 10s
+	Window time.Duration
+
+	// trips after the number of calls in a row fail with CodeDeadlineExceeded. 0 disables it
+	ConsecutiveTimeouts int
+
+	// how long the circuit is open before it half-opens. default 5s
+	OpenTimeout time.Duration
+
+	// the number of probe calls in the half-open state, the circuit
+	// closes when all of them succeed. default 1
+	HalfOpenRequests int
+
+	// the codes of errors counted as failures.
+	// default CodeUnavailable, CodeDeadlineExceeded, CodeResourceExhausted and CodeInternal
+	FailureCodes []Code
+
+	// called when the state of a circuit changes
+	OnStateChange func(channel RPCChannel, method string, from, to BreakerState)
+
+	// tells the time of the windows and the open timeout. default dnet.SystemClock
+	Clock dnet.Clock
+}
+
+// WithBreakerFailureRatio trips the circuit when the ratio of failures
+// reaches ratio, with minRequests calls at least in the window.
+func WithBreakerFailureRatio(ratio float64, minRequests int) BreakerOption {
+	return func(opt *BreakerOptions) {
+		opt.FailureRatio = ratio
+		opt.MinRequests = minRequests
+	}
+}
+
+// WithBreakerWindow sets the window of time in which the calls are counted.
+func WithBreakerWindow(window time.Duration) BreakerOption {
+	return func(opt *BreakerOptions) {
+		opt.Window = window
+	}
+}
+
+// WithBreakerConsecutiveTimeouts trips the circuit after n calls in a row time out.
+func WithBreakerConsecutiveTimeouts(n int) BreakerOption {
+	return func(opt *BreakerOptions) {
+		opt.ConsecutiveTimeouts = n
+	}
+}
+
+// WithBreakerOpenTimeout sets how long the circuit is open before it half-opens.
+func WithBreakerOpenTimeout(timeout time.Duration) BreakerOption {
+	return func(opt *BreakerOptions) {
+		opt.OpenTimeout = timeout
+	}
+}
+
+// WithBreakerHalfOpenRequests sets the number of probe calls in the half-open state.
+func WithBreakerHalfOpenRequests(n int) BreakerOption {
+	return func(opt *BreakerOptions) {
+		opt.HalfOpenRequests = n
+	}
+}
+
+// WithBreakerFailureCodes sets the codes of errors counted as failures.
+func WithBreakerFailureCodes(codes ...Code) BreakerOption {
+	return func(opt *BreakerOptions) {
+		opt.FailureCodes = codes
+	}
+}
+
+// WithBreakerClock sets the clock of the breaker.
+func WithBreakerClock(clock dnet.Clock) BreakerOption {
+	return func(opt *BreakerOptions) {
+		opt.Clock = clock
+	}
+}
+
+// WithBreakerStateChange sets the callback of the state changes.
+func WithBreakerStateChange(f func(channel RPCChannel, method string, from, to BreakerState)) BreakerOption {
+	return func(opt *BreakerOptions) {
+		opt.OnStateChange = f
+	}
+}
+
+type ResolverOption func(opt *ResolverOptions)
+
+// loadResolverOptions returns an initialized *ResolverOptions with options
+func loadResolverOptions(options ...ResolverOption) *ResolverOptions {
+	opts := new(ResolverOptions)
+	for _, option := range options {
+		option(opts)
+	}
+	return opts
+}
+
+// ResolverOptions contains all options which will be applied when instantiating a resolver.
+type ResolverOptions struct {
+	// picks the client of the calls. default RoundRobin
+	Policy Policy
+
+	// the timeout of DialTCP. default 3s
+	DialTimeout time.Duration
+
+	// the interval to dial again an instance which fails to dial or is disconnected. default 1s
+	ReconnectInterval time.Duration
+
+	// returns the codec of a session, the codec is not shared by the sessions
+	// as it keeps the state of decoding.
+	NewCodec func() dnet.Codec
+
+	// the options of the sessions.
+	// the codec, message and close callbacks are set by the resolver.
+	SessionOptions []dnet.Option
+
+	// the options of the clients
+	ClientOptions []ClientOption
+
+	// serves the calls from the instances, nil if there is none
+	Server *Server
+}
+
+// WithResolverPolicy sets the policy of the Balancer.
+func WithResolverPolicy(policy Policy) ResolverOption {
+	return func(opt *ResolverOptions) {
+		opt.Policy = policy
+	}
+}
+
+// WithResolverDialTimeout sets the timeout of dialing an instance.
+func WithResolverDialTimeout(timeout time.Duration) ResolverOption {
+	return func(opt *ResolverOptions) {
+		opt.DialTimeout = timeout
+	}
+}
+
+// WithResolverReconnect sets the interval to dial again an instance.
+func WithResolverReconnect(interval time.Duration) ResolverOption {
+	return func(opt *ResolverOptions) {
+		opt.ReconnectInterval = interval
+	}
+}
+
+// WithResolverCodec sets the function which returns the codec of a session.
+func WithResolverCodec(newCodec func() dnet.Codec) ResolverOption {
+	return func(opt *ResolverOptions) {
+		opt.NewCodec = newCodec
+	}
+}
+
+// WithResolverSession appends the options of the sessions.
+func WithResolverSession(options ...dnet.Option) ResolverOption {
+	return func(opt *ResolverOptions) {
+		opt.SessionOptions = append(opt.SessionOptions, options...)
+	}
+}
+
+// WithResolverClient appends the options of the clients.
+func WithResolverClient(options ...ClientOption) ResolverOption {
+	return func(opt *ResolverOptions) {
+		opt.ClientOptions = append(opt.ClientOptions, options...)
+	}
+}
+
+// WithResolverServer sets the server which serves the calls from the instances.
+func WithResolverServer(server *Server) ResolverOption {
+	return func(opt *ResolverOptions) {
+		opt.Server = server
+	}
+}
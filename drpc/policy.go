@@ -0,0 +1,187 @@
+package drpc
+
+import (
+	"fmt"
+	"hash/crc32"
+	"math/rand"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Policy picks a client for each call of the Balancer.
+// Update is called with all the clients when the set changes,
+// Pick returns a client which is not excluded, nil if there is none.
+type Policy interface {
+	Update(clients []*ChannelClient)
+	Pick(key string, exclude func(c *ChannelClient) bool) *ChannelClient
+}
+
+type roundRobin struct {
+	mtx     sync.Mutex
+	clients []*ChannelClient
+	next    int
+}
+
+// RoundRobin returns a Policy which picks the clients in turn.
+func RoundRobin() Policy {
+	return &roundRobin{}
+}
+
+func (p *roundRobin) Update(clients []*ChannelClient) {
+	p.mtx.Lock()
+	p.clients = clients
+	p.mtx.Unlock()
+}
+
+func (p *roundRobin) Pick(key string, exclude func(c *ChannelClient) bool) *ChannelClient {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	for i := 0; i < len(p.clients); i++ {
+		c := p.clients[(p.next+i)%len(p.clients)]
+		if !exclude(c) {
+			p.next = (p.next + i + 1) % len(p.clients)
+			return c
+		}
+	}
+	return nil
+}
+
+type leastPending struct {
+	mtx     sync.Mutex
+	clients []*ChannelClient
+}
+
+// LeastPending returns a Policy which picks the client with the fewest calls waiting for the response.
+func LeastPending() Policy {
+	return &leastPending{}
+}
+
+func (p *leastPending) Update(clients []*ChannelClient) {
+	p.mtx.Lock()
+	p.clients = clients
+	p.mtx.Unlock()
+}
+
+func (p *leastPending) Pick(key string, exclude func(c *ChannelClient) bool) *ChannelClient {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	var picked *ChannelClient
+	min := 0
+	for _, c := range p.clients {
+		if exclude(c) {
+			continue
+		}
+		if n := c.Pending(); picked == nil || n < min {
+			picked, min = c, n
+		}
+	}
+	return picked
+}
+
+type random struct {
+	mtx     sync.Mutex
+	clients []*ChannelClient
+	rand    *rand.Rand
+}
+
+// Random returns a Policy which picks a client at random.
+func Random() Policy {
+	return &random{rand: rand.New(rand.NewSource(time.Now().UnixNano()))}
+}
+
+func (p *random) Update(clients []*ChannelClient) {
+	p.mtx.Lock()
+	p.clients = clients
+	p.mtx.Unlock()
+}
+
+func (p *random) Pick(key string, exclude func(c *ChannelClient) bool) *ChannelClient {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	if len(p.clients) == 0 {
+		return nil
+	}
+	start := p.rand.Intn(len(p.clients))
+	for i := 0; i < len(p.clients); i++ {
+		if c := p.clients[(start+i)%len(p.clients)]; !exclude(c) {
+			return c
+		}
+	}
+	return nil
+}
+
+// DefaultHashReplicas is the number of points of each client on the hash ring.
+const DefaultHashReplicas = 160
+
+type hashPoint struct {
+	hash   uint32
+	client *ChannelClient
+}
+
+type consistentHash struct {
+	mtx      sync.Mutex
+	replicas int
+	ring     []hashPoint // sorted by hash
+	fallback Policy
+}
+
+// ConsistentHash returns a Policy which picks the client by the hash key of call,
+// so the calls with the same key go to the same client while the set is unchanged.
+// A client is placed on the ring by its remote address with replicas points,
+// replicas is DefaultHashReplicas if it is not positive.
+// The calls without hash key are picked in turn.
+func ConsistentHash(replicas int) Policy {
+	if replicas <= 0 {
+		replicas = DefaultHashReplicas
+	}
+	return &consistentHash{replicas: replicas, fallback: RoundRobin()}
+}
+
+func (p *consistentHash) Update(clients []*ChannelClient) {
+	ring := make([]hashPoint, 0, len(clients)*p.replicas)
+	for _, c := range clients {
+		name := clientName(c)
+		for i := 0; i < p.replicas; i++ {
+			ring = append(ring, hashPoint{hash: crc32.ChecksumIEEE([]byte(name + "#" + strconv.Itoa(i))), client: c})
+		}
+	}
+	sort.Slice(ring, func(i, j int) bool { return ring[i].hash < ring[j].hash })
+
+	p.mtx.Lock()
+	p.ring = ring
+	p.mtx.Unlock()
+	p.fallback.Update(clients)
+}
+
+func (p *consistentHash) Pick(key string, exclude func(c *ChannelClient) bool) *ChannelClient {
+	if key == "" {
+		return p.fallback.Pick(key, exclude)
+	}
+
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	if len(p.ring) == 0 {
+		return nil
+	}
+	hash := crc32.ChecksumIEEE([]byte(key))
+	start := sort.Search(len(p.ring), func(i int) bool { return p.ring[i].hash >= hash })
+	// walk clockwise to the first client not excluded
+	for i := 0; i < len(p.ring); i++ {
+		if c := p.ring[(start+i)%len(p.ring)].client; !exclude(c) {
+			return c
+		}
+	}
+	return nil
+}
+
+// clientName returns the name of client on the hash ring.
+func clientName(c *ChannelClient) string {
+	if session := c.Session(); session != nil {
+		if addr := session.RemoteAddr(); addr != nil {
+			return addr.String()
+		}
+	}
+	return fmt.Sprintf("%p", c)
+}
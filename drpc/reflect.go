@@ -0,0 +1,148 @@
+package drpc
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// ReflectListMethods is the name of the built-in method which lists the methods of server.
+// It replies the JSON of []MethodInfo as []byte, which is carried by any codec.
+// It is registered if the server is created with WithReflection(true).
+//
+//	ret, err := client.Call(drpc.ReflectListMethods, nil, drpc.DefaultRPCTimeout)
+//	methods, err := drpc.DecodeMethodList(ret)
+const ReflectListMethods = "drpc.reflect.ListMethods"
+
+const (
+	MethodUnary  = "unary"
+	MethodNotify = "notify"
+	MethodStream = "stream"
+)
+
+// MethodInfo describes a method registered on the server.
+// The types are known if it is registered by RegisterTyped.
+type MethodInfo struct {
+	Name     string `json:"name"`
+	Kind     string `json:"kind"` // MethodUnary, MethodNotify or MethodStream
+	Request  string `json:"request,omitempty"`
+	Response string `json:"response,omitempty"`
+}
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+// RegisterTyped Register the method on the server whit name by a function
+// of the form func(req T) (R, error), the types are listed by the reflection.
+// The request of other types fails with CodeInvalidArgument.
+//
+//	server.RegisterTyped("echo", func(req *pb.EchoToS) (*pb.EchoToC, error) {
+//		return &pb.EchoToC{Msg: req.Msg}, nil
+//	})
+func (server *Server) RegisterTyped(name string, fn interface{}) {
+	if fn == nil {
+		panic(fmt.Sprintf("drpc:RegisterTyped %s fn == nil", name))
+	}
+	v := reflect.ValueOf(fn)
+	t := v.Type()
+	if t.Kind() != reflect.Func || t.NumIn() != 1 || t.NumOut() != 2 || t.Out(1) != errorType {
+		panic(fmt.Sprintf("drpc:RegisterTyped %s fn is %s, want func(T) (R, error)", name, t))
+	}
+	if v.IsNil() {
+		panic(fmt.Sprintf("drpc:RegisterTyped %s fn == nil", name))
+	}
+	reqType, respType := t.In(0), t.Out(0)
+
+	h := func(replier *Replier, req interface{}) {
+		var arg reflect.Value
+		switch {
+		case req != nil && reflect.TypeOf(req).AssignableTo(reqType):
+			arg = reflect.ValueOf(req)
+		case req == nil && isNillable(reqType):
+			arg = reflect.Zero(reqType)
+		default:
+			_ = replier.Reply(nil, Errorf(CodeInvalidArgument, "drpc: method %s request is %T, want %s", name, req, reqType))
+			return
+		}
+
+		out := v.Call([]reflect.Value{arg})
+		if err, _ := out[1].Interface().(error); err != nil {
+			_ = replier.Reply(nil, err)
+			return
+		}
+		_ = replier.Reply(out[0].Interface(), nil)
+	}
+	server.register(&methodDesc{
+		name:     name,
+		handler:  chainServerInterceptors(server.opts.Interceptors, h),
+		reqType:  reqType,
+		respType: respType,
+	})
+}
+
+func isNillable(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Ptr, reflect.Interface, reflect.Map, reflect.Slice:
+		return true
+	default:
+		return false
+	}
+}
+
+// ListMethods returns the methods registered on the server, sorted by name.
+func (server *Server) ListMethods() []MethodInfo {
+	server.mtx.RLock()
+	methods := make([]MethodInfo, 0, len(server.methods))
+	for _, desc := range server.methods {
+		info := MethodInfo{Name: desc.name, Kind: MethodUnary}
+		switch {
+		case desc.stream != nil:
+			info.Kind = MethodStream
+		case desc.notify:
+			info.Kind = MethodNotify
+		}
+		if desc.reqType != nil {
+			info.Request = server.typeName(desc.reqType)
+			info.Response = server.typeName(desc.respType)
+		}
+		methods = append(methods, info)
+	}
+	server.mtx.RUnlock()
+
+	sort.Slice(methods, func(i, j int) bool { return methods[i].Name < methods[j].Name })
+	return methods
+}
+
+func (server *Server) typeName(t reflect.Type) string {
+	if server.opts.TypeNamer != nil {
+		if name := server.opts.TypeNamer(t); name != "" {
+			return name
+		}
+	}
+	return t.String()
+}
+
+// registerReflection registers the built-in methods of reflection.
+func (server *Server) registerReflection() {
+	server.Register(ReflectListMethods, func(replier *Replier, _ interface{}) {
+		data, err := json.Marshal(server.ListMethods())
+		if err != nil {
+			_ = replier.Reply(nil, Errorf(CodeInternal, "drpc: %s %v", ReflectListMethods, err))
+			return
+		}
+		_ = replier.Reply(data, nil)
+	})
+}
+
+// DecodeMethodList decodes the result of ReflectListMethods.
+func DecodeMethodList(ret interface{}) ([]MethodInfo, error) {
+	data, ok := ret.([]byte)
+	if !ok {
+		return nil, fmt.Errorf("drpc: DecodeMethodList result is %T, want []byte", ret)
+	}
+	var methods []MethodInfo
+	if err := json.Unmarshal(data, &methods); err != nil {
+		return nil, err
+	}
+	return methods, nil
+}
@@ -0,0 +1,102 @@
+package drpc
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type echoReq struct{ Msg string }
+
+func TestReflection(t *testing.T) {
+	channel := newRecordChannel()
+
+	// reflection is disabled by default
+	server := NewServer()
+	if err := server.OnRPCRequest(channel, &Request{Seq: 1, Method: ReflectListMethods}); err == nil {
+		t.Fatal("reflection is enabled by default")
+	}
+
+	server = NewServer(WithReflection(true), WithTypeNamer(func(t reflect.Type) string {
+		return "test." + t.Elem().Name()
+	}))
+	server.RegisterTyped("echo", func(req *echoReq) (*echoReq, error) { return req, nil })
+	server.RegisterNotify("event", func(req interface{}) {})
+	server.RegisterStream("watch", func(stream *Stream) error { return nil })
+	if err := server.OnRPCRequest(channel, &Request{Seq: 1, Method: ReflectListMethods}); err != nil {
+		t.Fatal(err)
+	}
+	methods, err := DecodeMethodList(channel.response(t).Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []MethodInfo{
+		{Name: ReflectListMethods, Kind: MethodUnary},
+		{Name: "echo", Kind: MethodUnary, Request: "test.echoReq", Response: "test.echoReq"},
+		{Name: "event", Kind: MethodNotify},
+		{Name: "watch", Kind: MethodStream},
+	}
+	if !reflect.DeepEqual(methods, want) {
+		t.Fatalf("methods %+v, want %+v", methods, want)
+	}
+
+	if _, err := DecodeMethodList("bad"); err == nil {
+		t.Fatal("decode a result which is not []byte")
+	}
+}
+
+func TestRegisterTyped(t *testing.T) {
+	server := NewServer()
+	server.RegisterTyped("echo", func(req *echoReq) (*echoReq, error) {
+		if req == nil {
+			return nil, errors.New("drpc: nil request")
+		}
+		return &echoReq{Msg: req.Msg}, nil
+	})
+	channel := newRecordChannel()
+
+	for _, test := range []struct {
+		req  interface{}
+		code Code
+	}{
+		{&echoReq{Msg: "hello"}, CodeOK},
+		{nil, CodeUnknown},
+		{"hello", CodeInvalidArgument},
+		{echoReq{}, CodeInvalidArgument},
+	} {
+		_ = server.OnRPCRequest(channel, &Request{Seq: 1, Method: "echo", Data: test.req})
+		resp := channel.response(t)
+		if code := resp.Code; code != test.code {
+			t.Fatalf("request %#v: code %s, want %s", test.req, code, test.code)
+		}
+		if test.code == CodeOK && resp.Data.(*echoReq).Msg != "hello" {
+			t.Fatalf("response %+v", resp.Data)
+		}
+	}
+}
+
+func TestRegisterTypedInvalid(t *testing.T) {
+	var nilFunc func(req *echoReq) (*echoReq, error)
+	for _, fn := range []interface{}{
+		nil,
+		nilFunc,
+		"echo",
+		func(req *echoReq) *echoReq { return req },
+		func(a, b *echoReq) (*echoReq, error) { return a, nil },
+	} {
+		func() {
+			defer func() {
+				r := recover()
+				if r == nil {
+					t.Fatalf("no panic of %T", fn)
+				}
+				if msg := fmt.Sprint(r); !strings.HasPrefix(msg, "drpc:RegisterTyped echo") {
+					t.Fatalf("panic of %T: %s", fn, msg)
+				}
+			}()
+			NewServer().RegisterTyped("echo", fn)
+		}()
+	}
+}
@@ -0,0 +1,176 @@
+package registry
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// DefaultPollInterval is the interval the FileRegistry reads the file for the watchers.
+const DefaultPollInterval = time.Second
+
+// FileRegistry is a Registry in a JSON file, which can be shared by processes
+// on a host, or edited by hand to list the static addresses:
+//
+//	{
+//		"echo": [
+//			{"id": "echo-1", "addr": "10.0.0.1:7756"},
+//			{"id": "echo-2", "addr": "10.0.0.2:7756"}
+//		]
+//	}
+//
+// The file is replaced as a whole by Register and Deregister, the updates of
+// processes at the same moment may be lost. The watchers read the file by poll.
+type FileRegistry struct {
+	path     string
+	interval time.Duration
+	mtx      sync.Mutex // serializes the updates of this process
+}
+
+// NewFileRegistry returns a FileRegistry of the file at path.
+// The file is created by the first Register if it does not exist.
+// interval is DefaultPollInterval if it is not positive.
+func NewFileRegistry(path string, interval time.Duration) *FileRegistry {
+	if interval <= 0 {
+		interval = DefaultPollInterval
+	}
+	return &FileRegistry{path: path, interval: interval}
+}
+
+func (r *FileRegistry) Register(inst *Instance) error {
+	if inst == nil || inst.Service == "" || inst.ID == "" {
+		return errors.New("registry: Register instance without service or id. ")
+	}
+
+	return r.update(func(services map[string][]*Instance) {
+		instances := services[inst.Service]
+		for i, v := range instances {
+			if v.ID == inst.ID {
+				instances[i] = copyInstance(inst)
+				return
+			}
+		}
+		services[inst.Service] = append(instances, copyInstance(inst))
+	})
+}
+
+func (r *FileRegistry) Deregister(inst *Instance) error {
+	if inst == nil {
+		return errors.New("registry: Deregister instance is nil. ")
+	}
+
+	return r.update(func(services map[string][]*Instance) {
+		instances := services[inst.Service]
+		for i, v := range instances {
+			if v.ID == inst.ID {
+				instances = append(instances[:i], instances[i+1:]...)
+				break
+			}
+		}
+		if len(instances) == 0 {
+			delete(services, inst.Service)
+		} else {
+			services[inst.Service] = instances
+		}
+	})
+}
+
+func (r *FileRegistry) Watch(service string) (Watcher, error) {
+	instances, err := r.list(service)
+	if err != nil {
+		return nil, err
+	}
+
+	chStop := make(chan struct{})
+	w := newWatcher(instances, func() { close(chStop) })
+	go func() {
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-chStop:
+				return
+			case <-ticker.C:
+				// keep the instances if the file can not be read at the moment
+				if latest, err := r.list(service); err == nil && !reflect.DeepEqual(latest, instances) {
+					instances = latest
+					w.update(instances)
+				}
+			}
+		}
+	}()
+	return w, nil
+}
+
+// list reads the instances of service from the file.
+func (r *FileRegistry) list(service string) ([]*Instance, error) {
+	services, err := r.load()
+	if err != nil {
+		return nil, err
+	}
+	instances := services[service]
+	for _, inst := range instances {
+		inst.Service = service
+	}
+	return sortInstances(instances), nil
+}
+
+// load reads all services from the file, a missing file has no service.
+func (r *FileRegistry) load() (map[string][]*Instance, error) {
+	services := map[string][]*Instance{}
+	data, err := ioutil.ReadFile(r.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return services, nil
+		}
+		return nil, err
+	}
+	if len(data) == 0 {
+		return services, nil
+	}
+	if err := json.Unmarshal(data, &services); err != nil {
+		return nil, err
+	}
+	return services, nil
+}
+
+// update changes the services by f, and replaces the file by rename.
+func (r *FileRegistry) update(f func(services map[string][]*Instance)) error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	services, err := r.load()
+	if err != nil {
+		return err
+	}
+	f(services)
+
+	data, err := json.MarshalIndent(services, "", "\t")
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(r.path), filepath.Base(r.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), r.path)
+}
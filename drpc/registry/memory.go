@@ -0,0 +1,98 @@
+package registry
+
+import (
+	"errors"
+	"sync"
+)
+
+// MemoryRegistry is a Registry in memory, shared by the clients and servers of a process.
+type MemoryRegistry struct {
+	mtx      sync.Mutex
+	services map[string]map[string]*Instance // service -> id -> instance
+	watchers map[string]map[*watcher]struct{}
+}
+
+// NewMemoryRegistry returns an empty MemoryRegistry.
+func NewMemoryRegistry() *MemoryRegistry {
+	return &MemoryRegistry{
+		services: map[string]map[string]*Instance{},
+		watchers: map[string]map[*watcher]struct{}{},
+	}
+}
+
+func (r *MemoryRegistry) Register(inst *Instance) error {
+	if inst == nil || inst.Service == "" || inst.ID == "" {
+		return errors.New("registry: Register instance without service or id. ")
+	}
+
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	instances, ok := r.services[inst.Service]
+	if !ok {
+		instances = map[string]*Instance{}
+		r.services[inst.Service] = instances
+	}
+	instances[inst.ID] = copyInstance(inst)
+	r.notify(inst.Service)
+	return nil
+}
+
+func (r *MemoryRegistry) Deregister(inst *Instance) error {
+	if inst == nil {
+		return errors.New("registry: Deregister instance is nil. ")
+	}
+
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	instances, ok := r.services[inst.Service]
+	if !ok {
+		return nil
+	}
+	if _, ok := instances[inst.ID]; ok {
+		delete(instances, inst.ID)
+		if len(instances) == 0 {
+			delete(r.services, inst.Service)
+		}
+		r.notify(inst.Service)
+	}
+	return nil
+}
+
+func (r *MemoryRegistry) Watch(service string) (Watcher, error) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	var w *watcher
+	w = newWatcher(r.list(service), func() {
+		r.mtx.Lock()
+		delete(r.watchers[service], w)
+		if len(r.watchers[service]) == 0 {
+			delete(r.watchers, service)
+		}
+		r.mtx.Unlock()
+	})
+	if r.watchers[service] == nil {
+		r.watchers[service] = map[*watcher]struct{}{}
+	}
+	r.watchers[service][w] = struct{}{}
+	return w, nil
+}
+
+// list returns the instances of service, it must be called with mtx held.
+func (r *MemoryRegistry) list(service string) []*Instance {
+	instances := make([]*Instance, 0, len(r.services[service]))
+	for _, inst := range r.services[service] {
+		instances = append(instances, inst)
+	}
+	return sortInstances(instances)
+}
+
+// notify updates the watchers of service, it must be called with mtx held.
+func (r *MemoryRegistry) notify(service string) {
+	if len(r.watchers[service]) == 0 {
+		return
+	}
+	instances := r.list(service)
+	for w := range r.watchers[service] {
+		w.update(instances)
+	}
+}
@@ -0,0 +1,108 @@
+// Package registry is the service discovery of drpc.
+// The instances of a service are registered by name, and watched by the clients.
+package registry
+
+import (
+	"errors"
+	"sort"
+	"sync"
+)
+
+var ErrWatcherStopped = errors.New("registry: watcher stopped. ")
+
+// Instance is an instance of a service.
+type Instance struct {
+	Service string            `json:"-"`
+	ID      string            `json:"id"`   // unique in the service
+	Addr    string            `json:"addr"` // the address to dial
+	Meta    map[string]string `json:"meta,omitempty"`
+}
+
+// Registry registers the instances and watches the changes of a service.
+type Registry interface {
+	// Register adds the instance, or replaces the instance of the same ID.
+	Register(inst *Instance) error
+
+	// Deregister removes the instance by its service and ID.
+	Deregister(inst *Instance) error
+
+	// Watch returns a Watcher of the instances of service.
+	Watch(service string) (Watcher, error)
+}
+
+// Watcher watches the instances of a service.
+type Watcher interface {
+	// Next blocks until the instances change, and returns all of them.
+	// The first call returns the current instances at once.
+	// It returns ErrWatcherStopped after Stop.
+	Next() ([]*Instance, error)
+
+	// Stop stops the watcher, and wakes up Next.
+	Stop()
+}
+
+// watcher keeps the latest instances, Next returns them once for each version.
+type watcher struct {
+	mtx       sync.Mutex
+	cond      *sync.Cond
+	instances []*Instance
+	version   uint64
+	seen      uint64
+	stopped   bool
+	onStop    func()
+}
+
+func newWatcher(instances []*Instance, onStop func()) *watcher {
+	w := &watcher{instances: instances, version: 1, onStop: onStop}
+	w.cond = sync.NewCond(&w.mtx)
+	return w
+}
+
+// update sets the instances and wakes up Next.
+func (w *watcher) update(instances []*Instance) {
+	w.mtx.Lock()
+	w.instances = instances
+	w.version++
+	w.cond.Broadcast()
+	w.mtx.Unlock()
+}
+
+func (w *watcher) Next() ([]*Instance, error) {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	for w.seen == w.version && !w.stopped {
+		w.cond.Wait()
+	}
+	if w.stopped {
+		return nil, ErrWatcherStopped
+	}
+	w.seen = w.version
+	return w.instances, nil
+}
+
+func (w *watcher) Stop() {
+	w.mtx.Lock()
+	if w.stopped {
+		w.mtx.Unlock()
+		return
+	}
+	w.stopped = true
+	w.cond.Broadcast()
+	w.mtx.Unlock()
+
+	if w.onStop != nil {
+		w.onStop()
+	}
+}
+
+// sortInstances sorts the instances by ID.
+func sortInstances(instances []*Instance) []*Instance {
+	sort.Slice(instances, func(i, j int) bool { return instances[i].ID < instances[j].ID })
+	return instances
+}
+
+// copyInstance returns a copy of inst, the Meta is shared.
+func copyInstance(inst *Instance) *Instance {
+	i := *inst
+	return &i
+}
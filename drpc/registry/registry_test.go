@@ -0,0 +1,78 @@
+package registry
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func next(t *testing.T, w Watcher) []*Instance {
+	ch := make(chan []*Instance, 1)
+	go func() {
+		instances, err := w.Next()
+		if err != nil {
+			t.Error(err)
+		}
+		ch <- instances
+	}()
+	select {
+	case instances := <-ch:
+		return instances
+	case <-time.After(3 * time.Second):
+		t.Fatal("Next timeout")
+		return nil
+	}
+}
+
+func testRegistry(t *testing.T, r Registry) {
+	a := &Instance{Service: "echo", ID: "a", Addr: "127.0.0.1:1"}
+	b := &Instance{Service: "echo", ID: "b", Addr: "127.0.0.1:2", Meta: map[string]string{"zone": "1"}}
+	if err := r.Register(a); err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := r.Watch("echo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if instances := next(t, w); len(instances) != 1 || instances[0].Addr != a.Addr || instances[0].Service != "echo" {
+		t.Fatal(instances)
+	}
+
+	if err := r.Register(b); err != nil {
+		t.Fatal(err)
+	}
+	if instances := next(t, w); len(instances) != 2 || instances[1].Meta["zone"] != "1" {
+		t.Fatal(instances)
+	}
+
+	if err := r.Register(&Instance{Service: "other", ID: "c", Addr: "127.0.0.1:3"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Deregister(a); err != nil {
+		t.Fatal(err)
+	}
+	if instances := next(t, w); len(instances) != 1 || instances[0].ID != "b" {
+		t.Fatal(instances)
+	}
+
+	w.Stop()
+	if _, err := w.Next(); err != ErrWatcherStopped {
+		t.Fatal(err)
+	}
+}
+
+func TestMemoryRegistry(t *testing.T) {
+	testRegistry(t, NewMemoryRegistry())
+}
+
+func TestFileRegistry(t *testing.T) {
+	dir, err := ioutil.TempDir("", "registry")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	testRegistry(t, NewFileRegistry(filepath.Join(dir, "services.json"), 10*time.Millisecond))
+}
@@ -0,0 +1,229 @@
+package drpc
+
+import (
+	"github.com/yddeng/dnet"
+	"github.com/yddeng/dnet/drpc/registry"
+	"sync"
+	"time"
+)
+
+// Resolver dials a TCP session to each instance of a service found in the registry,
+// and feeds their clients to a Balancer. The sessions are added and closed as the
+// registry changes, and a session closed by the instance is dialed again.
+//
+//	resolver := drpc.NewResolver(reg, "echo", drpc.WithResolverCodec(func() dnet.Codec {
+//		return codec.NewCodec(codec.NewProtobufMarshaler())
+//	}))
+//	if err := resolver.Start(); err != nil {
+//		panic(err)
+//	}
+//	ret, err := resolver.Balancer().Call(method, arg, drpc.DefaultRPCTimeout)
+type Resolver struct {
+	registry registry.Registry
+	service  string
+	opts     *ResolverOptions
+	balancer *Balancer
+	watcher  registry.Watcher
+
+	mtx     sync.Mutex
+	conns   map[string]*resolverConn // instance id -> conn
+	stopped bool
+}
+
+// resolverConn is the session to an instance.
+type resolverConn struct {
+	inst    *registry.Instance
+	session dnet.Session   // nil while dialing
+	client  *ChannelClient // nil while dialing
+	removed bool           // the instance is removed
+}
+
+// NewResolver returns a Resolver of the service in registry.
+// The codec of sessions must be set by WithResolverCodec.
+func NewResolver(registry registry.Registry, service string, options ...ResolverOption) *Resolver {
+	opts := loadResolverOptions(options...)
+	if opts.DialTimeout <= 0 {
+		opts.DialTimeout = 3 * time.Second
+	}
+	if opts.ReconnectInterval <= 0 {
+		opts.ReconnectInterval = time.Second
+	}
+	return &Resolver{
+		registry: registry,
+		service:  service,
+		opts:     opts,
+		balancer: NewBalancer(opts.Policy),
+		conns:    map[string]*resolverConn{},
+	}
+}
+
+// Balancer returns the Balancer of the clients.
+func (r *Resolver) Balancer() *Balancer {
+	return r.balancer
+}
+
+// Start watches the service, and dials the instances.
+// It returns after the first dials to the current instances are done.
+func (r *Resolver) Start() error {
+	watcher, err := r.registry.Watch(r.service)
+	if err != nil {
+		return err
+	}
+	instances, err := watcher.Next()
+	if err != nil {
+		return err
+	}
+
+	r.mtx.Lock()
+	r.watcher = watcher
+	r.mtx.Unlock()
+
+	var wg sync.WaitGroup
+	for _, rc := range r.update(instances) {
+		wg.Add(1)
+		go func(rc *resolverConn) {
+			defer wg.Done()
+			r.dial(rc)
+		}(rc)
+	}
+	wg.Wait()
+
+	go func() {
+		for {
+			instances, err := watcher.Next()
+			if err != nil {
+				return
+			}
+			for _, rc := range r.update(instances) {
+				go r.dial(rc)
+			}
+		}
+	}()
+	return nil
+}
+
+// Stop stops watching, and closes all the sessions.
+func (r *Resolver) Stop() {
+	r.mtx.Lock()
+	if r.stopped {
+		r.mtx.Unlock()
+		return
+	}
+	r.stopped = true
+	watcher := r.watcher
+	conns := r.conns
+	r.conns = map[string]*resolverConn{}
+	for _, rc := range conns {
+		rc.removed = true
+	}
+	r.mtx.Unlock()
+
+	if watcher != nil {
+		watcher.Stop()
+	}
+	for _, rc := range conns {
+		r.close(rc)
+	}
+}
+
+// update compares the instances with the conns, closes the removed ones,
+// and returns the new ones to dial.
+func (r *Resolver) update(instances []*registry.Instance) []*resolverConn {
+	r.mtx.Lock()
+	if r.stopped {
+		r.mtx.Unlock()
+		return nil
+	}
+
+	var added, removed []*resolverConn
+	current := map[string]bool{}
+	for _, inst := range instances {
+		current[inst.ID] = true
+		if rc, ok := r.conns[inst.ID]; ok {
+			if rc.inst.Addr == inst.Addr {
+				continue
+			}
+			// the instance moves to another address
+			rc.removed = true
+			removed = append(removed, rc)
+		}
+		rc := &resolverConn{inst: inst}
+		r.conns[inst.ID] = rc
+		added = append(added, rc)
+	}
+	for id, rc := range r.conns {
+		if !current[id] {
+			rc.removed = true
+			removed = append(removed, rc)
+			delete(r.conns, id)
+		}
+	}
+	r.mtx.Unlock()
+
+	for _, rc := range removed {
+		r.close(rc)
+	}
+	return added
+}
+
+// dial connects to the instance, it dials again after ReconnectInterval if it fails.
+func (r *Resolver) dial(rc *resolverConn) {
+	conn, err := dnet.DialTCP(rc.inst.Addr, r.opts.DialTimeout)
+	if err != nil {
+		r.redial(rc)
+		return
+	}
+
+	ep := NewEndpoint(r.opts.Server, r.opts.ClientOptions...)
+	options := make([]dnet.Option, 0, len(r.opts.SessionOptions)+3)
+	options = append(options, r.opts.SessionOptions...)
+	if r.opts.NewCodec != nil {
+		options = append(options, dnet.WithCodec(r.opts.NewCodec()))
+	}
+	session := dnet.NewTCPSession(conn, append(options, ep.Options()...)...)
+	ep.Attach(session)
+	client := ep.Client()
+
+	r.mtx.Lock()
+	if rc.removed {
+		r.mtx.Unlock()
+		session.Close(nil)
+		client.Close()
+		return
+	}
+	rc.session, rc.client = session, client
+	r.mtx.Unlock()
+
+	r.balancer.Add(client)
+	client.OnClose(func(reason error) {
+		r.mtx.Lock()
+		rc.session, rc.client = nil, nil
+		r.mtx.Unlock()
+		r.redial(rc)
+	})
+}
+
+// redial dials the instance again after ReconnectInterval, if it is not removed.
+func (r *Resolver) redial(rc *resolverConn) {
+	time.AfterFunc(r.opts.ReconnectInterval, func() {
+		r.mtx.Lock()
+		removed := rc.removed
+		r.mtx.Unlock()
+		if !removed {
+			r.dial(rc)
+		}
+	})
+}
+
+// close closes the session of a removed instance.
+func (r *Resolver) close(rc *resolverConn) {
+	r.mtx.Lock()
+	session, client := rc.session, rc.client
+	r.mtx.Unlock()
+	if client != nil {
+		client.Close()
+	}
+	if session != nil {
+		session.Close(nil)
+	}
+}
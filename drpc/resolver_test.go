@@ -0,0 +1,131 @@
+package drpc_test
+
+import (
+	"github.com/yddeng/dnet"
+	"github.com/yddeng/dnet/drpc"
+	"github.com/yddeng/dnet/drpc/registry"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// tcpBackend serves the method "id" which replies name on a TCP listener.
+type tcpBackend struct {
+	listener net.Listener
+	mtx      sync.Mutex
+	sessions []dnet.Session
+}
+
+func startTCPBackend(t *testing.T, name string) *tcpBackend {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := drpc.NewServer()
+	server.Register("id", func(replier *drpc.Replier, req interface{}) {
+		_ = replier.Reply(name, nil)
+	})
+
+	b := &tcpBackend{listener: listener}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			ep := drpc.NewEndpoint(server)
+			session := dnet.NewTCPSession(conn, append(ep.Options(), dnet.WithCodec(newCodec()))...)
+			ep.Attach(session)
+			b.mtx.Lock()
+			b.sessions = append(b.sessions, session)
+			b.mtx.Unlock()
+		}
+	}()
+	t.Cleanup(b.stop)
+	return b
+}
+
+func (b *tcpBackend) addr() string {
+	return b.listener.Addr().String()
+}
+
+// closeSessions closes the sessions accepted.
+func (b *tcpBackend) closeSessions() {
+	b.mtx.Lock()
+	sessions := b.sessions
+	b.sessions = nil
+	b.mtx.Unlock()
+	for _, session := range sessions {
+		session.Close(nil)
+	}
+}
+
+func (b *tcpBackend) stop() {
+	_ = b.listener.Close()
+	b.closeSessions()
+}
+
+func waitLen(t *testing.T, balancer *drpc.Balancer, n int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for balancer.Len() != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("balancer len %d, want %d", balancer.Len(), n)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestResolver(t *testing.T) {
+	a, b := startTCPBackend(t, "a"), startTCPBackend(t, "b")
+	reg := registry.NewMemoryRegistry()
+	_ = reg.Register(&registry.Instance{Service: "id", ID: "a", Addr: a.addr()})
+
+	resolver := drpc.NewResolver(reg, "id",
+		drpc.WithResolverCodec(newCodec),
+		drpc.WithResolverReconnect(50*time.Millisecond),
+		drpc.WithResolverClient(drpc.WithClock(dnet.SystemClock)))
+	if err := resolver.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer resolver.Stop()
+	balancer := resolver.Balancer()
+	// the current instances are dialed by Start
+	if n := balancer.Len(); n != 1 {
+		t.Fatalf("balancer len %d", n)
+	}
+
+	_ = reg.Register(&registry.Instance{Service: "id", ID: "b", Addr: b.addr()})
+	waitLen(t, balancer, 2)
+	seen := map[string]bool{}
+	for i := 0; i < 4; i++ {
+		seen[callID(t, balancer)] = true
+	}
+	if len(seen) != 2 {
+		t.Fatalf("calls on %v", seen)
+	}
+
+	// the session closed by the instance is dialed again
+	a.closeSessions()
+	waitLen(t, balancer, 1)
+	waitLen(t, balancer, 2)
+
+	_ = reg.Deregister(&registry.Instance{Service: "id", ID: "a"})
+	waitLen(t, balancer, 1)
+	for i := 0; i < 3; i++ {
+		if id := callID(t, balancer); id != "b" {
+			t.Fatalf("call on the removed instance %s", id)
+		}
+	}
+
+	// the instance which fails to dial is not added
+	_ = reg.Register(&registry.Instance{Service: "id", ID: "x", Addr: "127.0.0.1:1"})
+	time.Sleep(100 * time.Millisecond)
+	if n := balancer.Len(); n != 1 {
+		t.Fatalf("balancer len %d", n)
+	}
+
+	resolver.Stop()
+	waitLen(t, balancer, 0)
+}
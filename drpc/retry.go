@@ -0,0 +1,234 @@
+package drpc
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// RetryPolicy retries a call which fails with a retryable code.
+// The attempts are made one after another with exponential backoff,
+// each attempt waits for the full timeout of call.
+// It should only be used by the idempotent methods.
+type RetryPolicy struct {
+	// the max number of attempts, including the first one.
+	MaxAttempts int
+
+	// the backoff before the second attempt, it is multiplied by
+	// BackoffMultiplier for each later attempt, up to MaxBackoff.
+	// the backoff is randomized by ±20%.
+	InitialBackoff    time.Duration
+	MaxBackoff        time.Duration
+	BackoffMultiplier float64
+
+	// the codes of errors to retry.
+	// default CodeUnavailable and CodeDeadlineExceeded
+	RetryableCodes []Code
+}
+
+func (p *RetryPolicy) retryable(err error) bool {
+	return codeIn(CodeOf(err), p.RetryableCodes, CodeUnavailable, CodeDeadlineExceeded)
+}
+
+// backoff returns the delay before the attempt n+1.
+func (p *RetryPolicy) backoff(n int) time.Duration {
+	multiplier := p.BackoffMultiplier
+	if multiplier < 1 {
+		multiplier = 1
+	}
+	backoff := float64(p.InitialBackoff)
+	for i := 1; i < n; i++ {
+		backoff *= multiplier
+		if p.MaxBackoff > 0 && backoff > float64(p.MaxBackoff) {
+			break
+		}
+	}
+	if p.MaxBackoff > 0 && backoff > float64(p.MaxBackoff) {
+		backoff = float64(p.MaxBackoff)
+	}
+	return time.Duration(backoff * (0.8 + 0.4*rand.Float64()))
+}
+
+// HedgingPolicy sends the call again after a delay while the former
+// attempts are waiting, and takes the first successful response.
+// The attempts still waiting are canceled, the server is told by a cancel
+// request to drop their replies. It should only be used by the idempotent methods.
+type HedgingPolicy struct {
+	// the max number of attempts, including the first one.
+	MaxAttempts int
+
+	// the delay between the attempts.
+	// an attempt is sent at once when a former one fails with a non-fatal code.
+	Delay time.Duration
+
+	// the codes of errors which do not end the call.
+	// the call fails with the first error of other codes.
+	// default CodeUnavailable and CodeDeadlineExceeded
+	NonFatalCodes []Code
+}
+
+func (p *HedgingPolicy) nonFatal(err error) bool {
+	return codeIn(CodeOf(err), p.NonFatalCodes, CodeUnavailable, CodeDeadlineExceeded)
+}
+
+// codeIn reports whether code is in codes, or in defaults if codes is empty.
+func codeIn(code Code, codes []Code, defaults ...Code) bool {
+	if len(codes) == 0 {
+		codes = defaults
+	}
+	for _, c := range codes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+// retryCall is a call made with a RetryPolicy.
+type retryCall struct {
+	client   *Client
+	channel  RPCChannel
+	method   string
+	data     interface{}
+	timeout  time.Duration
+	callback func(interface{}, error)
+	policy   *RetryPolicy
+	attempts int // only used by one attempt at a time
+}
+
+func (call *retryCall) attempt() error {
+	if call.client.isClosed() {
+		return ErrConnectionClosed
+	}
+	call.attempts++
+	if err := call.client.invoker(call.channel, &Request{Method: call.method, Data: call.data}, call.timeout, call.onResult); err != nil {
+		return err
+	}
+	if call.client.isClosed() {
+		// closed while sending
+		call.client.failAll(ErrConnectionClosed)
+	}
+	return nil
+}
+
+func (call *retryCall) onResult(result interface{}, err error) {
+	if err == nil || call.attempts >= call.policy.MaxAttempts || !call.policy.retryable(err) ||
+		err == ErrConnectionClosed || call.client.isClosed() {
+		call.callback(result, err)
+		return
+	}
+
+	call.client.afterFunc(call.policy.backoff(call.attempts), func() {
+		if err := call.attempt(); err != nil {
+			call.callback(nil, err)
+		}
+	})
+}
+
+// hedgingCall is a call made with a HedgingPolicy.
+type hedgingCall struct {
+	client   *Client
+	channel  RPCChannel
+	method   string
+	data     interface{}
+	timeout  time.Duration
+	callback func(interface{}, error)
+	policy   *HedgingPolicy
+
+	mtx      sync.Mutex
+	sent     int        // attempts sent
+	finished int        // attempts failed
+	done     bool       // the callback is called
+	reqs     []*Request // requests sent, to cancel the losers
+	stop     func()     // stops the timer of delay
+}
+
+// start sends the first attempt, the error of it is returned.
+func (call *hedgingCall) start() error {
+	if err := call.send(); err != nil {
+		return err
+	}
+	call.schedule()
+	return nil
+}
+
+// schedule sends the next attempt after the delay.
+func (call *hedgingCall) schedule() {
+	call.mtx.Lock()
+	if !call.done && call.sent < call.policy.MaxAttempts {
+		call.stop = call.client.afterFunc(call.policy.Delay, func() {
+			if err := call.send(); err != nil {
+				call.onResult(nil, err)
+			}
+			call.schedule()
+		})
+	}
+	call.mtx.Unlock()
+}
+
+// send sends an attempt if the call is not done and the max attempts is not reached.
+func (call *hedgingCall) send() error {
+	if call.client.isClosed() {
+		return ErrConnectionClosed
+	}
+	call.mtx.Lock()
+	if call.done || call.sent >= call.policy.MaxAttempts {
+		call.mtx.Unlock()
+		return nil
+	}
+	call.sent++
+	call.mtx.Unlock()
+
+	req := &Request{Method: call.method, Data: call.data}
+	if err := call.client.invoker(call.channel, req, call.timeout, call.onResult); err != nil {
+		return err
+	}
+
+	call.mtx.Lock()
+	if call.done {
+		// the call is done while sending
+		call.mtx.Unlock()
+		call.client.cancelRemote(call.channel, req.Seq)
+		return nil
+	}
+	call.reqs = append(call.reqs, req)
+	call.mtx.Unlock()
+	return nil
+}
+
+func (call *hedgingCall) onResult(result interface{}, err error) {
+	call.mtx.Lock()
+	if call.done {
+		call.mtx.Unlock()
+		return
+	}
+	call.finished++
+
+	if err != nil && call.policy.nonFatal(err) && err != ErrConnectionClosed && !call.client.isClosed() {
+		if call.sent < call.policy.MaxAttempts {
+			call.mtx.Unlock()
+			if err := call.send(); err != nil {
+				call.onResult(nil, err)
+			}
+			return
+		}
+		if call.finished < call.sent {
+			// wait for the others
+			call.mtx.Unlock()
+			return
+		}
+	}
+
+	call.done = true
+	if call.stop != nil {
+		call.stop()
+	}
+	reqs := call.reqs
+	call.reqs = nil
+	call.mtx.Unlock()
+
+	for _, req := range reqs {
+		call.client.cancelRemote(call.channel, req.Seq)
+	}
+	call.callback(result, err)
+}
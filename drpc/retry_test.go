@@ -0,0 +1,214 @@
+package drpc
+
+import (
+	"github.com/yddeng/dnet"
+	"github.com/yddeng/dnet/dnettest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newFlakyServer serves the method "flaky" which fails with CodeUnavailable before the call n,
+// and "bad" which fails with CodeInvalidArgument. calls counts the calls.
+func newFlakyServer(n int32, calls *int32) *Server {
+	server := NewServer(WithExecutor(GoroutineExecutor()))
+	server.Register("flaky", func(replier *Replier, req interface{}) {
+		if atomic.AddInt32(calls, 1) < n {
+			_ = replier.Reply(nil, NewError(CodeUnavailable, "drpc: busy"))
+			return
+		}
+		_ = replier.Reply("ok", nil)
+	})
+	server.Register("bad", func(replier *Replier, req interface{}) {
+		atomic.AddInt32(calls, 1)
+		_ = replier.Reply(nil, NewError(CodeInvalidArgument, "drpc: bad argument"))
+	})
+	return server
+}
+
+func TestRetry(t *testing.T) {
+	var calls, attempts int32
+	server := newFlakyServer(3, &calls)
+	client := NewClient(WithClock(dnet.SystemClock), WithClientInterceptor(func(channel RPCChannel, req *Request, timeout time.Duration, callback func(interface{}, error), invoker Invoker) error {
+		atomic.AddInt32(&attempts, 1)
+		return invoker(channel, req, timeout, callback)
+	}))
+	channel := streamPipe(t, server, client)
+
+	policy := &RetryPolicy{MaxAttempts: 4, InitialBackoff: 10 * time.Millisecond, MaxBackoff: 20 * time.Millisecond, BackoffMultiplier: 2}
+	ret, err := client.Call(channel, "flaky", 1, time.Second, WithRetry(policy))
+	if ret != "ok" || err != nil {
+		t.Fatalf("call %v %v", ret, err)
+	}
+	// each attempt goes through the interceptors
+	if calls != 3 || attempts != 3 {
+		t.Fatalf("calls %d attempts %d", calls, attempts)
+	}
+}
+
+func TestRetryExhausted(t *testing.T) {
+	var calls int32
+	server := newFlakyServer(100, &calls)
+	client := NewClient(WithClock(dnet.SystemClock), WithMethodRetry("flaky", &RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond}))
+	channel := streamPipe(t, server, client)
+
+	if _, err := client.Call(channel, "flaky", 1, time.Second); CodeOf(err) != CodeUnavailable {
+		t.Fatalf("call %v", err)
+	}
+	if calls != 3 {
+		t.Fatalf("calls %d, want 3", calls)
+	}
+
+	// the errors not retryable are returned at once
+	calls = 0
+	if _, err := client.Call(channel, "bad", 1, time.Second, WithRetry(&RetryPolicy{MaxAttempts: 3})); CodeOf(err) != CodeInvalidArgument {
+		t.Fatalf("call %v", err)
+	}
+	if calls != 1 {
+		t.Fatalf("calls %d, want 1", calls)
+	}
+	if n := client.Pending(); n != 0 {
+		t.Fatalf("pending %d", n)
+	}
+}
+
+func TestRetryTimeout(t *testing.T) {
+	var calls int32
+	server := NewServer(WithExecutor(GoroutineExecutor()))
+	server.Register("hang", func(replier *Replier, req interface{}) {
+		if atomic.AddInt32(&calls, 1) < 2 {
+			return
+		}
+		_ = replier.Reply("ok", nil)
+	})
+	client := NewClient(WithClock(dnet.SystemClock))
+	channel := streamPipe(t, server, client)
+
+	ret, err := client.Call(channel, "hang", 1, 50*time.Millisecond, WithRetry(&RetryPolicy{MaxAttempts: 2}))
+	if ret != "ok" || err != nil {
+		t.Fatalf("call %v %v", ret, err)
+	}
+}
+
+func TestRetryAfterClose(t *testing.T) {
+	session := dnettest.NewRecorder()
+	client := NewChannelClient(session, WithClock(dnet.SystemClock))
+	done := make(chan error, 1)
+	policy := &RetryPolicy{MaxAttempts: 3, InitialBackoff: 20 * time.Millisecond}
+	if err := client.Go("flaky", 1, time.Second, func(_ interface{}, err error) {
+		done <- err
+	}, WithRetry(policy)); err != nil {
+		t.Fatal(err)
+	}
+	req, err := session.Next(time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the first attempt fails, and the client is closed in the backoff
+	_ = client.OnRPCResponse(&Response{Seq: req.(*Request).Seq, Code: CodeUnavailable, Error: "drpc: busy"})
+	client.Close()
+	select {
+	case err := <-done:
+		if err != ErrConnectionClosed {
+			t.Fatalf("call done by %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the call is not done after close")
+	}
+	time.Sleep(50 * time.Millisecond)
+	if sent := session.Sent(); len(sent) != 1 {
+		t.Fatalf("sent %d requests after close", len(sent))
+	}
+
+	// the calls failed by the close are not retried
+	session = dnettest.NewRecorder()
+	client = NewChannelClient(session, WithClock(dnet.SystemClock))
+	_ = client.Go("flaky", 1, time.Second, func(_ interface{}, err error) {
+		done <- err
+	}, WithRetry(policy))
+	client.Close()
+	if err := <-done; err != ErrConnectionClosed {
+		t.Fatalf("call done by %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if sent := session.Sent(); len(sent) != 1 {
+		t.Fatalf("sent %d requests after close", len(sent))
+	}
+}
+
+func TestHedging(t *testing.T) {
+	var calls int32
+	canceled := make(chan bool, 1)
+	server := NewServer(WithExecutor(GoroutineExecutor()))
+	server.Register("slow", func(replier *Replier, req interface{}) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			time.Sleep(200 * time.Millisecond)
+			canceled <- replier.Canceled()
+			_ = replier.Reply("first", nil)
+			return
+		}
+		_ = replier.Reply("hedge", nil)
+	})
+	client := NewClient(WithClock(dnet.SystemClock))
+	channel := streamPipe(t, server, client)
+
+	start := time.Now()
+	ret, err := client.Call(channel, "slow", 1, time.Second, WithHedging(&HedgingPolicy{MaxAttempts: 2, Delay: 20 * time.Millisecond}))
+	if ret != "hedge" || err != nil {
+		t.Fatalf("call %v %v", ret, err)
+	}
+	if d := time.Since(start); d >= 200*time.Millisecond {
+		t.Fatalf("hedged call takes %s", d)
+	}
+	if n := client.Pending(); n != 0 {
+		t.Fatalf("pending %d", n)
+	}
+	// the server is told to drop the reply of the loser
+	if !<-canceled {
+		t.Fatal("the loser is not canceled on the server")
+	}
+}
+
+func TestHedgingFailures(t *testing.T) {
+	var calls int32
+	server := newFlakyServer(100, &calls)
+	client := NewClient(WithClock(dnet.SystemClock))
+	channel := streamPipe(t, server, client)
+
+	// the non-fatal failures send the next attempt at once
+	_, err := client.Call(channel, "flaky", 1, time.Second, WithHedging(&HedgingPolicy{MaxAttempts: 3, Delay: time.Second}))
+	if CodeOf(err) != CodeUnavailable || calls != 3 {
+		t.Fatalf("call %v calls %d", err, calls)
+	}
+
+	// a fatal failure ends the call
+	calls = 0
+	_, err = client.Call(channel, "bad", 1, time.Second, WithHedging(&HedgingPolicy{MaxAttempts: 3, Delay: 10 * time.Millisecond}))
+	if CodeOf(err) != CodeInvalidArgument {
+		t.Fatalf("call %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Fatalf("calls %d after the fatal failure", n)
+	}
+}
+
+func TestHedgingAfterClose(t *testing.T) {
+	session := dnettest.NewRecorder()
+	client := NewChannelClient(session, WithClock(dnet.SystemClock))
+	done := make(chan error, 1)
+	if err := client.Go("slow", 1, time.Second, func(_ interface{}, err error) {
+		done <- err
+	}, WithHedging(&HedgingPolicy{MaxAttempts: 3, Delay: 10 * time.Millisecond})); err != nil {
+		t.Fatal(err)
+	}
+	client.Close()
+	if err := <-done; err != ErrConnectionClosed {
+		t.Fatalf("call done by %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if sent := session.Sent(); len(sent) != 1 {
+		t.Fatalf("sent %d requests after close", len(sent))
+	}
+}
@@ -2,21 +2,26 @@ package drpc
 
 import (
 	"fmt"
+	"reflect"
 	"runtime"
 	"sync"
 	"sync/atomic"
 )
 
 type Request struct {
-	Seq    uint64 // the number of request
-	Method string // The name of the service and method to call.
-	Data   interface{}
+	Seq     uint64 // the number of request
+	Method  string // The name of the service and method to call.
+	Data    interface{}
+	Meta    Metadata // key-value pairs sent along with the request
+	NoReply bool     // a notification, the server does not reply
+	Cancel  bool     // cancels the call of Seq, the reply of it is dropped
 }
 
 type Response struct {
 	Seq   uint64 // the number of request
 	Data  interface{}
 	Error string
+	Code  Code // the code of Error, CodeUnknown if it is zero
 }
 
 // RPCChannel
@@ -27,29 +32,112 @@ type RPCChannel interface {
 
 // Server represents an RPC Server.
 type Server struct {
-	methods map[string]MethodHandler
+	opts    *ServerOptions
+	methods map[string]*methodDesc
+	limits  map[string]*methodLimit
 	mtx     sync.RWMutex
+	streams sync.Map // map[streamKey]*Stream
+	calls   sync.Map // map[callKey]*Replier, the calls waiting for reply
 }
 
 type MethodHandler func(replier *Replier, req interface{})
 
+// NotifyHandler handles a notification, which has nothing to reply.
+type NotifyHandler func(req interface{})
+
+// methodDesc is a registered method.
+type methodDesc struct {
+	name    string
+	handler MethodHandler // unary method, or the notify method with interceptors
+	notify  bool          // handler is a notify method
+	stream  StreamHandler // stream method
+
+	// registered by RegisterTyped
+	reqType  reflect.Type
+	respType reflect.Type
+}
+
+// streamKey identifies a stream on the server, the channel must be comparable.
+type streamKey struct {
+	channel StreamChannel
+	id      uint64
+}
+
+// callKey identifies a call on the server, the channel must be comparable.
+type callKey struct {
+	channel RPCChannel
+	seq     uint64
+}
+
+// methodLimit counts the handlers of a method that are running.
+type methodLimit struct {
+	limit   int32
+	running int32
+}
+
+func (l *methodLimit) acquire() bool {
+	for {
+		running := atomic.LoadInt32(&l.running)
+		if running >= l.limit {
+			return false
+		}
+		if atomic.CompareAndSwapInt32(&l.running, running, running+1) {
+			return true
+		}
+	}
+}
+
+func (l *methodLimit) release() {
+	atomic.AddInt32(&l.running, -1)
+}
+
 // Register Register the method on the server whit name.
 // call method by name.
 func (server *Server) Register(name string, h MethodHandler) {
-	if name == "" {
-		panic("drpc: Register name == ''")
-	}
 	if nil == h {
 		panic("drpc: Register h == nil")
 	}
+	server.register(&methodDesc{name: name, handler: chainServerInterceptors(server.opts.Interceptors, h)})
+}
+
+// RegisterNotify Register the notify method on the server whit name.
+// It only handles requests sent by Client.Notify.
+func (server *Server) RegisterNotify(name string, h NotifyHandler) {
+	if nil == h {
+		panic("drpc: RegisterNotify h == nil")
+	}
+	handler := func(_ *Replier, req interface{}) { h(req) }
+	server.register(&methodDesc{name: name, handler: chainServerInterceptors(server.opts.Interceptors, handler), notify: true})
+}
+
+// RegisterStream Register the stream method on the server whit name.
+// Stream methods are not intercepted by the ServerInterceptor.
+func (server *Server) RegisterStream(name string, h StreamHandler) {
+	if nil == h {
+		panic("drpc: RegisterStream h == nil")
+	}
+	server.register(&methodDesc{name: name, stream: h})
+}
+
+func (server *Server) register(desc *methodDesc) {
+	if desc.name == "" {
+		panic("drpc: Register name == ''")
+	}
 
 	server.mtx.Lock()
 	defer server.mtx.Unlock()
-	_, ok := server.methods[name]
+	_, ok := server.methods[desc.name]
 	if ok {
-		panic(fmt.Sprintf("drpc:Register duplicate method:%s", name))
+		panic(fmt.Sprintf("drpc:Register duplicate method:%s", desc.name))
 	}
-	server.methods[name] = h
+	server.methods[desc.name] = desc
+}
+
+func (server *Server) getMethod(name string) (*methodDesc, bool) {
+	server.mtx.RLock()
+	defer server.mtx.RUnlock()
+	desc, ok := server.methods[name]
+	return desc, ok
 }
 
 // OnRPCRequest
@@ -57,16 +145,77 @@ func (server *Server) OnRPCRequest(channel RPCChannel, req *Request) error {
 	if channel == nil || req == nil {
 		return fmt.Errorf("drpc:OnRPCRequest invalid argument")
 	}
+	if req.Cancel {
+		server.cancelCall(channel, req.Seq)
+		return nil
+	}
 
-	server.mtx.RLock()
-	method, ok := server.methods[req.Method]
-	server.mtx.RUnlock()
-	if !ok {
+	desc, ok := server.getMethod(req.Method)
+	if !ok || desc.handler == nil {
 		return fmt.Errorf("drpc:OnRPCRequest invalid method %s", req.Method)
 	}
+	method := desc.handler
+
+	replier := &Replier{Channel: channel, method: req.Method, meta: req.Meta, noReply: req.NoReply, resp: &Response{Seq: req.Seq}}
+	if desc.notify && !req.NoReply {
+		_ = replier.Reply(nil, Errorf(CodeInvalidArgument, "drpc: method %s is a notification", req.Method))
+		return fmt.Errorf("drpc:OnRPCRequest method %s is a notification", req.Method)
+	}
+
+	limit := server.limits[req.Method]
+	if limit != nil && !limit.acquire() {
+		_ = replier.Reply(nil, ErrResourceExhausted)
+		return fmt.Errorf("drpc:OnRPCRequest method %s %s", req.Method, ErrResourceExhausted.Error())
+	}
+	server.addCall(channel, replier)
 
-	replier := &Replier{Channel: channel, resp: &Response{Seq: req.Seq}}
-	return server.callMethod(method, replier, req.Data)
+	executor := server.opts.Executor
+	if _, ok := executor.(inlineExecutor); ok || executor == nil {
+		if limit != nil {
+			defer limit.release()
+		}
+		return server.callMethod(method, replier, req.Data)
+	}
+
+	if err := executor.Execute(func() {
+		if limit != nil {
+			defer limit.release()
+		}
+		_ = server.callMethod(method, replier, req.Data)
+	}); err != nil {
+		if limit != nil {
+			limit.release()
+		}
+		_ = replier.Reply(nil, err)
+		return fmt.Errorf("drpc:OnRPCRequest method %s %s", req.Method, err.Error())
+	}
+	return nil
+}
+
+// addCall keeps the call until it is replied, so that it can be canceled by the client.
+// The calls of the channels which are not comparable can not be canceled.
+func (server *Server) addCall(channel RPCChannel, replier *Replier) {
+	if replier.noReply || !isComparable(channel) {
+		return
+	}
+	key := callKey{channel: channel, seq: replier.resp.Seq}
+	server.calls.Store(key, replier)
+	replier.onDone = func() { server.calls.Delete(key) }
+}
+
+// cancelCall drops the reply of the call, it is ignored if the call has been replied.
+func (server *Server) cancelCall(channel RPCChannel, seq uint64) {
+	if !isComparable(channel) {
+		return
+	}
+	if v, ok := server.calls.LoadAndDelete(callKey{channel: channel, seq: seq}); ok {
+		atomic.StoreInt32(&v.(*Replier).canceled, 1)
+	}
+}
+
+// isComparable reports whether channel can be a key of the maps, such as a pointer.
+func isComparable(channel interface{}) bool {
+	return reflect.TypeOf(channel).Comparable()
 }
 
 func (server *Server) callMethod(method MethodHandler, replier *Replier, arg interface{}) (err error) {
@@ -75,6 +224,8 @@ func (server *Server) callMethod(method MethodHandler, replier *Replier, arg int
 			buf := make([]byte, 1024)
 			l := runtime.Stack(buf, false)
 			err = fmt.Errorf(fmt.Sprintf("%v: %s", r, buf[:l]))
+			// the caller should not wait for the timeout
+			_ = replier.Reply(nil, Errorf(CodeInternal, "drpc: method panic: %v", r))
 		}
 	}()
 
@@ -82,11 +233,145 @@ func (server *Server) callMethod(method MethodHandler, replier *Replier, arg int
 	return
 }
 
+// OnStreamFrame
+func (server *Server) OnStreamFrame(channel StreamChannel, frame *StreamFrame) error {
+	if channel == nil || frame == nil {
+		return fmt.Errorf("drpc:OnStreamFrame invalid argument")
+	}
+	if !isComparable(channel) {
+		if frame.Flag == StreamOpen {
+			_ = channel.SendStreamFrame(&StreamFrame{ID: frame.ID, Flag: StreamCancel, Code: CodeUnimplemented,
+				Error: "drpc: streams need a comparable channel", Server: true})
+		}
+		return fmt.Errorf("drpc:OnStreamFrame channel %T is not comparable", channel)
+	}
+
+	key := streamKey{channel: channel, id: frame.ID}
+	if frame.Flag != StreamOpen {
+		v, ok := server.streams.Load(key)
+		if !ok {
+			// the handler has returned
+			return nil
+		}
+		v.(*Stream).onFrame(frame)
+		return nil
+	}
+
+	desc, ok := server.getMethod(frame.Method)
+	if !ok || desc.stream == nil {
+		_ = channel.SendStreamFrame(&StreamFrame{ID: frame.ID, Flag: StreamCancel, Code: CodeNotFound,
+			Error: fmt.Sprintf("drpc: stream method %s not found", frame.Method), Server: true})
+		return fmt.Errorf("drpc:OnStreamFrame invalid method %s", frame.Method)
+	}
+
+	limit := server.limits[frame.Method]
+	if limit != nil && !limit.acquire() {
+		_ = channel.SendStreamFrame(&StreamFrame{ID: frame.ID, Flag: StreamCancel, Code: CodeResourceExhausted,
+			Error: ErrResourceExhausted.Error(), Server: true})
+		return fmt.Errorf("drpc:OnStreamFrame method %s %s", frame.Method, ErrResourceExhausted.Error())
+	}
+
+	sendWindow := frame.Window
+	if sendWindow == 0 {
+		sendWindow = DefaultStreamWindow
+	}
+	stream := newStream(channel, frame.ID, frame.Method, frame.Meta, sendWindow, DefaultStreamWindow, false)
+	stream.onDone = func() { server.streams.Delete(key) }
+	if _, loaded := server.streams.LoadOrStore(key, stream); loaded {
+		if limit != nil {
+			limit.release()
+		}
+		return fmt.Errorf("drpc:OnStreamFrame duplicate stream %d", frame.ID)
+	}
+
+	// streams live long, they do not take the workers of executor
+	go func() {
+		if limit != nil {
+			defer limit.release()
+		}
+		if err := server.callStream(desc.stream, stream); err != nil {
+			stream.Cancel(err)
+		} else {
+			_ = stream.CloseSend()
+		}
+		stream.finish()
+	}()
+	return nil
+}
+
+// OnChannelClose cancels the calls and streams of the channel, it should be called
+// when the connection of channel is closed.
+func (server *Server) OnChannelClose(channel StreamChannel) {
+	if !isComparable(channel) {
+		return
+	}
+	server.calls.Range(func(k, v interface{}) bool {
+		if k.(callKey).channel == RPCChannel(channel) {
+			server.calls.Delete(k)
+			atomic.StoreInt32(&v.(*Replier).canceled, 1)
+		}
+		return true
+	})
+	server.streams.Range(func(k, v interface{}) bool {
+		if k.(streamKey).channel == channel {
+			stream := v.(*Stream)
+			if stream.abort(ErrConnectionClosed) {
+				stream.finish()
+			}
+		}
+		return true
+	})
+}
+
+func (server *Server) callStream(method StreamHandler, stream *Stream) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = Errorf(CodeInternal, "drpc: stream method panic: %v", r)
+		}
+	}()
+
+	return method(stream)
+}
+
 // Replier
 type Replier struct {
-	Channel RPCChannel
-	fired   int32
-	resp    *Response
+	Channel  RPCChannel
+	method   string
+	meta     Metadata
+	noReply  bool
+	fired    int32
+	canceled int32
+	resp     *Response
+	onReply  []func(ret interface{}, err error)
+	onDone   func() // removes the call from the server
+}
+
+// Method returns the name of the method called.
+func (r *Replier) Method() string {
+	return r.method
+}
+
+// Metadata returns the metadata sent along with the request.
+func (r *Replier) Metadata() Metadata {
+	return r.meta
+}
+
+// NoReply returns true if the request is a notification.
+// Reply does nothing but calls the OnReply functions then.
+func (r *Replier) NoReply() bool {
+	return r.noReply
+}
+
+// Canceled returns true if the call is canceled by the client or the channel is closed,
+// the handler may stop then, as the reply will be dropped.
+func (r *Replier) Canceled() bool {
+	return atomic.LoadInt32(&r.canceled) == 1
+}
+
+// OnReply adds a function which will be called with the result when Reply is called.
+// It is not safe to call OnReply concurrently with Reply.
+func (r *Replier) OnReply(f func(ret interface{}, err error)) {
+	r.onReply = append(r.onReply, f)
 }
 
 func (r *Replier) Reply(ret interface{}, err error) error {
@@ -96,11 +381,22 @@ func (r *Replier) Reply(ret interface{}, err error) error {
 
 	if err != nil {
 		r.resp.Error = err.Error()
+		r.resp.Code = CodeOf(err)
 	} else if ret != nil {
 		r.resp.Data = ret
 	} else {
 		return fmt.Errorf("drpc:Reply argments failed, none")
 	}
+
+	if r.onDone != nil {
+		r.onDone()
+	}
+	for _, f := range r.onReply {
+		f(ret, err)
+	}
+	if r.noReply || r.Canceled() {
+		return nil
+	}
 	return r.reply(r.resp)
 }
 
@@ -109,8 +405,21 @@ func (r *Replier) reply(resp *Response) error {
 }
 
 // NewServer returns a new Server.
-func NewServer() *Server {
-	return &Server{
-		methods: map[string]MethodHandler{},
+func NewServer(options ...ServerOption) *Server {
+	opts := loadServerOptions(options...)
+
+	limits := map[string]*methodLimit{}
+	for name, n := range opts.MethodConcurrency {
+		limits[name] = &methodLimit{limit: int32(n)}
+	}
+
+	server := &Server{
+		opts:    opts,
+		methods: map[string]*methodDesc{},
+		limits:  limits,
+	}
+	if opts.EnableReflection {
+		server.registerReflection()
 	}
+	return server
 }
@@ -0,0 +1,134 @@
+package drpc
+
+import (
+	"github.com/yddeng/dnet"
+	"testing"
+	"time"
+)
+
+func TestNotify(t *testing.T) {
+	server := NewServer()
+	var noReply []bool
+	server.RegisterNotify("event", func(req interface{}) {})
+	server.Register("echo", func(replier *Replier, req interface{}) {
+		noReply = append(noReply, replier.NoReply())
+		_ = replier.Reply(req, nil)
+	})
+	channel := newRecordChannel()
+	client := NewClient(WithClock(dnet.SystemClock))
+
+	// the notifications are handled without response
+	for _, method := range []string{"event", "echo"} {
+		if err := client.Notify(channel, method, 1); err != nil {
+			t.Fatal(err)
+		}
+		req := <-channel.reqCh
+		if !req.NoReply || req.Seq != 0 {
+			t.Fatalf("notification %+v", req)
+		}
+		if err := server.OnRPCRequest(channel, req); err != nil {
+			t.Fatal(err)
+		}
+	}
+	select {
+	case resp := <-channel.respCh:
+		t.Fatalf("response to a notification %+v", resp)
+	default:
+	}
+	if len(noReply) != 1 || !noReply[0] {
+		t.Fatalf("NoReply %v", noReply)
+	}
+	if n := client.Pending(); n != 0 {
+		t.Fatalf("pending %d", n)
+	}
+
+	// a notify method can not be called
+	if err := server.OnRPCRequest(channel, &Request{Seq: 1, Method: "event", Data: 1}); err == nil {
+		t.Fatal("call a notify method")
+	}
+	if resp := channel.response(t); resp.Seq != 1 || resp.Code != CodeInvalidArgument {
+		t.Fatalf("response %+v", resp)
+	}
+}
+
+func TestNotifyCall(t *testing.T) {
+	server := NewServer()
+	server.RegisterNotify("event", func(req interface{}) {})
+	client := NewClient(WithClock(dnet.SystemClock))
+	_, err := client.Call(&loopChannel{server: server, client: client}, "event", 1, time.Second)
+	if CodeOf(err) != CodeInvalidArgument {
+		t.Fatalf("call a notify method: %v", err)
+	}
+}
+
+func TestCancel(t *testing.T) {
+	server := NewServer(WithExecutor(GoroutineExecutor()))
+	block := make(chan struct{})
+	replied := make(chan bool, 1)
+	server.Register("wait", func(replier *Replier, req interface{}) {
+		<-block
+		replied <- replier.Canceled()
+		_ = replier.Reply(req, nil)
+	})
+	server.Register("echo", func(replier *Replier, req interface{}) {
+		_ = replier.Reply(req, nil)
+	})
+	channel := newRecordChannel()
+
+	if err := server.OnRPCRequest(channel, &Request{Seq: 1, Method: "wait", Data: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.OnRPCRequest(channel, &Request{Seq: 1, Cancel: true}); err != nil {
+		t.Fatal(err)
+	}
+	close(block)
+	if canceled := <-replied; !canceled {
+		t.Fatal("the call is not canceled")
+	}
+	// the reply of the canceled call is dropped
+	select {
+	case resp := <-channel.respCh:
+		t.Fatalf("response to the canceled call %+v", resp)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// the cancel after the reply is ignored
+	if err := server.OnRPCRequest(channel, &Request{Seq: 2, Method: "echo", Data: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if resp := channel.response(t); resp.Seq != 2 {
+		t.Fatalf("response %+v", resp)
+	}
+	if err := server.OnRPCRequest(channel, &Request{Seq: 2, Cancel: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	n := 0
+	server.calls.Range(func(_, _ interface{}) bool { n++; return true })
+	if n != 0 {
+		t.Fatalf("calls %d", n)
+	}
+}
+
+func TestCancelOnChannelClose(t *testing.T) {
+	server := NewServer(WithExecutor(GoroutineExecutor()))
+	block := make(chan struct{})
+	replied := make(chan bool, 1)
+	server.Register("wait", func(replier *Replier, req interface{}) {
+		<-block
+		replied <- replier.Canceled()
+		_ = replier.Reply(req, nil)
+	})
+	channel := &streamSide{toPeer: make(chan interface{}, 8)}
+	if err := server.OnRPCRequest(channel, &Request{Seq: 1, Method: "wait", Data: 1}); err != nil {
+		t.Fatal(err)
+	}
+	server.OnChannelClose(channel)
+	close(block)
+	if canceled := <-replied; !canceled {
+		t.Fatal("the call is not canceled by the close")
+	}
+	if len(channel.toPeer) != 0 {
+		t.Fatal("reply to the closed channel")
+	}
+}
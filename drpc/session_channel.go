@@ -0,0 +1,34 @@
+package drpc
+
+import (
+	"github.com/yddeng/dnet"
+)
+
+// SessionChannel is a StreamChannel which sends the rpc messages by dnet.Session,
+// the codec of session must encode *Request, *Response and *StreamFrame.
+type SessionChannel struct {
+	session dnet.Session
+}
+
+// NewSessionChannel returns a SessionChannel of session.
+// Streams are kept per channel, use one SessionChannel for a session.
+func NewSessionChannel(session dnet.Session) *SessionChannel {
+	return &SessionChannel{session: session}
+}
+
+// Session returns the session of channel.
+func (this *SessionChannel) Session() dnet.Session {
+	return this.session
+}
+
+func (this *SessionChannel) SendRequest(req *Request) error {
+	return this.session.Send(req)
+}
+
+func (this *SessionChannel) SendResponse(resp *Response) error {
+	return this.session.Send(resp)
+}
+
+func (this *SessionChannel) SendStreamFrame(frame *StreamFrame) error {
+	return this.session.Send(frame)
+}
@@ -0,0 +1,300 @@
+package drpc
+
+import (
+	"fmt"
+	"io"
+	"sync"
+)
+
+// DefaultStreamWindow is the number of messages a stream can receive
+// before the receiver grants more by a StreamWindow frame.
+const DefaultStreamWindow = 64
+
+var (
+	ErrStreamCanceled = NewError(CodeCanceled, "drpc: stream canceled. ")
+	ErrStreamClosed   = NewError(CodeCanceled, "drpc: stream send closed. ")
+)
+
+type StreamFlag uint8
+
+const (
+	StreamOpen   StreamFlag = 1 + iota // opens a stream, from the client
+	StreamData                         // a message
+	StreamEnd                          // the sender will not send any more
+	StreamCancel                       // aborts the stream with an error
+	StreamWindow                       // grants more messages to the peer
+)
+
+// StreamFrame is a frame of a stream, sent alongside Request and Response.
+type StreamFrame struct {
+	ID     uint64      // the id of stream, chosen by the client
+	Flag   StreamFlag  // the type of frame
+	Method string      // StreamOpen: the name of method
+	Meta   Metadata    // StreamOpen: key-value pairs sent along with the open
+	Data   interface{} // StreamData: the message
+	Window uint32      // StreamOpen: receive window of the client, StreamWindow: increment of window
+	Code   Code        // StreamCancel: the code of Error
+	Error  string      // StreamCancel: the error
+	Server bool        // sent by the server side of the stream
+}
+
+// StreamChannel is a RPCChannel which can carry streams.
+// Streams are kept per channel, so the channel of a connection must be
+// the same comparable value, such as a pointer, for all frames.
+type StreamChannel interface {
+	RPCChannel
+	SendStreamFrame(frame *StreamFrame) error // send stream frame
+}
+
+// StreamHandler serves a stream. The stream is ended when it returns,
+// with the returned error sent to the client.
+type StreamHandler func(stream *Stream) error
+
+// Stream is a sequence of messages in both directions, used for
+// server streaming, client streaming and bidirectional streaming calls.
+// Send and Recv can be called from two goroutines, but each of them must not
+// be called from multiple goroutines simultaneously.
+type Stream struct {
+	id       uint64
+	method   string
+	meta     Metadata
+	channel  StreamChannel
+	isClient bool
+	onDone   func() // removes the stream from its owner
+
+	mtx        sync.Mutex
+	cond       *sync.Cond
+	sendWindow uint32        // messages we can send
+	sendClosed bool          // we sent StreamEnd
+	recvQueue  []interface{} // received messages
+	recvClosed bool          // peer sent StreamEnd
+	recvWindow uint32        // messages the peer can send before a window update
+	consumed   uint32        // messages received since the last window update
+	err        error         // set when the stream is canceled
+	done       bool
+	chDone     chan struct{}
+}
+
+func newStream(channel StreamChannel, id uint64, method string, meta Metadata, sendWindow, recvWindow uint32, isClient bool) *Stream {
+	s := &Stream{
+		id:         id,
+		method:     method,
+		meta:       meta,
+		channel:    channel,
+		isClient:   isClient,
+		sendWindow: sendWindow,
+		recvWindow: recvWindow,
+		chDone:     make(chan struct{}),
+	}
+	s.cond = sync.NewCond(&s.mtx)
+	return s
+}
+
+// ID returns the id of the stream.
+func (s *Stream) ID() uint64 {
+	return s.id
+}
+
+// Method returns the name of the method called.
+func (s *Stream) Method() string {
+	return s.method
+}
+
+// Metadata returns the metadata sent along with the open.
+func (s *Stream) Metadata() Metadata {
+	return s.meta
+}
+
+// Done returns a channel which is closed when the stream is ended.
+func (s *Stream) Done() <-chan struct{} {
+	return s.chDone
+}
+
+// Err returns the error the stream is canceled with, nil if it is not canceled.
+func (s *Stream) Err() error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.err
+}
+
+// Send sends a message to the peer.
+// It blocks while the receive window of the peer is full.
+// It returns io.EOF on the client if the server has ended the stream.
+func (s *Stream) Send(o interface{}) error {
+	if o == nil {
+		return fmt.Errorf("drpc: stream Send msg is nil")
+	}
+
+	s.mtx.Lock()
+	for s.sendWindow == 0 && s.err == nil && !s.sendClosed && !s.done {
+		s.cond.Wait()
+	}
+	if s.err != nil {
+		err := s.err
+		s.mtx.Unlock()
+		return err
+	}
+	if s.sendClosed {
+		s.mtx.Unlock()
+		return ErrStreamClosed
+	}
+	if s.done {
+		s.mtx.Unlock()
+		return io.EOF
+	}
+	s.sendWindow--
+	s.mtx.Unlock()
+
+	return s.sendFrame(&StreamFrame{ID: s.id, Flag: StreamData, Data: o})
+}
+
+// Recv receives a message from the peer.
+// It returns io.EOF when the peer has ended its sending,
+// or the error the stream is canceled with.
+func (s *Stream) Recv() (interface{}, error) {
+	s.mtx.Lock()
+	for len(s.recvQueue) == 0 && !s.recvClosed && s.err == nil {
+		s.cond.Wait()
+	}
+	if s.err != nil {
+		err := s.err
+		s.mtx.Unlock()
+		return nil, err
+	}
+	if len(s.recvQueue) == 0 {
+		s.mtx.Unlock()
+		return nil, io.EOF
+	}
+
+	msg := s.recvQueue[0]
+	s.recvQueue[0] = nil
+	s.recvQueue = s.recvQueue[1:]
+
+	var increment uint32
+	s.consumed++
+	if !s.recvClosed && s.consumed >= (s.recvWindow+1)/2 {
+		increment = s.consumed
+		s.consumed = 0
+	}
+	s.mtx.Unlock()
+
+	if increment > 0 {
+		_ = s.sendFrame(&StreamFrame{ID: s.id, Flag: StreamWindow, Window: increment})
+	}
+	return msg, nil
+}
+
+// CloseSend tells the peer that no more messages will be sent.
+func (s *Stream) CloseSend() error {
+	s.mtx.Lock()
+	if s.sendClosed || s.err != nil || s.done {
+		s.mtx.Unlock()
+		return nil
+	}
+	s.sendClosed = true
+	finish := s.recvClosed
+	s.cond.Broadcast()
+	s.mtx.Unlock()
+
+	err := s.sendFrame(&StreamFrame{ID: s.id, Flag: StreamEnd})
+	if finish {
+		s.finish()
+	}
+	return err
+}
+
+// Cancel aborts the stream in both directions, and sends err to the peer.
+// err is ErrStreamCanceled if it is nil.
+func (s *Stream) Cancel(err error) {
+	if err == nil {
+		err = ErrStreamCanceled
+	}
+	if !s.abort(err) {
+		return
+	}
+
+	_ = s.sendFrame(&StreamFrame{ID: s.id, Flag: StreamCancel, Code: CodeOf(err), Error: err.Error()})
+	s.finish()
+}
+
+func (s *Stream) sendFrame(frame *StreamFrame) error {
+	frame.Server = !s.isClient
+	return s.channel.SendStreamFrame(frame)
+}
+
+// abort sets the error of stream and wakes up Send and Recv.
+// it returns false if the stream is already aborted or ended.
+func (s *Stream) abort(err error) bool {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.err != nil || s.done {
+		return false
+	}
+	s.err = err
+	s.cond.Broadcast()
+	return true
+}
+
+// finish ends the stream once.
+func (s *Stream) finish() {
+	s.mtx.Lock()
+	if s.done {
+		s.mtx.Unlock()
+		return
+	}
+	s.done = true
+	s.cond.Broadcast()
+	s.mtx.Unlock()
+
+	close(s.chDone)
+	if s.onDone != nil {
+		s.onDone()
+	}
+}
+
+// onFrame handles a frame from the peer.
+func (s *Stream) onFrame(frame *StreamFrame) {
+	switch frame.Flag {
+	case StreamData:
+		s.mtx.Lock()
+		if s.recvClosed || s.err != nil || s.done {
+			s.mtx.Unlock()
+			return
+		}
+		if uint32(len(s.recvQueue)) >= s.recvWindow {
+			// the peer does not respect the window
+			s.mtx.Unlock()
+			s.Cancel(Errorf(CodeResourceExhausted, "drpc: stream %d receive window exceeded", s.id))
+			return
+		}
+		s.recvQueue = append(s.recvQueue, frame.Data)
+		s.cond.Broadcast()
+		s.mtx.Unlock()
+
+	case StreamEnd:
+		s.mtx.Lock()
+		s.recvClosed = true
+		// the server has returned, nothing more can be sent
+		finish := s.sendClosed || s.isClient
+		s.cond.Broadcast()
+		s.mtx.Unlock()
+		if finish {
+			s.finish()
+		}
+
+	case StreamCancel:
+		code := frame.Code
+		if code == CodeOK {
+			code = CodeUnknown
+		}
+		if s.abort(NewError(code, frame.Error)) {
+			s.finish()
+		}
+
+	case StreamWindow:
+		s.mtx.Lock()
+		s.sendWindow += frame.Window
+		s.cond.Broadcast()
+		s.mtx.Unlock()
+	}
+}
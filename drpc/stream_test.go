@@ -0,0 +1,272 @@
+package drpc
+
+import (
+	"github.com/yddeng/dnet"
+	"io"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// streamSide is a side of streamPipe, the messages sent on it are handled by the other side.
+type streamSide struct {
+	toPeer chan interface{}
+}
+
+func (c *streamSide) SendRequest(req *Request) error {
+	c.toPeer <- req
+	return nil
+}
+
+func (c *streamSide) SendResponse(resp *Response) error {
+	c.toPeer <- resp
+	return nil
+}
+
+func (c *streamSide) SendStreamFrame(frame *StreamFrame) error {
+	c.toPeer <- frame
+	return nil
+}
+
+// streamPipe connects client and server in memory, in order,
+// and returns the channel of client.
+func streamPipe(t *testing.T, server *Server, client *Client) StreamChannel {
+	cs := &streamSide{toPeer: make(chan interface{}, 1024)}
+	ss := &streamSide{toPeer: make(chan interface{}, 1024)}
+	go func() {
+		for msg := range cs.toPeer {
+			switch msg := msg.(type) {
+			case *Request:
+				_ = server.OnRPCRequest(ss, msg)
+			case *StreamFrame:
+				_ = server.OnStreamFrame(ss, msg)
+			}
+		}
+		server.OnChannelClose(ss)
+	}()
+	go func() {
+		for msg := range ss.toPeer {
+			switch msg := msg.(type) {
+			case *Response:
+				_ = client.OnRPCResponse(msg)
+			case *StreamFrame:
+				_ = client.OnStreamFrame(msg)
+			}
+		}
+	}()
+	t.Cleanup(func() {
+		close(cs.toPeer)
+	})
+	return cs
+}
+
+func numStreams(server *Server, client *Client) int {
+	n := 0
+	server.streams.Range(func(_, _ interface{}) bool { n++; return true })
+	client.streams.Range(func(_, _ interface{}) bool { n++; return true })
+	return n
+}
+
+func TestStream(t *testing.T) {
+	server := NewServer()
+	server.RegisterStream("count", func(stream *Stream) error {
+		n, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+		for i := 0; i < n.(int); i++ {
+			if err := stream.Send(i); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	server.RegisterStream("sum", func(stream *Stream) error {
+		sum := 0
+		for {
+			v, err := stream.Recv()
+			if err == io.EOF {
+				return stream.Send(sum)
+			} else if err != nil {
+				return err
+			}
+			sum += v.(int)
+		}
+	})
+	server.RegisterStream("fail", func(stream *Stream) error {
+		return Errorf(CodeInvalidArgument, "drpc: bad argument")
+	})
+	client := NewClient(WithClock(dnet.SystemClock))
+	channel := streamPipe(t, server, client)
+
+	// server streaming, more messages than the window
+	stream, err := client.NewStream(channel, "count", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = stream.Send(200)
+	_ = stream.CloseSend()
+	for i := 0; ; i++ {
+		v, err := stream.Recv()
+		if err == io.EOF {
+			if i != 200 {
+				t.Fatalf("received %d", i)
+			}
+			break
+		}
+		if err != nil || v != i {
+			t.Fatalf("recv %v %v, want %d", v, err, i)
+		}
+	}
+
+	// client streaming
+	stream, _ = client.NewStream(channel, "sum", nil)
+	for i := 1; i <= 200; i++ {
+		if err := stream.Send(i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_ = stream.CloseSend()
+	if v, err := stream.Recv(); v != 20100 || err != nil {
+		t.Fatalf("sum %v %v", v, err)
+	}
+	if _, err := stream.Recv(); err != io.EOF {
+		t.Fatalf("recv after the end: %v", err)
+	}
+
+	stream, _ = client.NewStream(channel, "fail", nil)
+	if _, err := stream.Recv(); CodeOf(err) != CodeInvalidArgument {
+		t.Fatalf("recv of the failed stream: %v", err)
+	}
+	stream, _ = client.NewStream(channel, "none", nil)
+	if _, err := stream.Recv(); CodeOf(err) != CodeNotFound {
+		t.Fatalf("recv of the unknown method: %v", err)
+	}
+}
+
+func TestStreamWindow(t *testing.T) {
+	var sent int32
+	server := NewServer()
+	server.RegisterStream("count", func(stream *Stream) error {
+		for i := 0; i < 10; i++ {
+			if err := stream.Send(i); err != nil {
+				return err
+			}
+			atomic.AddInt32(&sent, 1)
+		}
+		return nil
+	})
+	client := NewClient(WithClock(dnet.SystemClock), WithStreamWindow(2))
+	channel := streamPipe(t, server, client)
+
+	stream, err := client.NewStream(channel, "count", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the server is blocked when the window of client is full
+	time.Sleep(50 * time.Millisecond)
+	if n := atomic.LoadInt32(&sent); n != 2 {
+		t.Fatalf("sent %d over the window 2", n)
+	}
+
+	// the window is granted as the client receives
+	if v, err := stream.Recv(); v != 0 || err != nil {
+		t.Fatalf("recv %v %v", v, err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if n := atomic.LoadInt32(&sent); n != 3 {
+		t.Fatalf("sent %d after a message is received", n)
+	}
+	for i := 1; i < 10; i++ {
+		if v, err := stream.Recv(); v != i || err != nil {
+			t.Fatalf("recv %v %v, want %d", v, err, i)
+		}
+	}
+	if _, err := stream.Recv(); err != io.EOF {
+		t.Fatalf("recv after the end: %v", err)
+	}
+}
+
+func TestStreamWindowExceeded(t *testing.T) {
+	channel := &streamSide{toPeer: make(chan interface{}, 8)}
+	stream := newStream(channel, 1, "count", nil, DefaultStreamWindow, 2, true)
+	for i := 0; i < 3; i++ {
+		stream.onFrame(&StreamFrame{ID: 1, Flag: StreamData, Data: i})
+	}
+	// the peer sent over the window, the stream is canceled
+	select {
+	case <-stream.Done():
+	default:
+		t.Fatal("stream is not canceled")
+	}
+	if CodeOf(stream.Err()) != CodeResourceExhausted {
+		t.Fatalf("stream err %v", stream.Err())
+	}
+	frame := (<-channel.toPeer).(*StreamFrame)
+	if frame.Flag != StreamCancel || frame.Code != CodeResourceExhausted {
+		t.Fatalf("frame %+v", frame)
+	}
+}
+
+func TestStreamCancel(t *testing.T) {
+	server := NewServer()
+	canceled := make(chan error, 1)
+	server.RegisterStream("wait", func(stream *Stream) error {
+		<-stream.Done()
+		canceled <- stream.Err()
+		return stream.Err()
+	})
+	client := NewClient(WithClock(dnet.SystemClock))
+	channel := streamPipe(t, server, client)
+
+	stream, _ := client.NewStream(channel, "wait", nil)
+	time.Sleep(10 * time.Millisecond)
+	stream.Cancel(nil)
+	if _, err := stream.Recv(); err != ErrStreamCanceled {
+		t.Fatalf("recv after cancel: %v", err)
+	}
+	select {
+	case err := <-canceled:
+		if CodeOf(err) != CodeCanceled {
+			t.Fatalf("server err %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the server stream is not canceled")
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for numStreams(server, client) != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("streams are not removed: %d", numStreams(server, client))
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// valueChannel is a channel of a struct value which is not comparable.
+type valueChannel struct {
+	frames []*StreamFrame
+	sent   chan *StreamFrame
+}
+
+func (c valueChannel) SendRequest(req *Request) error    { return nil }
+func (c valueChannel) SendResponse(resp *Response) error { return nil }
+func (c valueChannel) SendStreamFrame(frame *StreamFrame) error {
+	c.sent <- frame
+	return nil
+}
+
+// the streams of the channels not comparable are refused instead of a panic
+func TestStreamChannelNotComparable(t *testing.T) {
+	server := NewServer()
+	server.RegisterStream("watch", func(stream *Stream) error { return nil })
+	channel := valueChannel{sent: make(chan *StreamFrame, 1)}
+
+	if err := server.OnStreamFrame(channel, &StreamFrame{ID: 1, Flag: StreamOpen, Method: "watch"}); err == nil {
+		t.Fatal("stream on the channel not comparable")
+	}
+	if frame := <-channel.sent; frame.Flag != StreamCancel || frame.Code != CodeUnimplemented {
+		t.Fatalf("frame %+v", frame)
+	}
+	server.OnChannelClose(channel)
+}
@@ -5,25 +5,13 @@ import (
 	"github.com/golang/protobuf/proto"
 	"github.com/yddeng/dnet"
 	"github.com/yddeng/dnet/drpc"
+	"github.com/yddeng/dnet/drpc/codec"
 	"github.com/yddeng/dnet/examples/pb"
-	"github.com/yddeng/dnet/examples/rpc/codec"
 	"time"
 )
 
-type channel struct {
-	session dnet.Session
-}
-
-func (this *channel) SendRequest(req *drpc.Request) error {
-	return this.session.Send(req)
-}
-
-func (this *channel) SendResponse(resp *drpc.Response) error {
-	return this.session.Send(resp)
-}
-
 func main() {
-	rpcClient := drpc.NewClient()
+	endpoint := drpc.NewEndpoint(nil)
 
 	addr := "localhost:7756"
 	conn, err := dnet.DialTCP(addr, 0)
@@ -33,26 +21,21 @@ func main() {
 	}
 
 	session := dnet.NewTCPSession(conn,
-		dnet.WithCodec(codec.NewRpcCodec()),
+		dnet.WithCodec(codec.NewCodec(codec.NewProtobufMarshaler())),
 		dnet.WithErrorCallback(func(session dnet.Session, err error) {
 			fmt.Println("onError", err)
 		}),
 
 		dnet.WithCloseCallback(func(session dnet.Session, reason error) {
 			fmt.Println("onClose", reason)
+			endpoint.OnClose(session, reason)
 		}),
 		dnet.WithMessageCallback(func(session dnet.Session, data interface{}) {
-			var err error
-			switch data.(type) {
-			case *drpc.Response:
-				err = rpcClient.OnRPCResponse(data.(*drpc.Response))
-			default:
-				err = fmt.Errorf("invailed type")
-			}
-			if err != nil {
+			if _, err := endpoint.OnMessage(session, data); err != nil {
 				fmt.Println("read", err)
 			}
 		}))
+	endpoint.Attach(session)
 
 	msg := &pb.EchoToS{
 		Msg: proto.String("hello node1,i'm node2"),
@@ -60,12 +43,12 @@ func main() {
 
 	fmt.Println("sync Call")
 	// sync
-	ret, err := rpcClient.Call(&channel{session: session}, proto.MessageName(msg), msg, drpc.DefaultRPCTimeout)
+	ret, err := endpoint.Call(proto.MessageName(msg), msg, drpc.DefaultRPCTimeout)
 	fmt.Println(ret, err)
 
 	fmt.Println("async Call")
 	// async
-	rpcClient.Go(&channel{session: session}, proto.MessageName(msg), msg, drpc.DefaultRPCTimeout, func(i interface{}, e error) {
+	endpoint.Go(proto.MessageName(msg), msg, drpc.DefaultRPCTimeout, func(i interface{}, e error) {
 		if e != nil {
 			fmt.Println("Call", e)
 			return
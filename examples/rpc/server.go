@@ -6,8 +6,8 @@ import (
 	"github.com/pkg/errors"
 	"github.com/yddeng/dnet"
 	"github.com/yddeng/dnet/drpc"
+	"github.com/yddeng/dnet/drpc/codec"
 	"github.com/yddeng/dnet/examples/pb"
-	"github.com/yddeng/dnet/examples/rpc/codec"
 	"net"
 	"sync/atomic"
 	"time"
@@ -28,18 +28,6 @@ func echo(replyer *drpc.Replier, arg interface{}) {
 	replyer.Reply(&pb.EchoToC{Msg: proto.String("ok")}, nil)
 }
 
-type channel struct {
-	session dnet.Session
-}
-
-func (this *channel) SendRequest(req *drpc.Request) error {
-	return this.session.Send(req)
-}
-
-func (this *channel) SendResponse(resp *drpc.Response) error {
-	return this.session.Send(resp)
-}
-
 func main() {
 
 	rpcServer := drpc.NewServer()
@@ -50,23 +38,18 @@ func main() {
 		if err := dnet.ServeTCPFunc(addr, func(conn net.Conn) {
 			fmt.Println("new client", conn.RemoteAddr().String())
 
+			endpoint := drpc.NewEndpoint(rpcServer)
 			dnet.NewTCPSession(conn,
-				dnet.WithCodec(codec.NewRpcCodec()),
+				dnet.WithCodec(codec.NewCodec(codec.NewProtobufMarshaler())),
 				dnet.WithErrorCallback(func(session dnet.Session, err error) {
 					fmt.Println("onError", err)
 				}),
 				dnet.WithCloseCallback(func(session dnet.Session, reason error) {
 					fmt.Println("onClose", reason)
+					endpoint.OnClose(session, reason)
 				}),
 				dnet.WithMessageCallback(func(session dnet.Session, data interface{}) {
-					var err error
-					switch data.(type) {
-					case *drpc.Request:
-						err = rpcServer.OnRPCRequest(&channel{session: session}, data.(*drpc.Request))
-					default:
-						err = fmt.Errorf("invailed type")
-					}
-					if err != nil {
+					if _, err := endpoint.OnMessage(session, data); err != nil {
 						fmt.Println("read", err)
 					}
 				}))
@@ -0,0 +1,598 @@
+package dnet
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+var (
+	ErrMuxClosed   = errors.New("dnet: mux is closed. ")
+	ErrStreamReset = errors.New("dnet: mux stream is reset. ")
+	errStreamClose = errors.New("dnet: use of closed mux stream. ")
+)
+
+// the commands of the frames
+const (
+	muxSYN    byte = iota + 1 // opens a stream
+	muxData                   // the data of a stream
+	muxUpdate                 // the length is the bytes read, which opens the window of the sender
+	muxFin                    // no more data of a stream
+	muxRst                    // resets a stream
+)
+
+// cmd(1) id(4) length(4)
+const muxHeaderSize = 9
+
+const (
+	// the window of a new stream, which is opened to MuxOptions.StreamWindow by an update
+	muxInitialWindow = 256 << 10
+	muxMaxFrameSize  = 32 << 10
+	// the max number of the control frames pending, the updates of a stream are merged into one.
+	// The mux is closed over it, as the peer does not read them
+	muxControlBacklog = 4096
+)
+
+// Mux carries the independent streams over one connection, each stream is a net.Conn
+// with its own flow control window, so a stream not read does not block the others.
+// The streams are opened by Open, and accepted by Accept or Serve of the peer.
+// The client opens the odd ids, and the server opens the even ids.
+//
+//	mux := dnet.NewMuxClient(conn)
+//	chat, err := mux.Open()
+//	session := dnet.NewTCPSession(chat, ...)
+//
+//	mux := dnet.NewMuxServer(conn)
+//	go mux.ServeFunc(func(stream net.Conn) {
+//		dnet.NewTCPSession(stream, ...)
+//	})
+type Mux struct {
+	conn   net.Conn
+	opts   *MuxOptions
+	nextID uint32
+
+	writeMtx sync.Mutex
+
+	// the control frames of the read loop, written by controlLoop
+	controlMtx     sync.Mutex
+	controlRsts    []uint32
+	controlUpdates map[uint32]uint32
+	controlNotify  chan struct{}
+
+	mtx      sync.Mutex
+	streams  map[uint32]*muxStream
+	err      error
+	acceptCh chan *muxStream
+	doneCh   chan struct{}
+}
+
+// NewMuxClient returns the Mux of the client side of conn.
+func NewMuxClient(conn net.Conn, options ...MuxOption) *Mux {
+	return newMux(conn, 1, options...)
+}
+
+// NewMuxServer returns the Mux of the server side of conn.
+func NewMuxServer(conn net.Conn, options ...MuxOption) *Mux {
+	return newMux(conn, 2, options...)
+}
+
+func newMux(conn net.Conn, firstID uint32, options ...MuxOption) *Mux {
+	opts := loadMuxOptions(options...)
+	m := &Mux{
+		conn:           conn,
+		opts:           opts,
+		nextID:         firstID,
+		streams:        map[uint32]*muxStream{},
+		acceptCh:       make(chan *muxStream, opts.AcceptBacklog),
+		doneCh:         make(chan struct{}),
+		controlUpdates: map[uint32]uint32{},
+		controlNotify:  make(chan struct{}, 1),
+	}
+	go m.readLoop()
+	go m.controlLoop()
+	return m
+}
+
+// Open opens a new stream.
+func (m *Mux) Open() (net.Conn, error) {
+	m.mtx.Lock()
+	if m.err != nil {
+		m.mtx.Unlock()
+		return nil, m.err
+	}
+	id := m.nextID
+	m.nextID += 2
+	s := newMuxStream(m, id)
+	m.streams[id] = s
+	m.mtx.Unlock()
+
+	if err := m.writeFrame(muxSYN, id, 0, nil); err != nil {
+		return nil, err
+	}
+	s.openWindow()
+	return s, nil
+}
+
+// Accept waits for the next stream opened by the peer.
+// It returns io.EOF after the mux is closed.
+func (m *Mux) Accept() (net.Conn, error) {
+	select {
+	case s := <-m.acceptCh:
+		s.openWindow()
+		return s, nil
+	case <-m.doneCh:
+		return nil, io.EOF
+	}
+}
+
+// Serve accepts the streams, and calls handler in new goroutines.
+// It returns io.EOF after the mux is closed.
+func (m *Mux) Serve(handler AcceptorHandler) error {
+	if handler == nil {
+		return errors.New("dnet:Serve handler is nil. ")
+	}
+	for {
+		s, err := m.Accept()
+		if err != nil {
+			return err
+		}
+		go handler.OnConnection(s)
+	}
+}
+
+// ServeFunc accepts the streams, and calls handler in new goroutines.
+func (m *Mux) ServeFunc(handler AcceptorHandlerFunc) error {
+	return m.Serve(handler)
+}
+
+// Stop closes the mux.
+func (m *Mux) Stop() {
+	_ = m.Close()
+}
+
+// Addr returns the local address of the connection.
+func (m *Mux) Addr() net.Addr {
+	return m.conn.LocalAddr()
+}
+
+// Close closes the connection, the streams are broken.
+func (m *Mux) Close() error {
+	m.closeWithErr(ErrMuxClosed)
+	return nil
+}
+
+// NumStreams returns the number of the streams not closed.
+func (m *Mux) NumStreams() int {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	return len(m.streams)
+}
+
+func (m *Mux) closeWithErr(err error) {
+	m.mtx.Lock()
+	if m.err != nil {
+		m.mtx.Unlock()
+		return
+	}
+	m.err = err
+	streams := m.streams
+	m.streams = map[uint32]*muxStream{}
+	close(m.doneCh)
+	m.mtx.Unlock()
+
+	_ = m.conn.Close()
+	for _, s := range streams {
+		s.notify()
+	}
+}
+
+func (m *Mux) getErr() error {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	return m.err
+}
+
+func muxFrame(cmd byte, id, length uint32, data []byte) []byte {
+	b := make([]byte, muxHeaderSize+len(data))
+	b[0] = cmd
+	binary.BigEndian.PutUint32(b[1:], id)
+	binary.BigEndian.PutUint32(b[5:], length)
+	copy(b[muxHeaderSize:], data)
+	return b
+}
+
+func (m *Mux) writeFrame(cmd byte, id, length uint32, data []byte) error {
+	return m.write(muxFrame(cmd, id, length, data))
+}
+
+func (m *Mux) write(b []byte) error {
+	m.writeMtx.Lock()
+	defer m.writeMtx.Unlock()
+	if err := m.getErr(); err != nil {
+		return err
+	}
+	if _, err := m.conn.Write(b); err != nil {
+		m.closeWithErr(err)
+		return err
+	}
+	return nil
+}
+
+// writeControl queues the control frame of the read loop, which must not be blocked
+// by the writes. The updates of a stream are merged, and the mux is closed if the
+// frames pending are over muxControlBacklog.
+func (m *Mux) writeControl(cmd byte, id, length uint32) {
+	m.controlMtx.Lock()
+	if cmd == muxRst {
+		m.controlRsts = append(m.controlRsts, id)
+	} else {
+		m.controlUpdates[id] += length
+	}
+	pending := len(m.controlRsts) + len(m.controlUpdates)
+	m.controlMtx.Unlock()
+
+	if pending > muxControlBacklog {
+		m.closeWithErr(errors.New("dnet: mux control frames are not read by the peer"))
+		return
+	}
+	sendNotifyChan(m.controlNotify)
+}
+
+// controlLoop writes the control frames queued, until the mux is closed.
+func (m *Mux) controlLoop() {
+	for {
+		select {
+		case <-m.controlNotify:
+		case <-m.doneCh:
+			return
+		}
+
+		m.controlMtx.Lock()
+		var b []byte
+		for id, length := range m.controlUpdates {
+			b = append(b, muxFrame(muxUpdate, id, length, nil)...)
+		}
+		for _, id := range m.controlRsts {
+			b = append(b, muxFrame(muxRst, id, 0, nil)...)
+		}
+		m.controlUpdates = map[uint32]uint32{}
+		m.controlRsts = nil
+		m.controlMtx.Unlock()
+
+		if len(b) > 0 && m.write(b) != nil {
+			return
+		}
+	}
+}
+
+func (m *Mux) readLoop() {
+	header := make([]byte, muxHeaderSize)
+	for {
+		if _, err := io.ReadFull(m.conn, header); err != nil {
+			m.closeWithErr(err)
+			return
+		}
+		cmd := header[0]
+		id := binary.BigEndian.Uint32(header[1:])
+		length := binary.BigEndian.Uint32(header[5:])
+
+		var data []byte
+		if cmd == muxData {
+			if length > muxMaxFrameSize {
+				m.closeWithErr(fmt.Errorf("dnet: mux frame size %d is too large", length))
+				return
+			}
+			data = make([]byte, length)
+			if _, err := io.ReadFull(m.conn, data); err != nil {
+				m.closeWithErr(err)
+				return
+			}
+		}
+		if err := m.handleFrame(cmd, id, length, data); err != nil {
+			m.closeWithErr(err)
+			return
+		}
+	}
+}
+
+func (m *Mux) handleFrame(cmd byte, id, length uint32, data []byte) error {
+	m.mtx.Lock()
+	s := m.streams[id]
+	if cmd == muxSYN {
+		if s != nil || id%2 == m.nextID%2 {
+			m.mtx.Unlock()
+			return fmt.Errorf("dnet: mux stream %d is opened twice", id)
+		}
+		s = newMuxStream(m, id)
+		select {
+		case m.acceptCh <- s:
+			m.streams[id] = s
+			m.mtx.Unlock()
+		default:
+			m.mtx.Unlock()
+			// the backlog is full
+			m.writeControl(muxRst, id, 0)
+		}
+		return nil
+	}
+	m.mtx.Unlock()
+
+	if s == nil {
+		// the stream is closed, the data is discarded
+		if cmd == muxData {
+			m.writeControl(muxUpdate, id, length)
+		}
+		return nil
+	}
+	switch cmd {
+	case muxData:
+		return s.pushData(data)
+	case muxUpdate:
+		s.updateWindow(length)
+	case muxFin:
+		s.remoteClose()
+	case muxRst:
+		s.resetByPeer()
+	default:
+		return fmt.Errorf("dnet: mux frame command %d is unknown", cmd)
+	}
+	return nil
+}
+
+func (m *Mux) removeStream(id uint32) {
+	m.mtx.Lock()
+	delete(m.streams, id)
+	m.mtx.Unlock()
+}
+
+// muxStream is a stream of Mux, it implements net.Conn.
+type muxStream struct {
+	mux *Mux
+	id  uint32
+
+	mtx        sync.Mutex
+	recvBuf    []byte
+	recvWindow uint32 // the bytes can be received
+	consumed   uint32 // the bytes read and not updated to the peer
+	sendWindow uint32 // the bytes can be sent
+	closed     bool   // closed by Close, FIN is sent
+	remoteFin  bool
+	reset      bool
+
+	readNotify    chan struct{}
+	writeNotify   chan struct{}
+	closeCh       chan struct{}
+	readDeadline  deadline
+	writeDeadline deadline
+}
+
+func newMuxStream(mux *Mux, id uint32) *muxStream {
+	return &muxStream{
+		mux:           mux,
+		id:            id,
+		recvWindow:    muxInitialWindow,
+		sendWindow:    muxInitialWindow,
+		readNotify:    make(chan struct{}, 1),
+		writeNotify:   make(chan struct{}, 1),
+		closeCh:       make(chan struct{}),
+		readDeadline:  makeDeadline(),
+		writeDeadline: makeDeadline(),
+	}
+}
+
+// openWindow opens the window to MuxOptions.StreamWindow.
+func (s *muxStream) openWindow() {
+	if window := s.mux.opts.StreamWindow; window > muxInitialWindow {
+		s.mtx.Lock()
+		s.recvWindow += window - muxInitialWindow
+		s.mtx.Unlock()
+		_ = s.mux.writeFrame(muxUpdate, s.id, window-muxInitialWindow, nil)
+	}
+}
+
+func (s *muxStream) notify() {
+	sendNotifyChan(s.readNotify)
+	sendNotifyChan(s.writeNotify)
+}
+
+func (s *muxStream) pushData(data []byte) error {
+	s.mtx.Lock()
+	if uint32(len(data)) > s.recvWindow {
+		s.mtx.Unlock()
+		return fmt.Errorf("dnet: mux stream %d receives %d bytes over the window %d", s.id, len(data), s.recvWindow)
+	}
+	s.recvWindow -= uint32(len(data))
+	if s.closed || s.reset {
+		// not read any more
+		s.recvWindow += uint32(len(data))
+		s.mtx.Unlock()
+		s.mux.writeControl(muxUpdate, s.id, uint32(len(data)))
+		return nil
+	}
+	s.recvBuf = append(s.recvBuf, data...)
+	s.mtx.Unlock()
+	sendNotifyChan(s.readNotify)
+	return nil
+}
+
+func (s *muxStream) updateWindow(delta uint32) {
+	s.mtx.Lock()
+	s.sendWindow += delta
+	s.mtx.Unlock()
+	sendNotifyChan(s.writeNotify)
+}
+
+func (s *muxStream) remoteClose() {
+	s.mtx.Lock()
+	s.remoteFin = true
+	done := s.closed
+	s.mtx.Unlock()
+	sendNotifyChan(s.readNotify)
+	if done {
+		s.mux.removeStream(s.id)
+	}
+}
+
+func (s *muxStream) resetByPeer() {
+	s.mtx.Lock()
+	s.reset = true
+	s.mtx.Unlock()
+	s.notify()
+	s.mux.removeStream(s.id)
+}
+
+// Read reads the data of the stream, it returns io.EOF after the peer closes the stream.
+func (s *muxStream) Read(b []byte) (int, error) {
+	for {
+		s.mtx.Lock()
+		if len(s.recvBuf) > 0 {
+			n := copy(b, s.recvBuf)
+			s.recvBuf = s.recvBuf[n:]
+			if len(s.recvBuf) == 0 {
+				s.recvBuf = nil
+			}
+			// updates the peer after half of the window is read
+			s.consumed += uint32(n)
+			var delta uint32
+			if s.consumed >= s.mux.opts.StreamWindow/2 {
+				delta, s.consumed = s.consumed, 0
+				s.recvWindow += delta
+			}
+			s.mtx.Unlock()
+			if delta > 0 {
+				_ = s.mux.writeFrame(muxUpdate, s.id, delta, nil)
+			}
+			return n, nil
+		}
+		err := s.err()
+		if err == nil && s.remoteFin {
+			err = io.EOF
+		}
+		s.mtx.Unlock()
+		if err != nil {
+			return 0, err
+		}
+
+		select {
+		case <-s.readNotify:
+		case <-s.closeCh:
+		case <-s.readDeadline.wait():
+			return 0, timeoutError{}
+		}
+	}
+}
+
+// err returns the error of the stream, s.mtx is locked.
+func (s *muxStream) err() error {
+	switch {
+	case s.closed:
+		return errStreamClose
+	case s.reset:
+		return ErrStreamReset
+	}
+	return s.mux.getErr()
+}
+
+// Write writes the data in frames, it blocks if the window of the peer is full.
+func (s *muxStream) Write(b []byte) (int, error) {
+	n := 0
+	for n < len(b) {
+		s.mtx.Lock()
+		if err := s.err(); err != nil {
+			s.mtx.Unlock()
+			return n, err
+		}
+		if s.sendWindow == 0 {
+			s.mtx.Unlock()
+			select {
+			case <-s.writeNotify:
+			case <-s.closeCh:
+			case <-s.writeDeadline.wait():
+				return n, timeoutError{}
+			}
+			continue
+		}
+		size := uint32(len(b) - n)
+		if size > s.sendWindow {
+			size = s.sendWindow
+		}
+		if size > muxMaxFrameSize {
+			size = muxMaxFrameSize
+		}
+		s.sendWindow -= size
+		s.mtx.Unlock()
+
+		if err := s.mux.writeFrame(muxData, s.id, size, b[n:n+int(size)]); err != nil {
+			return n, err
+		}
+		n += int(size)
+	}
+	return n, nil
+}
+
+// Close sends FIN to the peer, the data received after it is discarded.
+// Any blocked Read or Write operations will be unblocked and return errors.
+func (s *muxStream) Close() error {
+	s.mtx.Lock()
+	if s.closed {
+		s.mtx.Unlock()
+		return errStreamClose
+	}
+	s.closed = true
+	done, reset := s.remoteFin || s.reset, s.reset
+	// the data not read is discarded, and the window of the peer is opened
+	// for the data it is sending
+	delta := uint32(len(s.recvBuf)) + s.consumed
+	s.recvWindow += delta
+	s.recvBuf, s.consumed = nil, 0
+	close(s.closeCh)
+	s.mtx.Unlock()
+
+	if done {
+		s.mux.removeStream(s.id)
+	}
+	if reset {
+		return nil
+	}
+	if delta > 0 && !done {
+		_ = s.mux.writeFrame(muxUpdate, s.id, delta, nil)
+	}
+	if err := s.mux.writeFrame(muxFin, s.id, 0, nil); err != nil && err != s.mux.getErr() {
+		return err
+	}
+	return nil
+}
+
+func (s *muxStream) LocalAddr() net.Addr {
+	return s.mux.conn.LocalAddr()
+}
+
+func (s *muxStream) RemoteAddr() net.Addr {
+	return s.mux.conn.RemoteAddr()
+}
+
+func (s *muxStream) SetDeadline(t time.Time) error {
+	s.readDeadline.set(t)
+	s.writeDeadline.set(t)
+	return nil
+}
+
+func (s *muxStream) SetReadDeadline(t time.Time) error {
+	s.readDeadline.set(t)
+	return nil
+}
+
+func (s *muxStream) SetWriteDeadline(t time.Time) error {
+	s.writeDeadline.set(t)
+	return nil
+}
+
+var (
+	_ Acceptor     = (*Mux)(nil)
+	_ net.Listener = (*Mux)(nil)
+	_ net.Conn     = (*muxStream)(nil)
+)
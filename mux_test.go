@@ -0,0 +1,289 @@
+package dnet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newMuxPair(options ...MuxOption) (client, server *Mux) {
+	c, s := net.Pipe()
+	return NewMuxClient(c, options...), NewMuxServer(s, options...)
+}
+
+func TestMuxSession(t *testing.T) {
+	client, server := newMuxPair()
+	defer client.Close()
+	defer server.Close()
+
+	// both sides echo the messages of the streams opened by the peer
+	echo := func(conn net.Conn) {
+		NewTCPSession(conn, WithMessageCallback(func(session Session, message interface{}) {
+			_ = session.Send(message)
+		}))
+	}
+	go client.ServeFunc(echo)
+	go server.ServeFunc(echo)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		m := client
+		if i%2 == 1 {
+			m = server
+		}
+		wg.Add(1)
+		go func(i int, m *Mux) {
+			defer wg.Done()
+			conn, err := m.Open()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			msgCh := make(chan interface{}, 10)
+			session := NewTCPSession(conn, WithMessageCallback(func(session Session, message interface{}) {
+				msgCh <- message
+			}))
+			defer session.Close(nil)
+
+			for j := 0; j < 10; j++ {
+				data := bytes.Repeat([]byte{byte(i)}, 1+j*6000)
+				if err := session.Send(data); err != nil {
+					t.Error(err)
+					return
+				}
+				select {
+				case msg := <-msgCh:
+					if !bytes.Equal(msg.([]byte), data) {
+						t.Errorf("stream %d message %d is not echoed", i, j)
+						return
+					}
+				case <-time.After(5 * time.Second):
+					t.Errorf("stream %d message %d timeout", i, j)
+					return
+				}
+			}
+		}(i, m)
+	}
+	wg.Wait()
+}
+
+func TestMuxFlowControl(t *testing.T) {
+	client, server := newMuxPair()
+	defer client.Close()
+	defer server.Close()
+
+	stalled, err := client.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := client.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stalledPeer, _ := server.Accept()
+	otherPeer, _ := server.Accept()
+
+	// the peer of stalled does not read, the writes over the window are blocked
+	data := make([]byte, muxInitialWindow+1000)
+	_ = stalled.SetWriteDeadline(time.Now().Add(200 * time.Millisecond))
+	n, err := stalled.Write(data)
+	if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+		t.Fatalf("write over the window: %d %v", n, err)
+	}
+	if n != muxInitialWindow {
+		t.Fatalf("written %d, want %d", n, muxInitialWindow)
+	}
+
+	// the other stream is not blocked
+	go func() { _, _ = other.Write([]byte("hello")) }()
+	buf := make([]byte, 5)
+	_ = otherPeer.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := io.ReadFull(otherPeer, buf); err != nil || string(buf) != "hello" {
+		t.Fatalf("read the other stream: %q %v", buf, err)
+	}
+
+	// the window is opened after the peer reads
+	_ = stalled.SetWriteDeadline(time.Time{})
+	done := make(chan error, 1)
+	go func() {
+		_, err := stalled.Write(data[n:])
+		done <- err
+	}()
+	if _, err := io.ReadFull(stalledPeer, make([]byte, len(data))); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMuxStreamWindow(t *testing.T) {
+	window := uint32(1 << 20)
+	client, server := newMuxPair(WithMuxStreamWindow(window))
+	defer client.Close()
+	defer server.Close()
+
+	conn, _ := client.Open()
+	if _, err := server.Accept(); err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.SetWriteDeadline(time.Now().Add(200 * time.Millisecond))
+	n, _ := conn.Write(make([]byte, window+1000))
+	if n != int(window) {
+		t.Fatalf("written %d, want %d", n, window)
+	}
+}
+
+func TestMuxStreamClose(t *testing.T) {
+	client, server := newMuxPair()
+	defer client.Close()
+	defer server.Close()
+
+	conn, _ := client.Open()
+	peer, _ := server.Accept()
+	if _, err := conn.Write([]byte("bye")); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Write([]byte("x")); err == nil {
+		t.Fatal("write after close")
+	}
+
+	// the data before FIN is read, then io.EOF
+	b, err := io.ReadAll(peer)
+	if err != nil || string(b) != "bye" {
+		t.Fatalf("read %q %v", b, err)
+	}
+	_ = peer.Close()
+
+	deadline := time.Now().Add(time.Second)
+	for client.NumStreams() != 0 || server.NumStreams() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("streams are not removed: %d %d", client.NumStreams(), server.NumStreams())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestMuxClose(t *testing.T) {
+	client, server := newMuxPair()
+
+	conn, _ := client.Open()
+	if _, err := server.Accept(); err != nil {
+		t.Fatal(err)
+	}
+
+	served := make(chan error, 1)
+	go func() {
+		served <- server.ServeFunc(func(conn net.Conn) {})
+	}()
+
+	readErr := make(chan error, 1)
+	go func() {
+		_, err := conn.Read(make([]byte, 1))
+		readErr <- err
+	}()
+	_ = client.Close()
+
+	select {
+	case err := <-readErr:
+		if err == nil {
+			t.Fatal("read after the mux is closed")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("read is not unblocked")
+	}
+	if _, err := client.Open(); err != ErrMuxClosed {
+		t.Fatalf("open after close: %v", err)
+	}
+	select {
+	case err := <-served:
+		if err != io.EOF {
+			t.Fatalf("serve returns %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("serve is not stopped by the peer")
+	}
+}
+
+// readMuxControl reads the control frames until the peer stops writing.
+func readMuxControl(t *testing.T, c net.Conn) (rsts int, updates map[uint32]uint32) {
+	updates = map[uint32]uint32{}
+	header := make([]byte, muxHeaderSize)
+	for {
+		_ = c.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		if _, err := io.ReadFull(c, header); err != nil {
+			return
+		}
+		switch header[0] {
+		case muxRst:
+			rsts++
+		case muxUpdate:
+			updates[binary.BigEndian.Uint32(header[1:])] += binary.BigEndian.Uint32(header[5:])
+		default:
+			t.Fatalf("frame %d", header[0])
+		}
+	}
+}
+
+func TestMuxControlFrames(t *testing.T) {
+	c, s := net.Pipe()
+	server := NewMuxServer(s, WithMuxAcceptBacklog(1))
+	defer server.Close()
+	defer c.Close()
+
+	// the SYNs over the backlog are reset, the peer does not read the RSTs
+	n := 300
+	_ = c.SetWriteDeadline(time.Now().Add(time.Second))
+	for i := 0; i < n; i++ {
+		if _, err := c.Write(muxFrame(muxSYN, uint32(2*i+1), 0, nil)); err != nil {
+			t.Fatalf("the read loop is blocked at the frame %d: %v", i, err)
+		}
+	}
+	// the data of the streams closed is updated by the merged frames
+	for i := 0; i < n; i++ {
+		if _, err := c.Write(muxFrame(muxData, 1001, 1, []byte{0})); err != nil {
+			t.Fatalf("the read loop is blocked at the data %d: %v", i, err)
+		}
+	}
+
+	rsts, updates := readMuxControl(t, c)
+	if rsts != n-1 {
+		t.Fatalf("%d RSTs of %d SYNs", rsts, n-1)
+	}
+	if len(updates) != 1 || updates[1001] != uint32(n) {
+		t.Fatalf("updates %v", updates)
+	}
+	if server.getErr() != nil {
+		t.Fatal(server.getErr())
+	}
+}
+
+// the mux is closed if the peer does not read the control frames
+func TestMuxControlFramesNotRead(t *testing.T) {
+	c, s := net.Pipe()
+	server := NewMuxServer(s, WithMuxAcceptBacklog(1))
+	defer server.Close()
+	defer c.Close()
+
+	_ = c.SetWriteDeadline(time.Now().Add(time.Second))
+	// the first frames may be taken by the write blocked
+	for i := 0; i < 2*muxControlBacklog+2; i++ {
+		if _, err := c.Write(muxFrame(muxSYN, uint32(2*i+1), 0, nil)); err != nil {
+			break
+		}
+	}
+	deadline := time.Now().Add(time.Second)
+	for server.getErr() == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("the mux is not closed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -1,6 +1,10 @@
 package dnet
 
-import "time"
+import (
+	"net/http"
+	"os"
+	"time"
+)
 
 type Option func(opt *Options)
 
@@ -48,6 +52,16 @@ type Options struct {
 
 	// encoder and decoder
 	Codec Codec
+
+	// the clock of ReadTimeout and WriteTimeout. default SystemClock
+	Clock Clock
+
+	// WSSession keeps the frames without a codec, MsgCallback gets *WSMessage of each frame,
+	// and Send takes *WSMessage, string as a text frame or []byte as a binary frame.
+	WSMessage bool
+
+	// maps the reason of Close to the close frame of WSSession. default DefaultWSCloseCode
+	WSCloseCode func(reason error) (code int, text string)
 }
 
 // WithOptions accepts the whole options config.
@@ -106,3 +120,388 @@ func WithCloseCallback(closeCallback func(session Session, reason error)) Option
 		opt.CloseCallback = closeCallback
 	}
 }
+
+// WithWSMessage sets WSSession to keep the frames and their types.
+func WithWSMessage() Option {
+	return func(opt *Options) {
+		opt.WSMessage = true
+	}
+}
+
+// WithWSCloseCode sets the map of the reason of Close to the close frame of WSSession.
+func WithWSCloseCode(closeCode func(reason error) (code int, text string)) Option {
+	return func(opt *Options) {
+		opt.WSCloseCode = closeCode
+	}
+}
+
+// WithClock sets the clock of the timeouts.
+func WithClock(clock Clock) Option {
+	return func(opt *Options) {
+		opt.Clock = clock
+	}
+}
+
+type WSOption func(opt *WSOptions)
+
+// loadWSOptions returns an initialized *WSOptions with options
+func loadWSOptions(options ...WSOption) *WSOptions {
+	opts := new(WSOptions)
+	for _, option := range options {
+		option(opts)
+	}
+	return opts
+}
+
+// WSOptions contains the options of the WebSocket upgrade of WSAcceptor and NewWSHandler.
+type WSOptions struct {
+	// returns true if the origin of request is allowed. default allows all,
+	// or the origins in AllowedOrigins if it is set.
+	CheckOrigin func(r *http.Request) bool
+
+	// the origins allowed, such as "https://example.com". the requests without Origin are allowed.
+	AllowedOrigins []string
+
+	// the subprotocols supported by the server in order of preference,
+	// the one negotiated is returned by WSConn.Subprotocol.
+	Subprotocols []string
+
+	// negotiates the per-message deflate with the client
+	EnableCompression bool
+
+	// the sizes of the I/O buffers. default 4096
+	ReadBufferSize, WriteBufferSize int
+
+	// called before the upgrade, the request is rejected with the status and the error
+	// if the error is not nil. default status is http.StatusUnauthorized
+	Authenticate func(r *http.Request) (status int, err error)
+
+	// the timeouts of the http.Server of WSAcceptor, zero means no timeout
+	ReadHeaderTimeout, IdleTimeout time.Duration
+
+	// the max size of the request headers of WSAcceptor. default http.DefaultMaxHeaderBytes
+	MaxHeaderBytes int
+
+	// parses the PROXY protocol v1 and v2 headers of the connections of WSAcceptor
+	// from TrustedProxies, which must not be empty. NewWSHandler panics with it, as the
+	// connections are accepted by the http.Server of the caller
+	ProxyProtocol bool
+
+	// the timeout to read the PROXY protocol header. default 5s
+	ProxyHeaderTimeout time.Duration
+
+	// breaks the connections from TrustedProxies without the PROXY protocol header. Otherwise
+	// the header is optional, and it is waited up to ProxyHeaderTimeout before the first
+	// read of the protocols the server speaks first
+	ProxyHeaderRequired bool
+
+	// the IPs or CIDRs of the proxies, such as "10.0.0.0/8". X-Forwarded-For and
+	// X-Real-IP are honored only if the request is from them.
+	TrustedProxies []string
+}
+
+// WithCheckOrigin sets the check of the origin of request.
+func WithCheckOrigin(checkOrigin func(r *http.Request) bool) WSOption {
+	return func(opt *WSOptions) {
+		opt.CheckOrigin = checkOrigin
+	}
+}
+
+// WithAllowedOrigins allows the requests from the origins only.
+func WithAllowedOrigins(origins ...string) WSOption {
+	return func(opt *WSOptions) {
+		opt.AllowedOrigins = origins
+	}
+}
+
+// WithSubprotocols sets the subprotocols supported by the server.
+func WithSubprotocols(protocols ...string) WSOption {
+	return func(opt *WSOptions) {
+		opt.Subprotocols = protocols
+	}
+}
+
+// WithCompression sets whether the per-message deflate is negotiated.
+func WithCompression(enabled bool) WSOption {
+	return func(opt *WSOptions) {
+		opt.EnableCompression = enabled
+	}
+}
+
+// WithBufferSize sets the sizes of the I/O buffers.
+func WithBufferSize(readBufferSize, writeBufferSize int) WSOption {
+	return func(opt *WSOptions) {
+		opt.ReadBufferSize = readBufferSize
+		opt.WriteBufferSize = writeBufferSize
+	}
+}
+
+// WithAuthenticate sets the authentication before the upgrade.
+func WithAuthenticate(authenticate func(r *http.Request) (status int, err error)) WSOption {
+	return func(opt *WSOptions) {
+		opt.Authenticate = authenticate
+	}
+}
+
+// WithHTTPTimeout sets the timeouts of reading the request headers and the idle keep-alive connections of WSAcceptor.
+func WithHTTPTimeout(readHeaderTimeout, idleTimeout time.Duration) WSOption {
+	return func(opt *WSOptions) {
+		opt.ReadHeaderTimeout = readHeaderTimeout
+		opt.IdleTimeout = idleTimeout
+	}
+}
+
+// WithMaxHeaderBytes sets the max size of the request headers of WSAcceptor.
+func WithMaxHeaderBytes(n int) WSOption {
+	return func(opt *WSOptions) {
+		opt.MaxHeaderBytes = n
+	}
+}
+
+// WithWSProxyProtocol makes WSAcceptor parse the PROXY protocol headers from the
+// trusted proxies, so the RemoteAddr of the connections is the client behind a load balancer.
+// The trusted proxies are the ones of WithTrustedProxies if they are not given.
+func WithWSProxyProtocol(headerTimeout time.Duration, trustedProxies ...string) WSOption {
+	return func(opt *WSOptions) {
+		opt.ProxyProtocol = true
+		opt.ProxyHeaderTimeout = headerTimeout
+		if len(trustedProxies) > 0 {
+			opt.TrustedProxies = trustedProxies
+		}
+	}
+}
+
+// WithWSProxyHeaderRequired breaks the connections from the trusted proxies without the PROXY protocol header.
+func WithWSProxyHeaderRequired() WSOption {
+	return func(opt *WSOptions) {
+		opt.ProxyHeaderRequired = true
+	}
+}
+
+// WithTrustedProxies sets the proxies trusted to send the PROXY protocol headers,
+// X-Forwarded-For and X-Real-IP.
+func WithTrustedProxies(proxies ...string) WSOption {
+	return func(opt *WSOptions) {
+		opt.TrustedProxies = proxies
+	}
+}
+
+type TCPOption func(opt *TCPOptions)
+
+// loadTCPOptions returns an initialized *TCPOptions with options
+func loadTCPOptions(options ...TCPOption) *TCPOptions {
+	opts := new(TCPOptions)
+	for _, option := range options {
+		option(opts)
+	}
+	return opts
+}
+
+// TCPOptions contains the options of TCPAcceptor.
+type TCPOptions struct {
+	// parses the PROXY protocol v1 and v2 headers of the connections
+	// from TrustedProxies, which must not be empty
+	ProxyProtocol bool
+
+	// the timeout to read the PROXY protocol header. default 5s
+	ProxyHeaderTimeout time.Duration
+
+	// the IPs or CIDRs of the proxies, such as "10.0.0.0/8".
+	TrustedProxies []string
+
+	// breaks the connections from TrustedProxies without the PROXY protocol header. Otherwise
+	// the header is optional, and it is waited up to ProxyHeaderTimeout before the first
+	// read of the protocols the server speaks first
+	ProxyHeaderRequired bool
+}
+
+// WithProxyProtocol makes TCPAcceptor parse the PROXY protocol headers from the
+// trusted proxies, so the RemoteAddr of the connections is the client behind a load balancer.
+func WithProxyProtocol(headerTimeout time.Duration, trustedProxies ...string) TCPOption {
+	return func(opt *TCPOptions) {
+		opt.ProxyProtocol = true
+		opt.ProxyHeaderTimeout = headerTimeout
+		opt.TrustedProxies = trustedProxies
+	}
+}
+
+// WithProxyHeaderRequired breaks the connections from the trusted proxies without the PROXY protocol header.
+func WithProxyHeaderRequired() TCPOption {
+	return func(opt *TCPOptions) {
+		opt.ProxyHeaderRequired = true
+	}
+}
+
+type UnixOption func(opt *UnixOptions)
+
+// loadUnixOptions returns an initialized *UnixOptions with options
+func loadUnixOptions(options ...UnixOption) *UnixOptions {
+	opts := new(UnixOptions)
+	for _, option := range options {
+		option(opts)
+	}
+	return opts
+}
+
+// UnixOptions contains the options of UnixAcceptor.
+type UnixOptions struct {
+	// the permission of the socket file. default is set by umask
+	Mode os.FileMode
+}
+
+// WithUnixMode sets the permission of the socket file, such as 0660 for the processes of the group.
+func WithUnixMode(mode os.FileMode) UnixOption {
+	return func(opt *UnixOptions) {
+		opt.Mode = mode
+	}
+}
+
+type UDPOption func(opt *UDPOptions)
+
+// loadUDPOptions returns an initialized *UDPOptions with options
+func loadUDPOptions(options ...UDPOption) *UDPOptions {
+	opts := new(UDPOptions)
+	for _, option := range options {
+		option(opts)
+	}
+	if opts.MTU <= udpHeaderSize {
+		opts.MTU = 1400
+	}
+	if opts.Window <= 0 {
+		opts.Window = 128
+	} else if opts.Window > 0xffff {
+		opts.Window = 0xffff
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = 10 * time.Millisecond
+	}
+	if opts.KeepAlive <= 0 {
+		opts.KeepAlive = time.Second
+	}
+	if opts.DeadLinkTimeout <= 0 {
+		opts.DeadLinkTimeout = 10 * time.Second
+	}
+	if opts.Clock == nil {
+		opts.Clock = SystemClock
+	}
+	if opts.MaxConns == 0 {
+		opts.MaxConns = 1024
+	}
+	return opts
+}
+
+// UDPOptions contains the options of UDPAcceptor and DialUDP.
+type UDPOptions struct {
+	// the max size of the packets. default 1400
+	MTU int
+
+	// the max number of the segments in flight, and received out of order. default 128
+	Window int
+
+	// the interval to send the acks and resend the segments lost. default 10ms
+	Interval time.Duration
+
+	// a packet is sent if nothing is sent in KeepAlive, it must be less than
+	// the DeadLinkTimeout of the peer. default 1s
+	KeepAlive time.Duration
+
+	// the connection is broken if nothing is received in DeadLinkTimeout,
+	// and it is the timeout of DialUDP by default. default 10s
+	DeadLinkTimeout time.Duration
+
+	// the clock of the flushes, the keepalive and the dead link. default SystemClock
+	Clock Clock
+
+	// the max number of the connections of UDPAcceptor, DialUDP is refused at the limit.
+	// A connection is made by a SYN from any address, so the spoofed SYNs take up to
+	// MaxConns connections until their DeadLinkTimeout. default 1024, no limit if it is negative
+	MaxConns int
+
+	// the rate of the packets dropped before they are sent, to simulate the loss in tests.
+	loss float64
+}
+
+// WithUDPMTU sets the max size of the packets.
+func WithUDPMTU(mtu int) UDPOption {
+	return func(opt *UDPOptions) {
+		opt.MTU = mtu
+	}
+}
+
+// WithUDPWindow sets the max number of the segments in flight.
+func WithUDPWindow(window int) UDPOption {
+	return func(opt *UDPOptions) {
+		opt.Window = window
+	}
+}
+
+// WithUDPInterval sets the interval to send the acks and resend the segments lost.
+func WithUDPInterval(interval time.Duration) UDPOption {
+	return func(opt *UDPOptions) {
+		opt.Interval = interval
+	}
+}
+
+// WithUDPKeepAlive sets the interval of the keepalive and the timeout of the dead link.
+func WithUDPKeepAlive(keepAlive, deadLinkTimeout time.Duration) UDPOption {
+	return func(opt *UDPOptions) {
+		opt.KeepAlive = keepAlive
+		opt.DeadLinkTimeout = deadLinkTimeout
+	}
+}
+
+// WithUDPMaxConns sets the max number of the connections of UDPAcceptor, no limit if n is negative.
+func WithUDPMaxConns(n int) UDPOption {
+	return func(opt *UDPOptions) {
+		opt.MaxConns = n
+	}
+}
+
+// WithUDPClock sets the clock of the flushes, the keepalive and the dead link.
+func WithUDPClock(clock Clock) UDPOption {
+	return func(opt *UDPOptions) {
+		opt.Clock = clock
+	}
+}
+
+type MuxOption func(opt *MuxOptions)
+
+// loadMuxOptions returns an initialized *MuxOptions with options
+func loadMuxOptions(options ...MuxOption) *MuxOptions {
+	opts := new(MuxOptions)
+	for _, option := range options {
+		option(opts)
+	}
+	if opts.StreamWindow < muxInitialWindow {
+		opts.StreamWindow = muxInitialWindow
+	}
+	if opts.AcceptBacklog <= 0 {
+		opts.AcceptBacklog = 256
+	}
+	return opts
+}
+
+// MuxOptions contains the options of Mux.
+type MuxOptions struct {
+	// the bytes of a stream can be received and not read, the writer of the peer
+	// is blocked if it is full. It is not less than 256KB. default 256KB
+	StreamWindow uint32
+
+	// the max number of the streams opened by the peer and not accepted,
+	// the streams over it are reset. default 256
+	AcceptBacklog int
+}
+
+// WithMuxStreamWindow sets the receive window of the streams.
+func WithMuxStreamWindow(window uint32) MuxOption {
+	return func(opt *MuxOptions) {
+		opt.StreamWindow = window
+	}
+}
+
+// WithMuxAcceptBacklog sets the max number of the streams not accepted.
+func WithMuxAcceptBacklog(backlog int) MuxOption {
+	return func(opt *MuxOptions) {
+		opt.AcceptBacklog = backlog
+	}
+}
@@ -0,0 +1,319 @@
+package dnet
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	proxyV1Prefix    = []byte("PROXY ")
+	proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+	errProxyNoTrusted      = errors.New("dnet: PROXY protocol needs the trusted proxies. ")
+	errProxyHeaderRequired = errors.New("dnet: PROXY protocol header is required. ")
+)
+
+const (
+	proxyV1MaxLength = 107 // the max length of a v1 header with CRLF
+	proxyV2HeaderLen = 16  // signature(12) ver_cmd(1) fam(1) len(2)
+)
+
+// trustedProxies is the list of the addresses of the proxies.
+type trustedProxies []*net.IPNet
+
+// parseTrustedProxies parses the IPs or CIDRs, such as "10.0.0.1" and "10.0.0.0/8".
+func parseTrustedProxies(proxies []string) (trustedProxies, error) {
+	nets := make(trustedProxies, 0, len(proxies))
+	for _, p := range proxies {
+		if _, ipNet, err := net.ParseCIDR(p); err == nil {
+			nets = append(nets, ipNet)
+			continue
+		}
+		ip := net.ParseIP(p)
+		if ip == nil {
+			return nil, fmt.Errorf("dnet: trusted proxy %s is invalid. ", p)
+		}
+		bits := 8 * net.IPv4len
+		if ip.To4() == nil {
+			bits = 8 * net.IPv6len
+		}
+		nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+	}
+	return nets, nil
+}
+
+func (nets trustedProxies) contains(ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// addrIP returns the IP of the TCP address, nil for the others.
+func addrIP(addr net.Addr) net.IP {
+	if tcpAddr, ok := addr.(*net.TCPAddr); ok {
+		return tcpAddr.IP
+	}
+	if host, _, err := net.SplitHostPort(addr.String()); err == nil {
+		return net.ParseIP(host)
+	}
+	return nil
+}
+
+// proxyListener parses the PROXY protocol headers of the connections accepted.
+type proxyListener struct {
+	net.Listener
+	headerTimeout  time.Duration
+	headerRequired bool
+	trusted        trustedProxies
+}
+
+// newProxyListener returns the listener of the PROXY protocol, the trusted proxies must not be empty.
+func newProxyListener(listener net.Listener, headerTimeout time.Duration, proxies []string, headerRequired bool) (*proxyListener, error) {
+	if len(proxies) == 0 {
+		return nil, errProxyNoTrusted
+	}
+	trusted, err := parseTrustedProxies(proxies)
+	if err != nil {
+		return nil, err
+	}
+	if headerTimeout <= 0 {
+		headerTimeout = 5 * time.Second
+	}
+	return &proxyListener{Listener: listener, headerTimeout: headerTimeout, headerRequired: headerRequired, trusted: trusted}, nil
+}
+
+// Accept returns the connection without reading, the header is read on the first
+// Read or RemoteAddr, so a slow client does not block the accept loop.
+// The connections not from the trusted proxies are returned as they are.
+func (l *proxyListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if !l.trusted.contains(addrIP(conn.RemoteAddr())) {
+		return conn, nil
+	}
+	return &proxyConn{Conn: conn, headerTimeout: l.headerTimeout, headerRequired: l.headerRequired}, nil
+}
+
+// proxyConn is a connection from a proxy, its RemoteAddr is the address of the client
+// in the PROXY protocol header. It is the address of the proxy if there is no header,
+// or the connection is broken if the header is required. The header is waited up to
+// the header timeout if the client sends nothing, so the optional header suits the
+// protocols the client speaks first.
+type proxyConn struct {
+	net.Conn
+	headerTimeout  time.Duration
+	headerRequired bool
+
+	once         sync.Once
+	remoteAddr   net.Addr
+	rest         []byte // the data read after the header
+	err          error
+	mtx          sync.Mutex
+	readDeadline time.Time // set before the header is read
+}
+
+func (c *proxyConn) readHeader() {
+	c.once.Do(func() {
+		_ = c.Conn.SetReadDeadline(time.Now().Add(c.headerTimeout))
+		r := bufio.NewReaderSize(c.Conn, 256)
+		if c.remoteAddr, c.err = readProxyHeader(r, c.headerRequired); c.err != nil {
+			return
+		}
+		if n := r.Buffered(); n > 0 {
+			c.rest, _ = r.Peek(n)
+		}
+
+		c.mtx.Lock()
+		defer c.mtx.Unlock()
+		if err := c.Conn.SetReadDeadline(c.readDeadline); err != nil {
+			c.err = err
+		}
+	})
+}
+
+func (c *proxyConn) Read(b []byte) (int, error) {
+	c.readHeader()
+	if c.err != nil {
+		return 0, c.err
+	}
+	if len(c.rest) > 0 {
+		n := copy(b, c.rest)
+		c.rest = c.rest[n:]
+		return n, nil
+	}
+	return c.Conn.Read(b)
+}
+
+// RemoteAddr returns the address of the client, it waits for the header.
+func (c *proxyConn) RemoteAddr() net.Addr {
+	c.readHeader()
+	if c.remoteAddr != nil {
+		return c.remoteAddr
+	}
+	return c.Conn.RemoteAddr()
+}
+
+func (c *proxyConn) SetDeadline(t time.Time) error {
+	if err := c.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return c.Conn.SetWriteDeadline(t)
+}
+
+// SetReadDeadline sets the read deadline, which takes effect after the header is read.
+func (c *proxyConn) SetReadDeadline(t time.Time) error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.readDeadline = t
+	return c.Conn.SetReadDeadline(t)
+}
+
+// readProxyHeader reads the PROXY protocol v1 or v2 header. It returns the nil address
+// if the header is sent by the proxy itself, such as a health check, or there is no
+// header and it is not required. If it is not required, nothing received in the
+// timeout is taken as no header, as the protocols the server speaks first.
+func readProxyHeader(r *bufio.Reader, required bool) (net.Addr, error) {
+	b, err := r.Peek(1)
+	if err != nil {
+		if ne, ok := err.(net.Error); ok && ne.Timeout() && !required {
+			return nil, nil
+		}
+		return nil, err
+	}
+	switch b[0] {
+	case proxyV1Prefix[0]:
+		if b, err := r.Peek(len(proxyV1Prefix)); err == nil && bytes.Equal(b, proxyV1Prefix) {
+			return readProxyV1(r)
+		}
+	case proxyV2Signature[0]:
+		if b, err := r.Peek(len(proxyV2Signature)); err == nil && bytes.Equal(b, proxyV2Signature) {
+			return readProxyV2(r)
+		}
+	}
+	if required {
+		return nil, errProxyHeaderRequired
+	}
+	return nil, nil
+}
+
+// readProxyV1 reads the header "PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\r\n".
+func readProxyV1(r *bufio.Reader) (net.Addr, error) {
+	line, err := r.ReadSlice('\n')
+	if err != nil {
+		if err == bufio.ErrBufferFull {
+			return nil, errors.New("dnet: PROXY protocol v1 header is too long. ")
+		}
+		return nil, err
+	}
+	if len(line) > proxyV1MaxLength || !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, errors.New("dnet: PROXY protocol v1 header is invalid. ")
+	}
+
+	fields := strings.Split(string(line[:len(line)-2]), " ")
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, fmt.Errorf("dnet: PROXY protocol v1 header %q is invalid. ", line)
+	}
+	ip := net.ParseIP(fields[2])
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if ip == nil || err != nil || (ip.To4() != nil) != (fields[1] == "TCP4") {
+		return nil, fmt.Errorf("dnet: PROXY protocol v1 header %q is invalid. ", line)
+	}
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+// readProxyV2 reads the binary header.
+func readProxyV2(r *bufio.Reader) (net.Addr, error) {
+	header := make([]byte, proxyV2HeaderLen)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	verCmd, fam := header[12], header[13]
+	length := int(binary.BigEndian.Uint16(header[14:]))
+	if verCmd>>4 != 2 {
+		return nil, fmt.Errorf("dnet: PROXY protocol v2 version %d is invalid. ", verCmd>>4)
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+
+	switch verCmd & 0xf {
+	case 0: // LOCAL
+		return nil, nil
+	case 1: // PROXY
+	default:
+		return nil, fmt.Errorf("dnet: PROXY protocol v2 command %d is invalid. ", verCmd&0xf)
+	}
+
+	var ipLen int
+	switch fam >> 4 {
+	case 1: // AF_INET
+		ipLen = net.IPv4len
+	case 2: // AF_INET6
+		ipLen = net.IPv6len
+	default:
+		// AF_UNSPEC and AF_UNIX
+		return nil, nil
+	}
+	// src_addr dst_addr src_port dst_port, followed by the TLVs
+	if length < 2*ipLen+4 {
+		return nil, errors.New("dnet: PROXY protocol v2 address is too short. ")
+	}
+	ip := make(net.IP, ipLen)
+	copy(ip, payload)
+	port := binary.BigEndian.Uint16(payload[2*ipLen:])
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+// forwardedFor returns the address of the client in X-Forwarded-For, by skipping the
+// trusted proxies from right to left. It returns nil if the remote address is not trusted.
+func forwardedFor(remoteAddr net.Addr, xff string, trusted trustedProxies) net.Addr {
+	if xff == "" || !trusted.contains(addrIP(remoteAddr)) {
+		return nil
+	}
+	var client net.IP
+	ips := strings.Split(xff, ",")
+	for i := len(ips) - 1; i >= 0; i-- {
+		ip := net.ParseIP(strings.TrimSpace(ips[i]))
+		if ip == nil {
+			break
+		}
+		client = ip
+		if !trusted.contains(ip) {
+			break
+		}
+	}
+	if client == nil {
+		return nil
+	}
+	return &net.TCPAddr{IP: client}
+}
+
+// realIP returns the address of the client in X-Real-IP, or the remote address
+// if it is not trusted or X-Real-IP is not an IP.
+func realIP(remoteAddr net.Addr, xri string, trusted trustedProxies) net.Addr {
+	if !trusted.contains(addrIP(remoteAddr)) {
+		return remoteAddr
+	}
+	if ip := net.ParseIP(strings.TrimSpace(xri)); ip != nil {
+		return &net.TCPAddr{IP: ip}
+	}
+	return remoteAddr
+}
@@ -0,0 +1,378 @@
+package dnet
+
+import (
+	"bufio"
+	"encoding/binary"
+	"github.com/gorilla/websocket"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// proxyV2Header returns a v2 header of the TCP over IPv4 from src to dst.
+func proxyV2Header(cmd byte, src, dst *net.TCPAddr, tlv []byte) []byte {
+	b := append([]byte{}, proxyV2Signature...)
+	b = append(b, 0x20|cmd, 0x11, 0, 0)
+	binary.BigEndian.PutUint16(b[14:], uint16(12+len(tlv)))
+	b = append(b, src.IP.To4()...)
+	b = append(b, dst.IP.To4()...)
+	b = append(b, byte(src.Port>>8), byte(src.Port), byte(dst.Port>>8), byte(dst.Port))
+	return append(b, tlv...)
+}
+
+func TestReadProxyHeader(t *testing.T) {
+	src := &net.TCPAddr{IP: net.ParseIP("192.168.0.1").To4(), Port: 56324}
+	dst := &net.TCPAddr{IP: net.ParseIP("10.0.0.1").To4(), Port: 443}
+	for _, test := range []struct {
+		name   string
+		header string
+		addr   string // empty if there is no address
+		err    bool
+	}{
+		{"v1 tcp4", "PROXY TCP4 192.168.0.1 10.0.0.1 56324 443\r\n", "192.168.0.1:56324", false},
+		{"v1 tcp6", "PROXY TCP6 2001:db8::1 2001:db8::2 56324 443\r\n", "[2001:db8::1]:56324", false},
+		{"v1 unknown", "PROXY UNKNOWN\r\n", "", false},
+		{"v1 family", "PROXY TCP4 2001:db8::1 10.0.0.1 56324 443\r\n", "", true},
+		{"v1 port", "PROXY TCP4 192.168.0.1 10.0.0.1 65536 443\r\n", "", true},
+		{"v1 too long", "PROXY TCP4 " + strings.Repeat("1", 200) + "\r\n", "", true},
+		{"v2 proxy", string(proxyV2Header(1, src, dst, nil)), "192.168.0.1:56324", false},
+		{"v2 tlv", string(proxyV2Header(1, src, dst, []byte{0x04, 0, 1, 0})), "192.168.0.1:56324", false},
+		{"v2 local", string(proxyV2Header(0, src, dst, nil)), "", false},
+		{"none", "GET / HTTP/1.1\r\n", "", false},
+		{"not proxy", "POST / HTTP/1.1\r\n", "", false},
+	} {
+		r := bufio.NewReaderSize(strings.NewReader(test.header+"data"), 256)
+		addr, err := readProxyHeader(r, false)
+		if test.err {
+			if err == nil {
+				t.Errorf("%s: no error", test.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if got := ""; addr != nil {
+			got = addr.String()
+			if got != test.addr {
+				t.Errorf("%s: addr %s, want %s", test.name, got, test.addr)
+			}
+		} else if test.addr != "" {
+			t.Errorf("%s: no addr, want %s", test.name, test.addr)
+		}
+
+		// the data after the header is not read
+		rest, _ := io.ReadAll(r)
+		if addr != nil || strings.HasPrefix(test.header, "PROXY UNKNOWN") || strings.Contains(test.name, "v2") {
+			if string(rest) != "data" {
+				t.Errorf("%s: rest %q", test.name, rest)
+			}
+		} else if string(rest) != test.header+"data" {
+			t.Errorf("%s: rest %q", test.name, rest)
+		}
+	}
+}
+
+// proxyAccept listens with the PROXY protocol, and returns the first connection accepted
+// after the header is written by a client.
+func proxyAccept(t *testing.T, header string, required bool, proxies ...string) net.Conn {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := newProxyListener(listener, time.Second, proxies, required)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	client, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	if _, err := client.Write([]byte(header + "hello")); err != nil {
+		t.Fatal(err)
+	}
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestProxyListener(t *testing.T) {
+	header := "PROXY TCP4 192.168.0.1 10.0.0.1 56324 443\r\n"
+
+	conn := proxyAccept(t, header, false, "127.0.0.0/8")
+	if addr := conn.RemoteAddr().String(); addr != "192.168.0.1:56324" {
+		t.Fatalf("remote addr %s", addr)
+	}
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "hello" {
+		t.Fatalf("read %q %v", buf, err)
+	}
+
+	// the header from the untrusted sources is not parsed
+	conn = proxyAccept(t, header, false, "10.0.0.0/8")
+	if ip := addrIP(conn.RemoteAddr()); !ip.IsLoopback() {
+		t.Fatalf("remote addr %s", conn.RemoteAddr())
+	}
+	buf = make([]byte, len(header))
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != header {
+		t.Fatalf("read %q %v", buf, err)
+	}
+
+	// a connection without header is kept unless the header is required
+	conn = proxyAccept(t, "", false, "127.0.0.1")
+	if ip := addrIP(conn.RemoteAddr()); !ip.IsLoopback() {
+		t.Fatalf("remote addr %s", conn.RemoteAddr())
+	}
+	buf = make([]byte, 5)
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "hello" {
+		t.Fatalf("read %q %v", buf, err)
+	}
+
+	conn = proxyAccept(t, "", true, "127.0.0.1")
+	if _, err := conn.Read(buf); err != errProxyHeaderRequired {
+		t.Fatalf("read without the required header: %v", err)
+	}
+	// the header of the proxy itself is kept
+	conn = proxyAccept(t, "PROXY UNKNOWN\r\n", true, "127.0.0.1")
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "hello" {
+		t.Fatalf("read %q %v", buf, err)
+	}
+	// the header is not required from the untrusted sources
+	conn = proxyAccept(t, "", true, "10.0.0.0/8")
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "hello" {
+		t.Fatalf("read %q %v", buf, err)
+	}
+
+	// the invalid header breaks the connection
+	conn = proxyAccept(t, "PROXY TCP4 bad\r\n", false, "127.0.0.1")
+	if _, err := conn.Read(buf); err == nil {
+		t.Fatal("read the invalid header")
+	}
+
+	if _, err := newProxyListener(nil, 0, []string{"bad"}, false); err == nil {
+		t.Fatal("invalid trusted proxy")
+	}
+	if _, err := newProxyListener(nil, 0, nil, false); err != errProxyNoTrusted {
+		t.Fatalf("no trusted proxy: %v", err)
+	}
+	if err := NewTCPAcceptor("127.0.0.1:0", WithProxyProtocol(time.Second)).ServeFunc(func(conn net.Conn) {}); err != errProxyNoTrusted {
+		t.Fatalf("serve without the trusted proxies: %v", err)
+	}
+}
+
+func TestProxyHeaderTimeout(t *testing.T) {
+	for _, required := range []bool{false, true} {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		l, _ := newProxyListener(listener, 100*time.Millisecond, []string{"127.0.0.1"}, required)
+		defer l.Close()
+
+		client, err := net.Dial("tcp", listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+		conn, _ := l.Accept()
+		defer conn.Close()
+
+		// the header is not sent, and the server speaks first
+		_ = conn.SetReadDeadline(time.Now().Add(time.Minute))
+		done := make(chan error, 1)
+		buf := make([]byte, 5)
+		go func() {
+			_, err := io.ReadFull(conn, buf)
+			done <- err
+		}()
+		if _, err := conn.Write([]byte("ready")); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.ReadFull(client, make([]byte, 5)); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(200 * time.Millisecond)
+		_, _ = client.Write([]byte("hello"))
+
+		select {
+		case err := <-done:
+			if required && !isTimeout(err) {
+				t.Fatalf("read %v, the header is required", err)
+			}
+			if !required && (err != nil || string(buf) != "hello") {
+				t.Fatalf("read %q %v, the header is optional", buf, err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("header timeout")
+		}
+		if ip := addrIP(conn.RemoteAddr()); !ip.IsLoopback() {
+			t.Fatalf("remote addr %s", conn.RemoteAddr())
+		}
+	}
+}
+
+func TestForwardedFor(t *testing.T) {
+	trusted, _ := parseTrustedProxies([]string{"10.0.0.0/8", "192.168.0.1"})
+	proxy := &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 1234}
+	for _, test := range []struct {
+		remote *net.TCPAddr
+		xff    string
+		client string // empty if it is not resolved
+	}{
+		{proxy, "1.1.1.1", "1.1.1.1"},
+		{proxy, "2.2.2.2, 1.1.1.1, 192.168.0.1", "1.1.1.1"},
+		{proxy, "10.0.0.2, 10.0.0.3", "10.0.0.2"},
+		{proxy, "bad, 1.1.1.1", "1.1.1.1"},
+		{proxy, "", ""},
+		{&net.TCPAddr{IP: net.ParseIP("1.1.1.1"), Port: 1234}, "2.2.2.2", ""},
+	} {
+		addr := forwardedFor(test.remote, test.xff, trusted)
+		if test.client == "" {
+			if addr != nil {
+				t.Errorf("%s %q: %s", test.remote, test.xff, addr)
+			}
+			continue
+		}
+		if addr == nil || addrIP(addr).String() != test.client {
+			t.Errorf("%s %q: %v, want %s", test.remote, test.xff, addr, test.client)
+		}
+	}
+}
+
+func TestWSTrustedProxies(t *testing.T) {
+	connCh := make(chan *WSConn, 1)
+	server := httptest.NewServer(NewWSHandler(AcceptorHandlerFunc(func(conn net.Conn) {
+		connCh <- conn.(*WSConn)
+	}), WithTrustedProxies("127.0.0.1")))
+	defer server.Close()
+	ws := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	header := http.Header{"X-Forwarded-For": {"1.1.1.1, 2.2.2.2"}}
+	c, _, err := websocket.DefaultDialer.Dial(ws, header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	conn := <-connCh
+	if ip := addrIP(conn.RemoteAddr()).String(); ip != "2.2.2.2" {
+		t.Fatalf("remote addr %s", conn.RemoteAddr())
+	}
+	if ip := conn.ClientIP(); ip != "2.2.2.2" {
+		t.Fatalf("client ip %s", ip)
+	}
+
+	// X-Forwarded-For is ignored from the others
+	server2 := httptest.NewServer(NewWSHandler(AcceptorHandlerFunc(func(conn net.Conn) {
+		connCh <- conn.(*WSConn)
+	}), WithTrustedProxies("10.0.0.0/8")))
+	defer server2.Close()
+	c, _, err = websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server2.URL, "http"), header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	conn = <-connCh
+	if ip := conn.ClientIP(); ip != "127.0.0.1" {
+		t.Fatalf("client ip %s", ip)
+	}
+
+	// X-Real-IP is honored from the trusted proxies
+	c, _, err = websocket.DefaultDialer.Dial(ws, http.Header{"X-Real-IP": {"3.3.3.3"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	conn = <-connCh
+	if ip := conn.ClientIP(); ip != "3.3.3.3" {
+		t.Fatalf("client ip %s", ip)
+	}
+}
+
+// the headers are ignored without the trusted proxies
+func TestWSClientIP(t *testing.T) {
+	connCh := make(chan *WSConn, 1)
+	server := httptest.NewServer(NewWSHandler(AcceptorHandlerFunc(func(conn net.Conn) {
+		connCh <- conn.(*WSConn)
+	})))
+	defer server.Close()
+
+	header := http.Header{"X-Forwarded-For": {"1.1.1.1"}, "X-Real-IP": {"2.2.2.2"}}
+	c, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	conn := <-connCh
+	if ip := conn.ClientIP(); ip != "127.0.0.1" {
+		t.Fatalf("client ip %s", ip)
+	}
+	if addr := conn.RemoteAddr().String(); addr != c.LocalAddr().String() {
+		t.Fatalf("remote addr %s, want %s", addr, c.LocalAddr())
+	}
+}
+
+func TestWSAcceptorProxyProtocol(t *testing.T) {
+	acceptor := NewWSAcceptor("127.0.0.1:0", WithWSProxyProtocol(time.Second, "127.0.0.1"))
+	connCh := make(chan net.Conn, 1)
+	go acceptor.ServeFunc(func(conn net.Conn) {
+		connCh <- conn
+	})
+	defer acceptor.Stop()
+	deadline := time.Now().Add(time.Second)
+	for acceptor.Addr() == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("acceptor is not started")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// the load balancer sends the header of the client 192.168.0.1, which forges X-Forwarded-For
+	dialer := &websocket.Dialer{
+		NetDial: func(network, addr string) (net.Conn, error) {
+			conn, err := net.Dial(network, addr)
+			if err != nil {
+				return nil, err
+			}
+			_, err = conn.Write([]byte("PROXY TCP4 192.168.0.1 10.0.0.1 56324 443\r\n"))
+			return conn, err
+		},
+	}
+	c, _, err := dialer.Dial("ws://"+acceptor.Addr().String(), http.Header{"X-Forwarded-For": {"1.1.1.1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	conn := <-connCh
+	if addr := conn.RemoteAddr().String(); addr != "192.168.0.1:56324" {
+		t.Fatalf("remote addr %s", addr)
+	}
+
+	if err := NewWSAcceptor("127.0.0.1:0", WithTrustedProxies("bad")).ServeFunc(func(conn net.Conn) {}); err == nil {
+		t.Fatal("invalid trusted proxy")
+	}
+	if err := NewWSAcceptor("127.0.0.1:0", WithWSProxyProtocol(time.Second)).ServeFunc(func(conn net.Conn) {}); err != errProxyNoTrusted {
+		t.Fatalf("no trusted proxy: %v", err)
+	}
+	// the trusted proxies of X-Forwarded-For are used by default
+	if opts := loadWSOptions(WithTrustedProxies("10.0.0.0/8"), WithWSProxyProtocol(time.Second)); len(opts.TrustedProxies) != 1 {
+		t.Fatalf("trusted proxies %v", opts.TrustedProxies)
+	}
+
+	defer func() {
+		if r := recover(); r == nil {
+			t.Fatal("NewWSHandler with the PROXY protocol")
+		}
+	}()
+	NewWSHandler(AcceptorHandlerFunc(func(conn net.Conn) {}), WithWSProxyProtocol(time.Second, "127.0.0.1"))
+}
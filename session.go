@@ -20,7 +20,8 @@ type session struct {
 	sendNotifyCh  chan struct{}    // 发送消息通知
 	sendMessageCh chan interface{} // 发送队列
 
-	waitGroup sync.WaitGroup
+	waitGroup sync.WaitGroup // 发送线程
+	readDone  chan struct{}  // 接收线程退出
 	closed    int32
 	chClose   chan struct{}
 }
@@ -29,17 +30,22 @@ func newSession(conn net.Conn, options *Options) *session {
 	if options.SendChannelSize <= 0 {
 		options.SendChannelSize = defSendChannelSize
 	}
+	if options.Clock == nil {
+		options.Clock = SystemClock
+	}
 
 	session := &session{
 		conn:         conn,
 		opts:         options,
 		sendNotifyCh: make(chan struct{}, 1),
 		chClose:      make(chan struct{}),
+		readDone:     make(chan struct{}),
 	}
 
 	if options.MsgCallback != nil {
-		session.waitGroup.Add(1)
 		go session.readThread()
+	} else {
+		close(session.readDone)
 	}
 
 	return session
@@ -85,15 +91,15 @@ func (this *session) RemoteAddr() net.Addr {
 
 // 接收线程
 func (this *session) readThread() {
-	defer this.waitGroup.Done()
+	defer close(this.readDone)
+
+	// the read is woken up by a deadline in the past when the timer expires
+	readTimer := &deadlineTimer{clock: this.opts.Clock, setDeadline: this.conn.SetReadDeadline}
+	defer readTimer.stop()
 
 	for {
 		if this.opts.ReadTimeout > 0 {
-			if err := this.conn.SetReadDeadline(time.Now().Add(this.opts.ReadTimeout)); err != nil {
-				if this.opts.ErrorCallback != nil {
-					this.opts.ErrorCallback(this, err)
-				}
-			}
+			readTimer.start(this.opts.ReadTimeout)
 		}
 
 		if msg, err := this.opts.Codec.Decode(this.conn); this.IsClosed() {
@@ -127,48 +133,26 @@ func (this *session) readThread() {
 func (this *session) writeThread() {
 	defer this.waitGroup.Done()
 
+	// the write is woken up by a deadline in the past when the timer expires
+	writeTimer := &deadlineTimer{clock: this.opts.Clock, setDeadline: this.conn.SetWriteDeadline}
+	defer writeTimer.stop()
+
 	for {
 		select {
 		case msg := <-this.sendMessageCh:
-			if data, err := this.opts.Codec.Encode(msg); err != nil {
+			if err := this.write(msg, writeTimer); err != nil {
 				if !this.IsClosed() {
+					if ne, ok := err.(net.Error); ok {
+						if ne.Timeout() {
+							err = ErrSendTimeout
+						}
+					}
 					if this.opts.ErrorCallback != nil {
 						this.opts.ErrorCallback(this, err)
 					}
 					this.Close(err)
 				}
 				return
-			} else {
-				if data != nil && len(data) != 0 {
-					// 发送的消息
-					if this.opts.WriteTimeout > 0 {
-						if err := this.conn.SetWriteDeadline(time.Now().Add(this.opts.ReadTimeout)); err != nil {
-							if this.opts.ErrorCallback != nil {
-								this.opts.ErrorCallback(this, err)
-							}
-						}
-					}
-
-					idx, length := 0, len(data)
-					for idx < length {
-						if n, err := this.conn.Write(data[idx:length]); err != nil {
-							if !this.IsClosed() {
-								if ne, ok := err.(net.Error); ok {
-									if ne.Timeout() {
-										err = ErrSendTimeout
-									}
-								}
-								if this.opts.ErrorCallback != nil {
-									this.opts.ErrorCallback(this, err)
-								}
-								this.Close(err)
-							}
-							return
-						} else {
-							idx += n
-						}
-					}
-				}
 			}
 
 		default:
@@ -183,6 +167,107 @@ func (this *session) writeThread() {
 	}
 }
 
+// write encodes and writes msg. The frame of WSMessageEncoder is written by
+// WSConn.WriteMessage, the empty data of other codecs is not written.
+func (this *session) write(msg interface{}, writeTimer *deadlineTimer) error {
+	if encoder, ok := this.opts.Codec.(WSMessageEncoder); ok {
+		if conn, ok := this.conn.(*WSConn); ok {
+			wsMsg, err := encoder.EncodeWSMessage(msg)
+			if err != nil {
+				return err
+			}
+			this.startWriteTimer(writeTimer)
+			if err := conn.WriteMessage(wsMsg); err != nil {
+				return err
+			}
+			writeTimer.stop()
+			return nil
+		}
+	}
+
+	data, err := this.opts.Codec.Encode(msg)
+	if err != nil {
+		return err
+	}
+	if len(data) == 0 {
+		return nil
+	}
+	// 发送的消息
+	this.startWriteTimer(writeTimer)
+	idx, length := 0, len(data)
+	for idx < length {
+		n, err := this.conn.Write(data[idx:length])
+		if err != nil {
+			return err
+		}
+		idx += n
+	}
+	writeTimer.stop()
+	return nil
+}
+
+// startWriteTimer starts the timer of WriteTimeout.
+func (this *session) startWriteTimer(writeTimer *deadlineTimer) {
+	if this.opts.WriteTimeout > 0 {
+		writeTimer.start(this.opts.WriteTimeout)
+	}
+}
+
+// deadlineTimer wakes up the blocked read or write with a timeout error, by a deadline
+// in the past when it expires. The timer is reused until it expires, then a new one is
+// made, and the callback of the old one is dropped by the generation, so that it does
+// not expire the next operation after the deadline is cleared.
+type deadlineTimer struct {
+	clock       Clock
+	setDeadline func(t time.Time) error
+
+	timer   Timer
+	stopped bool // stopped before it expires
+
+	mtx sync.Mutex
+	gen uint64
+}
+
+// start starts the timer of an operation.
+func (t *deadlineTimer) start(timeout time.Duration) {
+	if t.timer != nil {
+		if t.stopped {
+			t.stopped = false
+			t.timer.Reset(timeout)
+			return
+		}
+		if t.timer.Reset(timeout) {
+			return
+		}
+		t.timer.Stop()
+	}
+
+	t.mtx.Lock()
+	t.gen++
+	gen := t.gen
+	if t.timer != nil {
+		// expired after the last operation
+		_ = t.setDeadline(time.Time{})
+	}
+	t.mtx.Unlock()
+	t.timer = t.clock.AfterFunc(timeout, func() { t.expire(gen) })
+}
+
+// stop stops the timer after the operation is done.
+func (t *deadlineTimer) stop() {
+	if t.timer != nil && t.timer.Stop() {
+		t.stopped = true
+	}
+}
+
+func (t *deadlineTimer) expire(gen uint64) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	if gen == t.gen {
+		_ = t.setDeadline(aLongTimeAgo)
+	}
+}
+
 func (this *session) Send(o interface{}) error {
 	if o == nil {
 		return ErrSendMsgNil
@@ -223,6 +308,11 @@ func (this *session) Close(reason error) {
 
 		go func() {
 			this.waitGroup.Wait()
+			if wsConn, ok := this.conn.(*WSConn); ok {
+				this.closeWS(wsConn, reason)
+			}
+			// 关闭连接唤醒阻塞的读，不等待接收线程退出，
+			// 它可能阻塞在消息回调中等待本会话的响应
 			_ = this.conn.Close()
 			if this.opts.CloseCallback != nil {
 				this.opts.CloseCallback(this, reason)
@@ -231,6 +321,30 @@ func (this *session) Close(reason error) {
 	}
 }
 
+// closeWS sends the close frame of reason after the messages are sent, and waits
+// for the close frame of the peer. The read is woken up by the reply or the timeout,
+// and it is not waited after the timeout if it is blocked by the message callback.
+func (this *session) closeWS(conn *WSConn, reason error) {
+	closeCode := this.opts.WSCloseCode
+	if closeCode == nil {
+		closeCode = DefaultWSCloseCode
+	}
+	code, text := closeCode(reason)
+	deadline := time.Now().Add(wsCloseTimeout)
+	if conn.writeClose(code, text, deadline) != nil {
+		_ = conn.SetReadDeadline(aLongTimeAgo)
+		return
+	}
+	_ = conn.SetReadDeadline(deadline)
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+	select {
+	case <-this.readDone:
+		conn.waitClose()
+	case <-timer.C:
+	}
+}
+
 // 作为通知用的 channel， make(chan struct{}, 1)
 func sendNotifyChan(ch chan struct{}) {
 	select {
@@ -11,13 +11,14 @@ import (
 
 type TCPAcceptor struct {
 	address  string
+	opts     *TCPOptions
 	listener net.Listener
 	started  int32
 }
 
 // NewTCPAcceptor returns a new instance of TCPAcceptor
-func NewTCPAcceptor(address string) *TCPAcceptor {
-	return &TCPAcceptor{address: address}
+func NewTCPAcceptor(address string, options ...TCPOption) *TCPAcceptor {
+	return &TCPAcceptor{address: address, opts: loadTCPOptions(options...)}
 }
 
 // ServeTCP listen and serve tcp address with AcceptorHandler
@@ -44,12 +45,26 @@ func (this *TCPAcceptor) Serve(handler AcceptorHandler) error {
 	if err != nil {
 		return err
 	}
+	if this.opts.ProxyProtocol {
+		l, err := newProxyListener(listener, this.opts.ProxyHeaderTimeout, this.opts.TrustedProxies, this.opts.ProxyHeaderRequired)
+		if err != nil {
+			_ = listener.Close()
+			return err
+		}
+		listener = l
+	}
 	this.listener = listener
 	defer this.Stop()
 
+	return serveListener(listener, handler)
+}
+
+// serveListener accepts the connections of listener, and calls handler in new goroutines.
+// It returns io.EOF after the listener is closed.
+func serveListener(listener net.Listener, handler AcceptorHandler) error {
 	var tempDelay time.Duration
 	for {
-		conn, err := this.listener.Accept()
+		conn, err := listener.Accept()
 		if err != nil {
 			if ne, ok := err.(net.Error); ok && ne.Temporary() {
 				if tempDelay == 0 {
@@ -71,7 +86,6 @@ func (this *TCPAcceptor) Serve(handler AcceptorHandler) error {
 
 		go handler.OnConnection(conn)
 	}
-
 }
 
 // ServeFunc listens and serve in the specified addr
@@ -1,8 +1,10 @@
 package dnet
 
 import (
+	"errors"
 	"fmt"
 	"net"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -152,3 +154,101 @@ func TestTCP(t *testing.T) {
 	time.Sleep(time.Second * 1)
 
 }
+
+// the session closed by its own side reaches the close callback while the peer
+// is silent, and no message is delivered after Close.
+func TestTCPSessionCloseSilentPeer(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+
+	closed := make(chan error, 1)
+	var afterClose int32
+	session := NewTCPSession(c1,
+		WithTimeout(time.Minute, 0),
+		WithMessageCallback(func(session Session, message interface{}) {
+			if session.IsClosed() {
+				atomic.AddInt32(&afterClose, 1)
+			}
+		}),
+		WithCloseCallback(func(session Session, reason error) {
+			closed <- reason
+		}))
+
+	reason := errors.New("closed by test")
+	session.Close(reason)
+	select {
+	case err := <-closed:
+		if err != reason {
+			t.Fatalf("closed by %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the close callback is not called")
+	}
+	if _, err := c2.Write([]byte{0, 0, 0, 1, 'a'}); err == nil {
+		t.Fatal("write to the closed session")
+	}
+	if n := atomic.LoadInt32(&afterClose); n != 0 {
+		t.Fatalf("%d messages after Close", n)
+	}
+}
+
+type manualTimer struct {
+	f      func()
+	active bool
+}
+
+func (t *manualTimer) Stop() bool {
+	active := t.active
+	t.active = false
+	return active
+}
+
+func (t *manualTimer) Reset(d time.Duration) bool {
+	active := t.active
+	t.active = true
+	return active
+}
+
+// manualClock holds the timers, which are expired by hand.
+type manualClock struct {
+	timers []*manualTimer
+}
+
+func (c *manualClock) Now() time.Time { return time.Now() }
+
+func (c *manualClock) AfterFunc(d time.Duration, f func()) Timer {
+	t := &manualTimer{f: f, active: true}
+	c.timers = append(c.timers, t)
+	return t
+}
+
+// the callback of a timer expired after the operation does not expire the next operation
+func TestDeadlineTimerExpiredLate(t *testing.T) {
+	clock := &manualClock{}
+	var deadline time.Time
+	timer := &deadlineTimer{clock: clock, setDeadline: func(t time.Time) error {
+		deadline = t
+		return nil
+	}}
+
+	timer.start(time.Second)
+	timer.stop()
+	timer.start(time.Second)
+	if len(clock.timers) != 1 {
+		t.Fatalf("%d timers, the stopped timer is not reused", len(clock.timers))
+	}
+
+	// expires after the operation, and calls back after the next operation starts
+	late := clock.timers[0]
+	late.active = false
+	timer.start(time.Second)
+	late.f()
+	if !deadline.IsZero() {
+		t.Fatalf("deadline %v is set by the late callback", deadline)
+	}
+
+	clock.timers[len(clock.timers)-1].f()
+	if deadline != aLongTimeAgo {
+		t.Fatalf("deadline %v, the timer does not expire", deadline)
+	}
+}
@@ -0,0 +1,296 @@
+package dnet
+
+import (
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// UDPAcceptor accepts the reliable connections over UDP, which are dialed by DialUDP.
+// The peers are multiplexed by address on one socket, and each connection is
+// an ordered stream with the ARQ reliability, so TCPSession works on it as on TCP.
+type UDPAcceptor struct {
+	address string
+	opts    *UDPOptions
+	started int32
+
+	mtx     sync.Mutex
+	conn    *net.UDPConn
+	conns   map[string]*udpConn // remote addr -> conn
+	stopped bool
+}
+
+// NewUDPAcceptor returns a new instance of UDPAcceptor
+func NewUDPAcceptor(address string, options ...UDPOption) *UDPAcceptor {
+	return &UDPAcceptor{
+		address: address,
+		opts:    loadUDPOptions(options...),
+		conns:   map[string]*udpConn{},
+	}
+}
+
+// ServeUDP listen and serve udp address with AcceptorHandler
+func ServeUDP(address string, handler AcceptorHandler) error {
+	return NewUDPAcceptor(address).Serve(handler)
+}
+
+// ServeUDPFunc listen and serve udp address with AcceptorHandlerFunc
+func ServeUDPFunc(address string, handler AcceptorHandlerFunc) error {
+	return NewUDPAcceptor(address).ServeFunc(handler)
+}
+
+// Serve listens and serve in the specified addr.
+// It returns io.EOF after the acceptor is stopped, the connections are broken then.
+func (this *UDPAcceptor) Serve(handler AcceptorHandler) error {
+	if handler == nil {
+		return errors.New("dnet:Serve handler is nil. ")
+	}
+
+	if !atomic.CompareAndSwapInt32(&this.started, 0, 1) {
+		return errors.New("dnet:Serve acceptor is already started. ")
+	}
+
+	addr, err := net.ResolveUDPAddr("udp", this.address)
+	if err != nil {
+		return err
+	}
+	conn, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		return err
+	}
+
+	this.mtx.Lock()
+	if this.stopped {
+		this.mtx.Unlock()
+		_ = conn.Close()
+		return io.EOF
+	}
+	this.conn = conn
+	this.mtx.Unlock()
+	defer this.Stop()
+
+	output := newUDPOutput(this.opts, func(b []byte, addr *net.UDPAddr) {
+		_, _ = conn.WriteToUDP(b, addr)
+	})
+	buf := make([]byte, 65536)
+	for {
+		n, addr, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				continue
+			}
+			if strings.Contains(err.Error(), "use of closed network connection") {
+				return io.EOF
+			}
+			return err
+		}
+		if p, ok := unmarshalUDPPacket(buf[:n]); ok {
+			this.input(p, addr, handler, output)
+		}
+	}
+}
+
+// input dispatches the packet to the conn of addr, a SYN makes a new conn.
+func (this *UDPAcceptor) input(p *udpPacket, addr *net.UDPAddr, handler AcceptorHandler, output func(b []byte, addr *net.UDPAddr)) {
+	key := addr.String()
+	this.mtx.Lock()
+	c := this.conns[key]
+	if p.cmd != udpSYN {
+		this.mtx.Unlock()
+		if c != nil && c.conv == p.conv {
+			c.input(p)
+		}
+		return
+	}
+
+	synAck := (&udpPacket{cmd: udpSYNACK, conv: p.conv}).marshal()
+	if c != nil && c.conv == p.conv {
+		// the SYNACK is lost
+		this.mtx.Unlock()
+		output(synAck, addr)
+		return
+	}
+	if c == nil && this.opts.MaxConns > 0 && len(this.conns) >= this.opts.MaxConns {
+		// refused by the FIN of the conv
+		this.mtx.Unlock()
+		output((&udpPacket{cmd: udpFin, conv: p.conv}).marshal(), addr)
+		return
+	}
+	old := c
+	c = newUDPConn(p.conv, this.conn.LocalAddr(), addr, this.opts, func(b []byte) {
+		output(b, addr)
+	}, nil)
+	c.onClose = func() {
+		this.mtx.Lock()
+		if this.conns[key] == c {
+			delete(this.conns, key)
+		}
+		this.mtx.Unlock()
+	}
+	this.conns[key] = c
+	this.mtx.Unlock()
+
+	if old != nil {
+		// the peer dials again from the same address
+		old.mtx.Lock()
+		old.fail(io.EOF)
+		old.mtx.Unlock()
+	}
+	output(synAck, addr)
+	go c.run()
+	go handler.OnConnection(c)
+}
+
+// ServeFunc listens and serve in the specified addr
+func (this *UDPAcceptor) ServeFunc(handler AcceptorHandlerFunc) error {
+	return this.Serve(handler)
+}
+
+// Addr returns the addr the acceptor will listen on, nil if it is not started.
+func (this *UDPAcceptor) Addr() net.Addr {
+	this.mtx.Lock()
+	defer this.mtx.Unlock()
+	if this.conn == nil {
+		return nil
+	}
+	return this.conn.LocalAddr()
+}
+
+// Stop stops the acceptor, and breaks the connections.
+func (this *UDPAcceptor) Stop() {
+	this.mtx.Lock()
+	if this.stopped {
+		this.mtx.Unlock()
+		return
+	}
+	this.stopped = true
+	conns := this.conns
+	this.conns = map[string]*udpConn{}
+	if this.conn != nil {
+		_ = this.conn.Close()
+	}
+	this.mtx.Unlock()
+
+	for _, c := range conns {
+		c.mtx.Lock()
+		c.fail(errUDPStopped)
+		c.mtx.Unlock()
+	}
+}
+
+// DialUDP connects to the UDPAcceptor at address. The SYN is resent until
+// it is accepted in timeout, the zero timeout means UDPOptions.DeadLinkTimeout.
+// It fails at once if the acceptor is at the limit of UDPOptions.MaxConns.
+func DialUDP(address string, timeout time.Duration, options ...UDPOption) (net.Conn, error) {
+	opts := loadUDPOptions(options...)
+	if timeout <= 0 {
+		timeout = opts.DeadLinkTimeout
+	}
+	raddr, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		return nil, err
+	}
+	sock, err := net.DialUDP("udp", nil, raddr)
+	if err != nil {
+		return nil, err
+	}
+
+	output := newUDPOutput(opts, func(b []byte, _ *net.UDPAddr) {
+		_, _ = sock.Write(b)
+	})
+	conv := rand.New(rand.NewSource(time.Now().UnixNano())).Uint32()
+	c := newUDPConn(conv, sock.LocalAddr(), raddr, opts, func(b []byte) {
+		output(b, raddr)
+	}, func() {
+		_ = sock.Close()
+	})
+
+	// the handshake
+	syn := (&udpPacket{cmd: udpSYN, conv: conv}).marshal()
+	deadline := time.Now().Add(timeout)
+	buf := make([]byte, 65536)
+	var first *udpPacket
+	for first == nil {
+		now := time.Now()
+		if !now.Before(deadline) {
+			_ = sock.Close()
+			return nil, errors.New("dnet:DialUDP " + address + " timeout. ")
+		}
+		retry := now.Add(200 * time.Millisecond)
+		if retry.After(deadline) {
+			retry = deadline
+		}
+		output(syn, raddr)
+		_ = sock.SetReadDeadline(retry)
+		for time.Now().Before(retry) {
+			n, err := sock.Read(buf)
+			if err != nil {
+				if ne, ok := err.(net.Error); ok && ne.Timeout() {
+					break
+				}
+				// such as the port is unreachable
+				time.Sleep(time.Until(retry))
+				break
+			}
+			if p, ok := unmarshalUDPPacket(buf[:n]); ok && p.conv == conv && p.cmd != udpSYN {
+				first = p
+				break
+			}
+		}
+	}
+	_ = sock.SetReadDeadline(time.Time{})
+	if first.cmd == udpFin {
+		_ = sock.Close()
+		return nil, errors.New("dnet:DialUDP " + address + " is refused. ")
+	}
+	if first.cmd != udpSYNACK {
+		// the SYNACK is lost, but the data is received
+		c.input(first)
+	}
+
+	go c.run()
+	go func() {
+		buf := make([]byte, 65536)
+		for {
+			n, err := sock.Read(buf)
+			if err != nil {
+				select {
+				case <-c.doneCh:
+					return
+				default:
+				}
+				if strings.Contains(err.Error(), "use of closed network connection") {
+					return
+				}
+				// such as the port is unreachable, the dead link is found by the timeout
+				continue
+			}
+			if p, ok := unmarshalUDPPacket(buf[:n]); ok && p.conv == conv {
+				c.input(p)
+			}
+		}
+	}()
+	return c, nil
+}
+
+// newUDPOutput returns the output which drops the packets by the loss of UDPOptions.
+func newUDPOutput(opts *UDPOptions, output func(b []byte, addr *net.UDPAddr)) func(b []byte, addr *net.UDPAddr) {
+	if opts.loss <= 0 {
+		return output
+	}
+	var mtx sync.Mutex
+	random := rand.New(rand.NewSource(time.Now().UnixNano()))
+	return func(b []byte, addr *net.UDPAddr) {
+		mtx.Lock()
+		drop := random.Float64() < opts.loss
+		mtx.Unlock()
+		if !drop {
+			output(b, addr)
+		}
+	}
+}
@@ -0,0 +1,509 @@
+package dnet
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+var (
+	ErrUDPDeadLink = errors.New("dnet: udp peer is not responding. ")
+	errUDPClosed   = errors.New("dnet: use of closed udp connection. ")
+	errUDPStopped  = errors.New("dnet: udp acceptor is stopped. ")
+)
+
+// the commands of the packets
+const (
+	udpSYN    byte = iota + 1 // dials with a new conv
+	udpSYNACK                 // accepts the conv
+	udpData                   // a segment of the stream
+	udpAck                    // acks the seqs in the payload, or keeps alive if it is empty
+	udpFin                    // all the data sent is acked, no more data
+)
+
+// cmd(1) conv(4) seq(4) una(4) wnd(2)
+const udpHeaderSize = 15
+
+const (
+	udpMinRTO = 30 * time.Millisecond
+	udpMaxRTO = 3 * time.Second
+)
+
+// udpPacket is the packet of the ARQ over UDP.
+// una is the next seq expected by the sender, all the seqs before it are acked.
+// wnd is the number of segments the sender can receive.
+type udpPacket struct {
+	cmd  byte
+	conv uint32
+	seq  uint32
+	una  uint32
+	wnd  uint16
+	data []byte
+}
+
+func (p *udpPacket) marshal() []byte {
+	b := make([]byte, udpHeaderSize+len(p.data))
+	b[0] = p.cmd
+	binary.BigEndian.PutUint32(b[1:], p.conv)
+	binary.BigEndian.PutUint32(b[5:], p.seq)
+	binary.BigEndian.PutUint32(b[9:], p.una)
+	binary.BigEndian.PutUint16(b[13:], p.wnd)
+	copy(b[udpHeaderSize:], p.data)
+	return b
+}
+
+func unmarshalUDPPacket(b []byte) (*udpPacket, bool) {
+	if len(b) < udpHeaderSize || b[0] < udpSYN || b[0] > udpFin {
+		return nil, false
+	}
+	return &udpPacket{
+		cmd:  b[0],
+		conv: binary.BigEndian.Uint32(b[1:]),
+		seq:  binary.BigEndian.Uint32(b[5:]),
+		una:  binary.BigEndian.Uint32(b[9:]),
+		wnd:  binary.BigEndian.Uint16(b[13:]),
+		data: append([]byte{}, b[udpHeaderSize:]...),
+	}, true
+}
+
+// seqBefore returns true if a is before b, the seqs wrap around.
+func seqBefore(a, b uint32) bool {
+	return int32(a-b) < 0
+}
+
+type udpSegment struct {
+	seq      uint32
+	data     []byte
+	xmit     int // times sent
+	sentAt   time.Time
+	resendAt time.Time
+	rto      time.Duration
+	fastack  int // times the later seqs are acked
+	acked    bool
+}
+
+// udpConn is a reliable and ordered stream over UDP, it implements net.Conn.
+// The segments are sent in the window of the peer, and resent if they are
+// not acked in the RTO, or the later ones are acked twice.
+type udpConn struct {
+	conv          uint32
+	opts          *UDPOptions
+	mss           int
+	local, remote net.Addr
+	output        func(b []byte) // sends a packet to the peer
+	onClose       func()         // called after the conn is finished
+
+	mtx      sync.Mutex
+	sndNxt   uint32
+	sndBuf   []*udpSegment // sent but not acked, by seq
+	rmtWnd   int
+	rcvNxt   uint32
+	rcvBuf   map[uint32][]byte // received out of order
+	readBuf  []byte            // received in order
+	acks     []uint32          // seqs to ack
+	lastWnd  int               // the wnd sent last
+	updWnd   bool              // sends the wnd opened by Read
+	srtt     time.Duration
+	rttvar   time.Duration
+	rto      time.Duration
+	lastRecv time.Time
+	lastSend time.Time
+	peerFin  bool
+	closed   bool  // closed by Close
+	err      error // the link is broken
+	finished bool
+
+	flushCh       chan struct{}
+	readNotify    chan struct{}
+	writeNotify   chan struct{}
+	closeCh       chan struct{}
+	doneCh        chan struct{}
+	readDeadline  deadline
+	writeDeadline deadline
+}
+
+func newUDPConn(conv uint32, local, remote net.Addr, opts *UDPOptions, output func(b []byte), onClose func()) *udpConn {
+	now := opts.Clock.Now()
+	return &udpConn{
+		conv:          conv,
+		opts:          opts,
+		mss:           opts.MTU - udpHeaderSize,
+		local:         local,
+		remote:        remote,
+		output:        output,
+		onClose:       onClose,
+		rmtWnd:        opts.Window,
+		rcvBuf:        map[uint32][]byte{},
+		lastWnd:       opts.Window,
+		rto:           200 * time.Millisecond,
+		lastRecv:      now,
+		lastSend:      now,
+		flushCh:       make(chan struct{}, 1),
+		readNotify:    make(chan struct{}, 1),
+		writeNotify:   make(chan struct{}, 1),
+		closeCh:       make(chan struct{}),
+		doneCh:        make(chan struct{}),
+		readDeadline:  makeDeadline(),
+		writeDeadline: makeDeadline(),
+	}
+}
+
+// run flushes the conn every Interval on the clock, until it is finished.
+func (c *udpConn) run() {
+	var timer Timer
+	c.mtx.Lock()
+	timer = c.opts.Clock.AfterFunc(c.opts.Interval, func() {
+		c.mtx.Lock()
+		defer c.mtx.Unlock()
+		if !c.finished {
+			timer.Reset(c.opts.Interval)
+			sendNotifyChan(c.flushCh)
+		}
+	})
+	c.mtx.Unlock()
+	defer timer.Stop()
+	for {
+		<-c.flushCh
+		packets, finished := c.flush(c.opts.Clock.Now())
+		for _, p := range packets {
+			c.output(p)
+		}
+		if finished {
+			if c.onClose != nil {
+				c.onClose()
+			}
+			return
+		}
+	}
+}
+
+// input handles a packet from the peer.
+func (c *udpConn) input(p *udpPacket) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if c.finished {
+		return
+	}
+	c.lastRecv = c.opts.Clock.Now()
+	c.rmtWnd = int(p.wnd)
+	if p.cmd == udpAck {
+		// the acks sample the RTT before the segments are removed by una
+		for b := p.data; len(b) >= 4; b = b[4:] {
+			c.ackSeq(binary.BigEndian.Uint32(b))
+		}
+	}
+	c.ackUna(p.una)
+
+	switch p.cmd {
+	case udpData:
+		c.acks = append(c.acks, p.seq)
+		if !seqBefore(p.seq, c.rcvNxt) && seqBefore(p.seq, c.rcvNxt+uint32(c.opts.Window)) {
+			if _, ok := c.rcvBuf[p.seq]; !ok {
+				c.rcvBuf[p.seq] = p.data
+			}
+			for {
+				data, ok := c.rcvBuf[c.rcvNxt]
+				if !ok {
+					break
+				}
+				delete(c.rcvBuf, c.rcvNxt)
+				c.readBuf = append(c.readBuf, data...)
+				c.rcvNxt++
+			}
+			sendNotifyChan(c.readNotify)
+		}
+		sendNotifyChan(c.flushCh)
+	case udpFin:
+		c.peerFin = true
+		sendNotifyChan(c.readNotify)
+		sendNotifyChan(c.flushCh)
+	}
+}
+
+// ackUna acks the segments before una.
+func (c *udpConn) ackUna(una uint32) {
+	for _, seg := range c.sndBuf {
+		if !seqBefore(seg.seq, una) {
+			break
+		}
+		seg.acked = true
+	}
+	c.trimSndBuf()
+}
+
+// ackSeq acks the segment of seq, the segments before it are missed once more.
+func (c *udpConn) ackSeq(seq uint32) {
+	for _, seg := range c.sndBuf {
+		if seg.seq == seq {
+			if !seg.acked && seg.xmit == 1 {
+				c.updateRTT(c.opts.Clock.Now().Sub(seg.sentAt))
+			}
+			seg.acked = true
+			break
+		}
+		if !seqBefore(seg.seq, seq) {
+			break
+		}
+		seg.fastack++
+	}
+	c.trimSndBuf()
+}
+
+func (c *udpConn) trimSndBuf() {
+	i := 0
+	for i < len(c.sndBuf) && c.sndBuf[i].acked {
+		i++
+	}
+	if i > 0 {
+		c.sndBuf = c.sndBuf[i:]
+		sendNotifyChan(c.writeNotify)
+	}
+}
+
+func (c *udpConn) updateRTT(rtt time.Duration) {
+	if c.srtt == 0 {
+		c.srtt, c.rttvar = rtt, rtt/2
+	} else {
+		delta := rtt - c.srtt
+		if delta < 0 {
+			delta = -delta
+		}
+		c.rttvar = (3*c.rttvar + delta) / 4
+		c.srtt = (7*c.srtt + rtt) / 8
+	}
+	c.rto = c.srtt + 4*c.rttvar + c.opts.Interval
+	if c.rto < udpMinRTO {
+		c.rto = udpMinRTO
+	} else if c.rto > udpMaxRTO {
+		c.rto = udpMaxRTO
+	}
+}
+
+// rcvWnd returns the number of segments can be received.
+func (c *udpConn) rcvWnd() int {
+	wnd := c.opts.Window - len(c.rcvBuf) - (len(c.readBuf)+c.mss-1)/c.mss
+	if wnd < 0 {
+		return 0
+	}
+	return wnd
+}
+
+func (c *udpConn) packet(cmd byte, seq uint32, data []byte) []byte {
+	return (&udpPacket{cmd: cmd, conv: c.conv, seq: seq, una: c.rcvNxt, wnd: uint16(c.lastWnd), data: data}).marshal()
+}
+
+// flush returns the packets to send at now, and whether the conn is finished.
+func (c *udpConn) flush(now time.Time) (packets [][]byte, finished bool) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if c.finished {
+		return nil, true
+	}
+	if c.err == nil && now.Sub(c.lastRecv) > c.opts.DeadLinkTimeout {
+		c.fail(ErrUDPDeadLink)
+	}
+	c.lastWnd = c.rcvWnd()
+
+	// acks
+	for len(c.acks) > 0 {
+		n := len(c.acks)
+		if n > c.mss/4 {
+			n = c.mss / 4
+		}
+		data := make([]byte, 4*n)
+		for i, seq := range c.acks[:n] {
+			binary.BigEndian.PutUint32(data[4*i:], seq)
+		}
+		packets = append(packets, c.packet(udpAck, 0, data))
+		c.acks = c.acks[n:]
+	}
+	c.acks = nil
+
+	// segments in the window
+	if c.err == nil {
+		wnd := c.opts.Window
+		if c.rmtWnd < wnd {
+			wnd = c.rmtWnd
+		}
+		for _, seg := range c.sndBuf {
+			if int(seg.seq-c.sndBuf[0].seq) >= wnd {
+				break
+			}
+			if seg.acked {
+				continue
+			}
+			switch {
+			case seg.xmit == 0:
+				seg.rto = c.rto
+			case !now.Before(seg.resendAt):
+				seg.rto *= 2
+				if seg.rto > udpMaxRTO {
+					seg.rto = udpMaxRTO
+				}
+			case seg.fastack >= 2:
+				seg.fastack = 0
+			default:
+				continue
+			}
+			seg.xmit++
+			seg.sentAt, seg.resendAt = now, now.Add(seg.rto)
+			packets = append(packets, c.packet(udpData, seg.seq, seg.data))
+		}
+	}
+
+	// the wnd is opened, or keeps alive
+	if len(packets) == 0 && (c.updWnd || now.Sub(c.lastSend) >= c.opts.KeepAlive) {
+		packets = append(packets, c.packet(udpAck, 0, nil))
+	}
+	c.updWnd = false
+
+	if c.err != nil || (c.closed && (len(c.sndBuf) == 0 || c.peerFin)) {
+		// the FIN may be lost, the peer finds the dead link then
+		packets = append(packets, c.packet(udpFin, c.sndNxt, nil))
+		c.finish()
+	}
+	if len(packets) > 0 {
+		c.lastSend = now
+	}
+	return packets, c.finished
+}
+
+// fail breaks the link by err.
+func (c *udpConn) fail(err error) {
+	if c.err == nil {
+		c.err = err
+		sendNotifyChan(c.readNotify)
+		sendNotifyChan(c.writeNotify)
+		sendNotifyChan(c.flushCh)
+	}
+}
+
+func (c *udpConn) finish() {
+	if !c.finished {
+		c.finished = true
+		close(c.doneCh)
+	}
+}
+
+// Read reads the data in order, it returns io.EOF after the peer is closed.
+func (c *udpConn) Read(b []byte) (int, error) {
+	for {
+		c.mtx.Lock()
+		if len(c.readBuf) > 0 {
+			n := copy(b, c.readBuf)
+			c.readBuf = c.readBuf[n:]
+			if len(c.readBuf) == 0 {
+				c.readBuf = nil
+			}
+			if c.lastWnd == 0 && c.rcvWnd() > 0 {
+				c.updWnd = true
+				sendNotifyChan(c.flushCh)
+			}
+			c.mtx.Unlock()
+			return n, nil
+		}
+		var err error
+		switch {
+		case c.closed:
+			err = errUDPClosed
+		case c.peerFin:
+			err = io.EOF
+		case c.err != nil:
+			err = c.err
+		}
+		c.mtx.Unlock()
+		if err != nil {
+			return 0, err
+		}
+
+		select {
+		case <-c.readNotify:
+		case <-c.closeCh:
+		case <-c.readDeadline.wait():
+			return 0, timeoutError{}
+		}
+	}
+}
+
+// Write writes the data to the send buffer, it blocks if the buffer is full.
+func (c *udpConn) Write(b []byte) (int, error) {
+	n := 0
+	for n < len(b) {
+		c.mtx.Lock()
+		var err error
+		switch {
+		case c.closed:
+			err = errUDPClosed
+		case c.err != nil:
+			err = c.err
+		}
+		if err != nil {
+			c.mtx.Unlock()
+			return n, err
+		}
+
+		for n < len(b) && len(c.sndBuf) < 2*c.opts.Window {
+			size := len(b) - n
+			if size > c.mss {
+				size = c.mss
+			}
+			c.sndBuf = append(c.sndBuf, &udpSegment{seq: c.sndNxt, data: append([]byte{}, b[n:n+size]...)})
+			c.sndNxt++
+			n += size
+		}
+		sendNotifyChan(c.flushCh)
+		c.mtx.Unlock()
+		if n == len(b) {
+			break
+		}
+
+		select {
+		case <-c.writeNotify:
+		case <-c.closeCh:
+		case <-c.writeDeadline.wait():
+			return n, timeoutError{}
+		}
+	}
+	return n, nil
+}
+
+// Close closes the conn, the data written is still sent until it is acked by the peer.
+// Any blocked Read or Write operations will be unblocked and return errors.
+func (c *udpConn) Close() error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if c.closed {
+		return errUDPClosed
+	}
+	c.closed = true
+	close(c.closeCh)
+	sendNotifyChan(c.flushCh)
+	return nil
+}
+
+func (c *udpConn) LocalAddr() net.Addr {
+	return c.local
+}
+
+func (c *udpConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+func (c *udpConn) SetDeadline(t time.Time) error {
+	c.readDeadline.set(t)
+	c.writeDeadline.set(t)
+	return nil
+}
+
+func (c *udpConn) SetReadDeadline(t time.Time) error {
+	c.readDeadline.set(t)
+	return nil
+}
+
+func (c *udpConn) SetWriteDeadline(t time.Time) error {
+	c.writeDeadline.set(t)
+	return nil
+}
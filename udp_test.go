@@ -0,0 +1,229 @@
+package dnet
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// serveUDPEcho starts an acceptor which echoes the messages of TCPSession.
+func serveUDPEcho(t *testing.T, options ...UDPOption) (*UDPAcceptor, <-chan error) {
+	acceptor := NewUDPAcceptor("127.0.0.1:0", options...)
+	served := make(chan error, 1)
+	go func() {
+		served <- acceptor.ServeFunc(func(conn net.Conn) {
+			NewTCPSession(conn, WithMessageCallback(func(session Session, message interface{}) {
+				_ = session.Send(message)
+			}))
+		})
+	}()
+	deadline := time.Now().Add(time.Second)
+	for acceptor.Addr() == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("acceptor is not started")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return acceptor, served
+}
+
+// withUDPLoss drops the packets sent by the rate.
+func withUDPLoss(rate float64) UDPOption {
+	return func(opt *UDPOptions) {
+		opt.loss = rate
+	}
+}
+
+func TestUDPSessionWithLoss(t *testing.T) {
+	acceptor, _ := serveUDPEcho(t, withUDPLoss(0.2))
+	defer acceptor.Stop()
+
+	conn, err := DialUDP(acceptor.Addr().String(), 5*time.Second, withUDPLoss(0.2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	msgCh := make(chan interface{}, 100)
+	session := NewTCPSession(conn, WithMessageCallback(func(session Session, message interface{}) {
+		msgCh <- message
+	}))
+	defer session.Close(nil)
+
+	random := rand.New(rand.NewSource(1))
+	var sent [][]byte
+	for i := 0; i < 100; i++ {
+		// some messages are larger than a segment
+		data := make([]byte, 1+random.Intn(4000))
+		random.Read(data)
+		sent = append(sent, data)
+		if err := session.Send(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i, data := range sent {
+		select {
+		case msg := <-msgCh:
+			if !bytes.Equal(msg.([]byte), data) {
+				t.Fatalf("message %d is not the one sent", i)
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatalf("wait message %d timeout", i)
+		}
+	}
+}
+
+func TestUDPRTT(t *testing.T) {
+	acceptor, _ := serveUDPEcho(t)
+	defer acceptor.Stop()
+
+	conn, err := DialUDP(acceptor.Addr().String(), 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msgCh := make(chan interface{}, 10)
+	session := NewTCPSession(conn, WithMessageCallback(func(session Session, message interface{}) {
+		msgCh <- message
+	}))
+	defer session.Close(nil)
+
+	for i := 0; i < 10; i++ {
+		_ = session.Send([]byte("hello"))
+		select {
+		case <-msgCh:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("wait message %d timeout", i)
+		}
+	}
+
+	c := conn.(*udpConn)
+	c.mtx.Lock()
+	srtt, rto := c.srtt, c.rto
+	c.mtx.Unlock()
+	if srtt <= 0 || rto < udpMinRTO {
+		t.Fatalf("srtt %v rto %v, the RTT is not measured", srtt, rto)
+	}
+}
+
+func TestUDPClose(t *testing.T) {
+	acceptor := NewUDPAcceptor("127.0.0.1:0")
+	closed := make(chan error, 1)
+	served := make(chan error, 1)
+	go func() {
+		served <- acceptor.ServeFunc(func(conn net.Conn) {
+			NewTCPSession(conn,
+				WithMessageCallback(func(session Session, message interface{}) {}),
+				WithCloseCallback(func(session Session, reason error) { closed <- reason }))
+		})
+	}()
+	for acceptor.Addr() == nil {
+		time.Sleep(time.Millisecond)
+	}
+
+	conn, err := DialUDP(acceptor.Addr().String(), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	session := NewTCPSession(conn, WithMessageCallback(func(session Session, message interface{}) {}))
+	_ = session.Send([]byte("bye"))
+	session.Close(nil)
+
+	// the peer reads EOF after the data sent before Close
+	select {
+	case reason := <-closed:
+		if reason != io.EOF {
+			t.Fatalf("close reason %v", reason)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("wait close timeout")
+	}
+
+	acceptor.Stop()
+	if err := <-served; err != io.EOF {
+		t.Fatalf("Serve returns %v", err)
+	}
+}
+
+func TestUDPDeadLink(t *testing.T) {
+	keepAlive := WithUDPKeepAlive(50*time.Millisecond, 300*time.Millisecond)
+	acceptor, _ := serveUDPEcho(t, keepAlive)
+	conn, err := DialUDP(acceptor.Addr().String(), time.Second, keepAlive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// the idle conn is kept alive
+	time.Sleep(500 * time.Millisecond)
+	if _, err := conn.Write([]byte{0, 0, 0, 1, 'a'}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the FIN is not sent to the dialer if the socket is closed first
+	acceptor.mtx.Lock()
+	_ = acceptor.conn.Close()
+	acceptor.mtx.Unlock()
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	buf := make([]byte, 16)
+	for {
+		if _, err = conn.Read(buf); err != nil {
+			break
+		}
+	}
+	if err != ErrUDPDeadLink {
+		t.Fatalf("read %v, want %v", err, ErrUDPDeadLink)
+	}
+}
+
+func TestDialUDPTimeout(t *testing.T) {
+	sock, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the SYN is never answered
+	defer sock.Close()
+
+	start := time.Now()
+	if _, err := DialUDP(sock.LocalAddr().String(), 300*time.Millisecond); err == nil {
+		t.Fatal("dial without the acceptor")
+	}
+	if d := time.Since(start); d < 300*time.Millisecond || d > time.Second {
+		t.Fatalf("dial returns after %s", d)
+	}
+}
+
+func TestUDPMaxConns(t *testing.T) {
+	acceptor, _ := serveUDPEcho(t, WithUDPMaxConns(1))
+	defer acceptor.Stop()
+
+	conn, err := DialUDP(acceptor.Addr().String(), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the SYN at the limit is refused at once
+	start := time.Now()
+	if _, err := DialUDP(acceptor.Addr().String(), time.Second); err == nil || !strings.Contains(err.Error(), "refused") {
+		t.Fatalf("dial at the limit: %v", err)
+	}
+	if d := time.Since(start); d >= time.Second {
+		t.Fatalf("refused after %s", d)
+	}
+
+	// the closed conn frees the slot
+	_ = conn.Close()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		conn, err = DialUDP(acceptor.Addr().String(), time.Second)
+		if err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("dial after close: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	_ = conn.Close()
+}
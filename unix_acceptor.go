@@ -0,0 +1,166 @@
+package dnet
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// UnixAcceptor accepts the connections of a Unix domain socket,
+// which are handled by TCPSession as the TCP ones.
+type UnixAcceptor struct {
+	path    string
+	opts    *UnixOptions
+	started int32
+
+	mtx      sync.Mutex
+	listener *net.UnixListener
+	stopped  bool
+}
+
+// NewUnixAcceptor returns a new instance of UnixAcceptor of the socket file path.
+func NewUnixAcceptor(path string, options ...UnixOption) *UnixAcceptor {
+	return &UnixAcceptor{path: path, opts: loadUnixOptions(options...)}
+}
+
+// ServeUnix listen and serve the socket file path with AcceptorHandler
+func ServeUnix(path string, handler AcceptorHandler) error {
+	return NewUnixAcceptor(path).Serve(handler)
+}
+
+// ServeUnixFunc listen and serve the socket file path with AcceptorHandlerFunc
+func ServeUnixFunc(path string, handler AcceptorHandlerFunc) error {
+	return NewUnixAcceptor(path).ServeFunc(handler)
+}
+
+// Serve listens and serve in the specified path.
+// The stale socket file left by a dead process is removed before listening,
+// and the socket file is removed after the acceptor is stopped.
+func (this *UnixAcceptor) Serve(handler AcceptorHandler) error {
+	if handler == nil {
+		return errors.New("dnet:Serve handler is nil. ")
+	}
+
+	if !atomic.CompareAndSwapInt32(&this.started, 0, 1) {
+		return errors.New("dnet:Serve acceptor is already started. ")
+	}
+
+	if err := removeStaleSocket(this.path); err != nil {
+		return err
+	}
+	listener, err := listenUnix(this.path, this.opts.Mode)
+	if err != nil {
+		return err
+	}
+
+	this.mtx.Lock()
+	if this.stopped {
+		this.mtx.Unlock()
+		_ = listener.Close()
+		_ = os.Remove(this.path)
+		return io.EOF
+	}
+	this.listener = listener
+	this.mtx.Unlock()
+	defer this.Stop()
+
+	return serveListener(listener, handler)
+}
+
+// listenUnix listens on the socket file path with mode. The socket is made in a private
+// directory, and renamed to path after chmod, so that it is never connected with the
+// permission of umask. The socket file is not removed by the Close of the listener.
+func listenUnix(path string, mode os.FileMode) (*net.UnixListener, error) {
+	if mode == 0 {
+		listener, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+		if err != nil {
+			return nil, err
+		}
+		listener.SetUnlinkOnClose(false)
+		return listener, nil
+	}
+
+	// the directory is made with 0700
+	dir, err := ioutil.TempDir(filepath.Dir(path), ".dnet")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	tmp := filepath.Join(dir, "s")
+	listener, err := net.ListenUnix("unix", &net.UnixAddr{Name: tmp, Net: "unix"})
+	if err != nil {
+		return nil, err
+	}
+	listener.SetUnlinkOnClose(false)
+	if err = os.Chmod(tmp, mode); err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		_ = listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
+
+// removeStaleSocket removes the socket file at path if no one listens on it.
+func removeStaleSocket(path string) error {
+	fi, err := os.Lstat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if fi.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("dnet:Serve %s is not a socket file. ", path)
+	}
+
+	conn, err := net.DialTimeout("unix", path, time.Second)
+	if err == nil {
+		_ = conn.Close()
+		return fmt.Errorf("dnet:Serve %s is in use. ", path)
+	}
+	return os.Remove(path)
+}
+
+// ServeFunc listens and serve in the specified path
+func (this *UnixAcceptor) ServeFunc(handler AcceptorHandlerFunc) error {
+	return this.Serve(handler)
+}
+
+// Addr returns the addr the acceptor will listen on, nil if it is not started.
+func (this *UnixAcceptor) Addr() net.Addr {
+	this.mtx.Lock()
+	defer this.mtx.Unlock()
+	if this.listener == nil {
+		return nil
+	}
+	// the listener may be made at another path, see listenUnix
+	return &net.UnixAddr{Name: this.path, Net: "unix"}
+}
+
+// Stop stops the acceptor, and removes the socket file.
+func (this *UnixAcceptor) Stop() {
+	this.mtx.Lock()
+	defer this.mtx.Unlock()
+	if !this.stopped {
+		this.stopped = true
+		if this.listener != nil {
+			_ = this.listener.Close()
+			_ = os.Remove(this.path)
+		}
+	}
+}
+
+// DialUnix connects to the socket file path.
+func DialUnix(path string, timeout time.Duration) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: timeout}
+	return dialer.Dial("unix", path)
+}
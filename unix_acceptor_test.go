@@ -0,0 +1,86 @@
+package dnet
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestUnixAcceptor(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dnet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "dnet.sock")
+
+	// a stale socket file left by a dead process
+	stale, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.SetUnlinkOnClose(false)
+	stale.Close()
+
+	acceptor := NewUnixAcceptor(path, WithUnixMode(0600))
+	served := make(chan error, 1)
+	go func() {
+		served <- acceptor.ServeFunc(func(conn net.Conn) {
+			NewTCPSession(conn, WithMessageCallback(func(session Session, message interface{}) {
+				_ = session.Send(message)
+			}))
+		})
+	}()
+	deadline := time.Now().Add(time.Second)
+	for acceptor.Addr() == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("acceptor is not started")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if fi, err := os.Stat(path); err != nil || fi.Mode().Perm() != 0600 {
+		t.Fatalf("socket file %v %v", fi, err)
+	}
+	// the socket is made in a private directory, which is removed after it is renamed
+	if files, err := ioutil.ReadDir(dir); err != nil || len(files) != 1 {
+		t.Fatalf("files %v %v", files, err)
+	}
+	if addr := acceptor.Addr().String(); addr != path {
+		t.Fatalf("addr %s", addr)
+	}
+	if err := NewUnixAcceptor(path).ServeFunc(func(conn net.Conn) {}); err == nil {
+		t.Fatal("serve on the socket file in use")
+	}
+
+	conn, err := DialUnix(path, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msgCh := make(chan interface{}, 1)
+	session := NewTCPSession(conn, WithMessageCallback(func(session Session, message interface{}) {
+		msgCh <- message
+	}))
+	defer session.Close(nil)
+	_ = session.Send([]byte("hello"))
+	select {
+	case msg := <-msgCh:
+		if !bytes.Equal(msg.([]byte), []byte("hello")) {
+			t.Fatalf("message %v", msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("wait message timeout")
+	}
+
+	acceptor.Stop()
+	if err := <-served; err != io.EOF {
+		t.Fatalf("Serve returns %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("socket file after Stop: %v", err)
+	}
+}
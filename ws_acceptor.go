@@ -1,35 +1,40 @@
 package dnet
 
 import (
+	"context"
+	"crypto/tls"
 	"errors"
 	"github.com/gorilla/websocket"
+	"io"
 	"log"
 	"net"
 	"net/http"
 	"net/url"
+	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 )
 
 type WSAcceptor struct {
-	address  string
-	handler  *wsHandler
+	address string
+	opts    *WSOptions
+	handler *wsHandler
+	started int32
+
+	mtx      sync.Mutex
 	listener net.Listener
-	started  int32
+	server   *http.Server
+	stopped  bool
 }
 
 // NewWSAcceptor returns a new instance of WSAcceptor
-func NewWSAcceptor(address string) *WSAcceptor {
+func NewWSAcceptor(address string, options ...WSOption) *WSAcceptor {
+	opts := loadWSOptions(options...)
 	return &WSAcceptor{
 		address: address,
-		handler: &wsHandler{
-			upgrader: &websocket.Upgrader{
-				CheckOrigin: func(r *http.Request) bool {
-					// allow all connections by default
-					return true
-				},
-			},
-		},
+		opts:    opts,
+		handler: newWSHandler(nil, opts),
 	}
 }
 
@@ -44,42 +49,161 @@ func ServeWSFunc(address string, handler AcceptorHandlerFunc) error {
 }
 
 type wsHandler struct {
-	upgrader *websocket.Upgrader
-	handler  AcceptorHandler
+	upgrader     *websocket.Upgrader
+	handler      AcceptorHandler
+	authenticate func(r *http.Request) (int, error)
+	trusted      trustedProxies
+	err          error // the options are invalid
+}
+
+func newWSHandler(handler AcceptorHandler, opts *WSOptions) *wsHandler {
+	trusted, err := parseTrustedProxies(opts.TrustedProxies)
+	if err == nil && opts.ProxyProtocol && len(trusted) == 0 {
+		err = errProxyNoTrusted
+	}
+	checkOrigin := opts.CheckOrigin
+	if checkOrigin == nil {
+		if len(opts.AllowedOrigins) > 0 {
+			checkOrigin = allowedOrigins(opts.AllowedOrigins)
+		} else {
+			checkOrigin = func(r *http.Request) bool {
+				// allow all connections by default
+				return true
+			}
+		}
+	}
+	return &wsHandler{
+		upgrader: &websocket.Upgrader{
+			ReadBufferSize:    opts.ReadBufferSize,
+			WriteBufferSize:   opts.WriteBufferSize,
+			Subprotocols:      opts.Subprotocols,
+			EnableCompression: opts.EnableCompression,
+			CheckOrigin:       checkOrigin,
+		},
+		handler:      handler,
+		authenticate: opts.Authenticate,
+		trusted:      trusted,
+		err:          err,
+	}
+}
+
+// allowedOrigins returns the check of the origins.
+func allowedOrigins(origins []string) func(r *http.Request) bool {
+	return func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			// not from a browser
+			return true
+		}
+		for _, o := range origins {
+			if strings.EqualFold(o, origin) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// NewWSHandler returns an http.Handler which upgrades the requests to WebSocket,
+// and calls handler with the WSConn. It can be mounted on a route of http.ServeMux
+// or dhttp.HttpServer, to share the port with other handlers.
+//
+//	mux := http.NewServeMux()
+//	mux.Handle("/ws", dnet.NewWSHandler(dnet.AcceptorHandlerFunc(func(conn net.Conn) {
+//		dnet.NewWSSession(conn, ...)
+//	})))
+//
+// The PROXY protocol is not supported, it is parsed by the listener of the http.Server.
+func NewWSHandler(handler AcceptorHandler, options ...WSOption) http.Handler {
+	if handler == nil {
+		panic("dnet:NewWSHandler handler is nil. ")
+	}
+	opts := loadWSOptions(options...)
+	if opts.ProxyProtocol {
+		panic("dnet:NewWSHandler PROXY protocol is not supported. ")
+	}
+	h := newWSHandler(handler, opts)
+	if h.err != nil {
+		panic("dnet:NewWSHandler " + h.err.Error())
+	}
+	return h
 }
 
 func (h *wsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.authenticate != nil {
+		if status, err := h.authenticate(r); err != nil {
+			if status == 0 {
+				status = http.StatusUnauthorized
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+	}
+
 	c, err := h.upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Printf("dnet:ServeHTTP WSSession Upgrade failed, %s\n", err.Error())
 		return
 	}
-	h.handler.OnConnection(NewWSConn(c))
+	conn := NewWSConn(c)
+	conn.request = r
+	if len(h.trusted) > 0 {
+		// the headers from the others are ignored
+		if conn.remoteAddr = forwardedFor(c.RemoteAddr(), strings.Join(r.Header.Values("X-Forwarded-For"), ","), h.trusted); conn.remoteAddr == nil {
+			conn.remoteAddr = realIP(c.RemoteAddr(), r.Header.Get("X-Real-IP"), h.trusted)
+		}
+	}
+	h.handler.OnConnection(conn)
 }
 
-// Serve listens and serve in the specified addr
+// Serve listens and serve in the specified addr.
+// It returns io.EOF after the acceptor is stopped.
 func (this *WSAcceptor) Serve(handler AcceptorHandler) error {
 	if handler == nil {
 		return errors.New("dnet:Serve handler is nil. ")
 	}
-	this.handler.handler = handler
 
+	// the invalid options do not start the acceptor
+	if this.handler.err != nil {
+		return this.handler.err
+	}
 	if !atomic.CompareAndSwapInt32(&this.started, 0, 1) {
 		return errors.New("dnet:Serve acceptor is already started. ")
 	}
+	this.handler.handler = handler
 
 	listener, err := net.Listen("tcp", this.address)
 	if err != nil {
 		return errors.New("dnet:Serve net.Listen failed, " + err.Error())
 	}
-	this.listener = listener
-	defer this.Stop()
+	if this.opts.ProxyProtocol {
+		l, err := newProxyListener(listener, this.opts.ProxyHeaderTimeout, this.opts.TrustedProxies, this.opts.ProxyHeaderRequired)
+		if err != nil {
+			_ = listener.Close()
+			return err
+		}
+		listener = l
+	}
+	server := &http.Server{
+		Handler:           this.handler,
+		ReadHeaderTimeout: this.opts.ReadHeaderTimeout,
+		IdleTimeout:       this.opts.IdleTimeout,
+		MaxHeaderBytes:    this.opts.MaxHeaderBytes,
+	}
 
-	if err = http.Serve(this.listener, this.handler); err != nil {
-		log.Printf("dnet:Serve failed, %s\n", err.Error())
+	this.mtx.Lock()
+	if this.stopped {
+		this.mtx.Unlock()
+		_ = listener.Close()
+		return io.EOF
 	}
+	this.listener, this.server = listener, server
+	this.mtx.Unlock()
 
-	return nil
+	if err = server.Serve(listener); err == http.ErrServerClosed {
+		return io.EOF
+	}
+	return err
 }
 
 // ServeFunc listens and serve in the specified addr
@@ -87,22 +211,93 @@ func (this *WSAcceptor) ServeFunc(handler AcceptorHandlerFunc) error {
 	return this.Serve(handler)
 }
 
-// Addr returns the addr the acceptor will listen on
+// Addr returns the addr the acceptor will listen on, nil if it is not started.
 func (this *WSAcceptor) Addr() net.Addr {
+	this.mtx.Lock()
+	defer this.mtx.Unlock()
+	if this.listener == nil {
+		return nil
+	}
 	return this.listener.Addr()
 }
 
-// Stop stops the acceptor
+// Stop stops the acceptor, it closes the listener and the connections not upgraded.
+// The WebSocket connections upgraded are not closed.
 func (this *WSAcceptor) Stop() {
-	if !atomic.CompareAndSwapInt32(&this.started, 1, 0) {
-		_ = this.listener.Close()
+	if server := this.stop(); server != nil {
+		_ = server.Close()
+	}
+}
+
+// Shutdown stops the acceptor gracefully, it closes the listener and waits for the
+// connections not upgraded to be idle, until the ctx is done.
+func (this *WSAcceptor) Shutdown(ctx context.Context) error {
+	if server := this.stop(); server != nil {
+		return server.Shutdown(ctx)
+	}
+	return nil
+}
+
+func (this *WSAcceptor) stop() *http.Server {
+	this.mtx.Lock()
+	defer this.mtx.Unlock()
+	if this.stopped {
+		return nil
 	}
+	this.stopped = true
+	return this.server
 }
 
+// DialWS dials ws://host/ by a WSDialer of the timeout.
 func DialWS(host string, timeout time.Duration) (net.Conn, error) {
-	u := url.URL{Scheme: "ws", Host: host}
-	websocket.DefaultDialer.HandshakeTimeout = timeout
-	conn, _, err := websocket.DefaultDialer.Dial(u.String(), nil)
+	return (&WSDialer{HandshakeTimeout: timeout}).Dial(host)
+}
+
+// WSDialer dials the WebSocket connections, its zero value dials ws://host/.
+//
+//	dialer := &dnet.WSDialer{
+//		Path:         "/ws",
+//		Query:        url.Values{"token": {token}},
+//		Subprotocols: []string{"json"},
+//	}
+//	conn, err := dialer.Dial("127.0.0.1:7757")
+type WSDialer struct {
+	Path   string
+	Query  url.Values
+	Header http.Header
+
+	// the subprotocols requested, the one negotiated is returned by WSConn.Subprotocol.
+	Subprotocols []string
+
+	// dials wss:// if it is set
+	TLSClientConfig *tls.Config
+
+	// the timeout of the dial and handshake. zero means no timeout
+	HandshakeTimeout time.Duration
+
+	// requests the per-message deflate
+	EnableCompression bool
+
+	// the sizes of the I/O buffers. default 4096
+	ReadBufferSize, WriteBufferSize int
+}
+
+// Dial connects to the host, and returns the *WSConn.
+func (d *WSDialer) Dial(host string) (net.Conn, error) {
+	u := url.URL{Scheme: "ws", Host: host, Path: d.Path, RawQuery: d.Query.Encode()}
+	if d.TLSClientConfig != nil {
+		u.Scheme = "wss"
+	}
+	dialer := &websocket.Dialer{
+		Proxy:             http.ProxyFromEnvironment,
+		TLSClientConfig:   d.TLSClientConfig,
+		HandshakeTimeout:  d.HandshakeTimeout,
+		ReadBufferSize:    d.ReadBufferSize,
+		WriteBufferSize:   d.WriteBufferSize,
+		Subprotocols:      d.Subprotocols,
+		EnableCompression: d.EnableCompression,
+	}
+	conn, _, err := dialer.Dial(u.String(), d.Header)
 	if err != nil {
 		return nil, err
 	}
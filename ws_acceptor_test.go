@@ -0,0 +1,297 @@
+package dnet
+
+import (
+	"context"
+	"errors"
+	"github.com/gorilla/websocket"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewWSHandler(t *testing.T) {
+	connCh := make(chan net.Conn, 1)
+	mux := http.NewServeMux()
+	mux.Handle("/ws", NewWSHandler(AcceptorHandlerFunc(func(conn net.Conn) {
+		connCh <- conn
+	})))
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/health")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "ok" {
+		t.Fatalf("health %s", body)
+	}
+
+	ws := "ws" + strings.TrimPrefix(server.URL, "http")
+	if _, _, err := websocket.DefaultDialer.Dial(ws+"/other", nil); err == nil {
+		t.Fatal("upgraded on the route not mounted")
+	}
+	c, _, err := websocket.DefaultDialer.Dial(ws+"/ws", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	conn := <-connCh
+	defer conn.Close()
+
+	if err := c.WriteMessage(websocket.BinaryMessage, []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 5)
+	if n, err := conn.Read(buf); err != nil || string(buf[:n]) != "hello" {
+		t.Fatalf("read %q %v", buf[:n], err)
+	}
+}
+
+func TestCheckOrigin(t *testing.T) {
+	server := httptest.NewServer(NewWSHandler(AcceptorHandlerFunc(func(conn net.Conn) {
+		conn.Close()
+	}), WithCheckOrigin(func(r *http.Request) bool {
+		return r.Header.Get("Origin") == "http://example.com"
+	})))
+	defer server.Close()
+
+	ws := "ws" + strings.TrimPrefix(server.URL, "http")
+	header := http.Header{"Origin": {"http://evil.com"}}
+	if _, resp, err := websocket.DefaultDialer.Dial(ws, header); err == nil || resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("dial from a denied origin: %v", err)
+	}
+	header.Set("Origin", "http://example.com")
+	c, _, err := websocket.DefaultDialer.Dial(ws, header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+}
+
+func TestWSDialer(t *testing.T) {
+	reqCh := make(chan *http.Request, 1)
+	connCh := make(chan net.Conn, 1)
+	ws := NewWSHandler(AcceptorHandlerFunc(func(conn net.Conn) {
+		connCh <- conn
+	}), WithAllowedOrigins("http://example.com"), WithSubprotocols("json", "binary"), WithCompression(true))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reqCh <- r
+		ws.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	dialer := &WSDialer{
+		Path:              "/ws",
+		Query:             url.Values{"token": {"abc"}},
+		Header:            http.Header{"Origin": {"http://evil.com"}},
+		Subprotocols:      []string{"binary"},
+		HandshakeTimeout:  time.Second,
+		EnableCompression: true,
+	}
+	if _, err := dialer.Dial(host); err == nil {
+		t.Fatal("dial from a denied origin")
+	}
+	<-reqCh
+
+	dialer.Header.Set("Origin", "http://EXAMPLE.com")
+	conn, err := dialer.Dial(host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	r := <-reqCh
+	if r.URL.Path != "/ws" || r.URL.Query().Get("token") != "abc" {
+		t.Fatalf("request %s", r.URL)
+	}
+	if !strings.Contains(r.Header.Get("Sec-Websocket-Extensions"), "permessage-deflate") {
+		t.Fatalf("extensions %q", r.Header.Get("Sec-Websocket-Extensions"))
+	}
+
+	peer := <-connCh
+	defer peer.Close()
+	if p := conn.(*WSConn).Subprotocol(); p != "binary" {
+		t.Fatalf("client subprotocol %q", p)
+	}
+	if p := peer.(*WSConn).Subprotocol(); p != "binary" {
+		t.Fatalf("server subprotocol %q", p)
+	}
+
+	data := []byte(strings.Repeat("compressed ", 100))
+	if _, err := conn.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	msg, err := peer.(*WSConn).ReadMessage()
+	if err != nil || string(msg.Data) != string(data) {
+		t.Fatalf("read %d bytes %v", len(msg.Data), err)
+	}
+}
+
+func TestWSAuthenticate(t *testing.T) {
+	sessionCh := make(chan *WSSession, 1)
+	server := httptest.NewServer(NewWSHandler(AcceptorHandlerFunc(func(conn net.Conn) {
+		sessionCh <- NewWSSession(conn, WithMessageCallback(func(session Session, message interface{}) {}))
+	}), WithAuthenticate(func(r *http.Request) (int, error) {
+		switch r.URL.Query().Get("token") {
+		case "":
+			return 0, errors.New("no token")
+		case "banned":
+			return http.StatusForbidden, errors.New("banned")
+		}
+		return 0, nil
+	})))
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	for token, status := range map[string]int{"": http.StatusUnauthorized, "banned": http.StatusForbidden} {
+		ws := "ws://" + host + "/?token=" + token
+		if _, resp, err := websocket.DefaultDialer.Dial(ws, nil); err == nil || resp.StatusCode != status {
+			t.Fatalf("token %q: %v", token, err)
+		}
+	}
+
+	dialer := &WSDialer{
+		Path:   "/login",
+		Query:  url.Values{"token": {"abc"}},
+		Header: http.Header{"X-Forwarded-For": {"10.0.0.1, 192.168.0.1"}, "Cookie": {"sid=1"}},
+	}
+	conn, err := dialer.Dial(host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if conn.(*WSConn).Request() != nil {
+		t.Fatal("request of the dialed conn")
+	}
+
+	session := <-sessionCh
+	defer session.Close(nil)
+	r := session.Request()
+	if r == nil || r.URL.Path != "/login" || r.URL.Query().Get("token") != "abc" {
+		t.Fatalf("request %v", r)
+	}
+	if c, err := r.Cookie("sid"); err != nil || c.Value != "1" {
+		t.Fatalf("cookie %v %v", c, err)
+	}
+	// X-Forwarded-For is ignored without the trusted proxies
+	if ip := session.NetConn().(*WSConn).ClientIP(); ip != "127.0.0.1" {
+		t.Fatalf("client ip %s", ip)
+	}
+}
+
+// serveWS starts the acceptor, and returns the result of Serve.
+func serveWS(t *testing.T, acceptor *WSAcceptor) <-chan error {
+	served := make(chan error, 1)
+	go func() {
+		served <- acceptor.ServeFunc(func(conn net.Conn) {
+			NewWSSession(conn, WithMessageCallback(func(session Session, message interface{}) {}))
+		})
+	}()
+	deadline := time.Now().Add(time.Second)
+	for acceptor.Addr() == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("acceptor is not started")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return served
+}
+
+func TestWSAcceptorStop(t *testing.T) {
+	acceptor := NewWSAcceptor("127.0.0.1:0")
+	served := serveWS(t, acceptor)
+	addr := acceptor.Addr().String()
+
+	conn, err := DialWS(addr, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	acceptor.Stop()
+	select {
+	case err := <-served:
+		if err != io.EOF {
+			t.Fatalf("Serve returns %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Serve does not return after Stop")
+	}
+	if _, err := DialWS(addr, time.Second); err == nil {
+		t.Fatal("dial after Stop")
+	}
+	if err := acceptor.Serve(AcceptorHandlerFunc(func(conn net.Conn) {})); err == nil {
+		t.Fatal("Serve again after Stop")
+	}
+}
+
+func TestWSAcceptorShutdown(t *testing.T) {
+	acceptor := NewWSAcceptor("127.0.0.1:0")
+	served := serveWS(t, acceptor)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := acceptor.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-served; err != io.EOF {
+		t.Fatalf("Serve returns %v", err)
+	}
+
+	// stopped before Serve
+	acceptor = NewWSAcceptor("127.0.0.1:0")
+	acceptor.Stop()
+	if err := acceptor.ServeFunc(func(conn net.Conn) {}); err != io.EOF {
+		t.Fatalf("Serve after Stop returns %v", err)
+	}
+}
+
+func TestWSAcceptorLimits(t *testing.T) {
+	acceptor := NewWSAcceptor("127.0.0.1:0", WithHTTPTimeout(100*time.Millisecond, time.Second), WithMaxHeaderBytes(1024))
+	serveWS(t, acceptor)
+	defer acceptor.Stop()
+	addr := acceptor.Addr().String()
+
+	// the headers never end
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_, _ = conn.Write([]byte("GET / HTTP/1.1\r\nHost: x\r\n"))
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err == nil || isTimeout(err) {
+		t.Fatalf("read after the header timeout: %v", err)
+	}
+
+	header := http.Header{"X-Large": {strings.Repeat("x", 16<<10)}}
+	if _, resp, err := websocket.DefaultDialer.Dial("ws://"+addr, header); err == nil || resp == nil || resp.StatusCode != http.StatusRequestHeaderFieldsTooLarge {
+		t.Fatalf("dial with large headers: %v", err)
+	}
+}
+
+func isTimeout(err error) bool {
+	ne, ok := err.(net.Error)
+	return ok && ne.Timeout()
+}
+
+// the acceptor of the invalid options is not started by Serve
+func TestWSAcceptorInvalidOptions(t *testing.T) {
+	acceptor := NewWSAcceptor("127.0.0.1:0", WithTrustedProxies("bad"))
+	for i := 0; i < 2; i++ {
+		if err := acceptor.ServeFunc(func(conn net.Conn) {}); err == nil || !strings.Contains(err.Error(), "trusted proxy") {
+			t.Fatalf("serve %d: %v", i, err)
+		}
+	}
+}
@@ -4,15 +4,34 @@ import (
 	"github.com/gorilla/websocket"
 	"io"
 	"net"
+	"net/http"
+	"sync/atomic"
 	"time"
 )
 
 // WSConn is an adapter to t.Conn, which implements all t.Conn
 // interface base on *websocket.Conn
 type WSConn struct {
-	conn   *websocket.Conn
-	typ    int // message type
-	reader io.Reader
+	conn    *websocket.Conn
+	typ     int // message type
+	reader  io.Reader
+	request *http.Request // the upgrade request of the server side
+	closed  int32         // the close frame of the peer is received
+
+	// the client in X-Forwarded-For or X-Real-IP of the trusted proxies, or the
+	// remote address if the request is not from them
+	remoteAddr net.Addr
+}
+
+const (
+	WSTextMessage   = websocket.TextMessage
+	WSBinaryMessage = websocket.BinaryMessage
+)
+
+// WSMessage is a whole frame of WebSocket with its type.
+type WSMessage struct {
+	Type int // WSTextMessage or WSBinaryMessage
+	Data []byte
 }
 
 // NewWSConn return an initialized *WSConn
@@ -28,7 +47,7 @@ reRead:
 	if c.reader == nil {
 		t, r, err := c.conn.NextReader()
 		if err != nil {
-			return 0, err
+			return 0, c.readErr(err)
 		}
 		c.typ = t
 		c.reader = r
@@ -48,14 +67,38 @@ reRead:
 					goto reRead
 				}
 			}
-			return off + n, err
+			return off + n, c.readErr(err)
 		}
 		off += n
 	}
 	return off, nil
 }
 
-// Write writes data to the connection.
+// MessageType returns the type of the frame read last, WSTextMessage or WSBinaryMessage.
+func (c *WSConn) MessageType() int {
+	return c.typ
+}
+
+// ReadMessage reads a whole frame. The rest of the frame read partly by Read is discarded.
+func (c *WSConn) ReadMessage() (*WSMessage, error) {
+	c.reader = nil
+	t, data, err := c.conn.ReadMessage()
+	if err != nil {
+		return nil, c.readErr(err)
+	}
+	c.typ = t
+	return &WSMessage{Type: t, Data: data}, nil
+}
+
+// readErr records the close frame of the peer, which is returned as *websocket.CloseError.
+func (c *WSConn) readErr(err error) error {
+	if _, ok := err.(*websocket.CloseError); ok {
+		atomic.StoreInt32(&c.closed, 1)
+	}
+	return err
+}
+
+// Write writes data to the connection as a binary frame.
 // Write can be made to time out and return an Error with Timeout() == true
 // after a fixed time limit; see SetDeadline and SetWriteDeadline.
 func (c *WSConn) Write(b []byte) (int, error) {
@@ -67,19 +110,79 @@ func (c *WSConn) Write(b []byte) (int, error) {
 	return len(b), nil
 }
 
+// WriteMessage writes the data as a frame of the type.
+func (c *WSConn) WriteMessage(msg *WSMessage) error {
+	return c.conn.WriteMessage(msg.Type, msg.Data)
+}
+
+// Request returns the HTTP request upgraded to the connection, nil if the connection is dialed.
+// The body of the request has been closed.
+func (c *WSConn) Request() *http.Request {
+	return c.request
+}
+
+// ClientIP returns the IP of RemoteAddr. X-Forwarded-For and X-Real-IP can be forged
+// by the clients, so they are honored only if the request is from the trusted proxies
+// of WithTrustedProxies.
+func (c *WSConn) ClientIP() string {
+	addr := c.RemoteAddr()
+	if ip := addrIP(addr); ip != nil {
+		return ip.String()
+	}
+	return addr.String()
+}
+
+// Subprotocol returns the subprotocol negotiated by the handshake.
+func (c *WSConn) Subprotocol() string {
+	return c.conn.Subprotocol()
+}
+
 // Close closes the connection.
 // Any blocked Read or Write operations will be unblocked and return errors.
 func (c *WSConn) Close() error {
 	return c.conn.Close()
 }
 
+// CloseWithReason closes the connection by the closing handshake. It sends a close frame
+// of the code and text, and waits for the close frame of the peer in timeout, unless the
+// peer has closed first. It must not be called with Read concurrently.
+func (c *WSConn) CloseWithReason(code int, text string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	if c.writeClose(code, text, deadline) == nil {
+		_ = c.conn.SetReadDeadline(deadline)
+		c.waitClose()
+	}
+	return c.conn.Close()
+}
+
+// writeClose sends the close frame, unless the peer has closed first.
+func (c *WSConn) writeClose(code int, text string, deadline time.Time) error {
+	if atomic.LoadInt32(&c.closed) == 1 {
+		return websocket.ErrCloseSent
+	}
+	return c.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, text), deadline)
+}
+
+// waitClose discards the messages until the close frame of the peer or an error.
+func (c *WSConn) waitClose() {
+	for atomic.LoadInt32(&c.closed) == 0 {
+		if _, _, err := c.conn.NextReader(); err != nil {
+			return
+		}
+	}
+}
+
 // LocalAddr returns the local network address.
 func (c *WSConn) LocalAddr() net.Addr {
 	return c.conn.LocalAddr()
 }
 
-// RemoteAddr returns the remote network address.
+// RemoteAddr returns the remote network address, or the client in X-Forwarded-For
+// or X-Real-IP if the request is from the trusted proxies of WithTrustedProxies.
 func (c *WSConn) RemoteAddr() net.Addr {
+	if c.remoteAddr != nil {
+		return c.remoteAddr
+	}
 	return c.conn.RemoteAddr()
 }
 
@@ -2,10 +2,14 @@ package dnet
 
 import (
 	"fmt"
+	"github.com/gorilla/websocket"
 	"github.com/yddeng/utils/buffer"
 	"io"
 	"net"
+	"net/http"
 	"reflect"
+	"strings"
+	"time"
 )
 
 type defWsCodec struct {
@@ -34,6 +38,86 @@ func (encoder *defWsCodec) Encode(o interface{}) ([]byte, error) {
 	return data, nil
 }
 
+// WSMessageEncoder is a Codec of WSSession which encodes the messages to frames with their types.
+// The frames are written by WSConn.WriteMessage, so the empty frames are sent too.
+type WSMessageEncoder interface {
+	EncodeWSMessage(o interface{}) (*WSMessage, error)
+}
+
+// wsMessageCodec keeps the frames of WSConn, Decode returns *WSMessage of a frame,
+// Encode sends *WSMessage by its type, string as a text frame and []byte as a binary frame.
+type wsMessageCodec struct {
+	conn *WSConn
+}
+
+// NewWSMessageCodec returns the codec of conn which keeps the frames and their types.
+// It is the codec of WSSession if WithWSMessage is set.
+func NewWSMessageCodec(conn *WSConn) Codec {
+	return &wsMessageCodec{conn: conn}
+}
+
+func (codec *wsMessageCodec) Decode(reader io.Reader) (interface{}, error) {
+	return codec.conn.ReadMessage()
+}
+
+// Encode returns the data of the frame, the type is lost.
+func (codec *wsMessageCodec) Encode(o interface{}) ([]byte, error) {
+	msg, err := codec.EncodeWSMessage(o)
+	if err != nil {
+		return nil, err
+	}
+	return msg.Data, nil
+}
+
+func (codec *wsMessageCodec) EncodeWSMessage(o interface{}) (*WSMessage, error) {
+	switch msg := o.(type) {
+	case *WSMessage:
+		if msg.Type != WSTextMessage && msg.Type != WSBinaryMessage {
+			return nil, fmt.Errorf("dnet:wsMessageCodec encode message type %d", msg.Type)
+		}
+		return msg, nil
+	case string:
+		return &WSMessage{Type: WSTextMessage, Data: []byte(msg)}, nil
+	case []byte:
+		return &WSMessage{Type: WSBinaryMessage, Data: msg}, nil
+	default:
+		return nil, fmt.Errorf("dnet:wsMessageCodec encode interface{} is %s, need type *WSMessage, string or []byte", reflect.TypeOf(o))
+	}
+}
+
+// the time to wait for the close frame of the peer
+const wsCloseTimeout = time.Second
+
+// NewWSCloseError returns the reason of Session.Close which closes WSSession with the code and text,
+// see the close codes of websocket, such as websocket.CloseNormalClosure. The close frame of the peer
+// is the reason of CloseCallback as *websocket.CloseError.
+func NewWSCloseError(code int, text string) error {
+	return &websocket.CloseError{Code: code, Text: text}
+}
+
+// DefaultWSCloseCode maps the reason of Session.Close to the code and text of the close frame.
+// nil is websocket.CloseNormalClosure, the timeouts are websocket.CloseGoingAway,
+// and others are websocket.CloseInternalServerErr.
+func DefaultWSCloseCode(reason error) (code int, text string) {
+	switch reason {
+	case nil:
+		return websocket.CloseNormalClosure, ""
+	case ErrReadTimeout, ErrSendTimeout:
+		code = websocket.CloseGoingAway
+	default:
+		code = websocket.CloseInternalServerErr
+	}
+	text = reason.Error()
+	if e, ok := reason.(*websocket.CloseError); ok {
+		code, text = e.Code, e.Text
+	}
+	if len(text) > 123 {
+		// the payload of a control frame is 125 bytes at most
+		text = strings.ToValidUTF8(text[:123], "")
+	}
+	return code, text
+}
+
 type WSSession struct {
 	*session
 }
@@ -47,10 +131,22 @@ func NewWSSession(conn net.Conn, options ...Option) *WSSession {
 	}
 	// init default codec
 	if op.Codec == nil {
-		op.Codec = newWsCodec()
+		if wsConn, ok := conn.(*WSConn); ok && op.WSMessage {
+			op.Codec = NewWSMessageCodec(wsConn)
+		} else {
+			op.Codec = newWsCodec()
+		}
 	}
 
 	return &WSSession{
 		session: newSession(conn, op),
 	}
 }
+
+// Request returns the HTTP request upgraded to the session, nil if it is not the server side of WSConn.
+func (this *WSSession) Request() *http.Request {
+	if conn, ok := this.conn.(*WSConn); ok {
+		return conn.Request()
+	}
+	return nil
+}
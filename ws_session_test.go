@@ -1,10 +1,16 @@
 package dnet
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"github.com/gorilla/websocket"
 	"net"
+	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
+	"unicode/utf8"
 )
 
 func TestNewWSSession(t *testing.T) {
@@ -58,3 +64,160 @@ func TestNewWSSession(t *testing.T) {
 	fmt.Println(session.Send([]byte{1, 2, 3, 4}))
 	time.Sleep(time.Second)
 }
+
+func TestWSMessageSession(t *testing.T) {
+	server := httptest.NewServer(NewWSHandler(AcceptorHandlerFunc(func(conn net.Conn) {
+		// echo the frames with their types
+		NewWSSession(conn, WithWSMessage(), WithMessageCallback(func(session Session, message interface{}) {
+			_ = session.Send(message)
+		}))
+	})))
+	defer server.Close()
+
+	conn, err := DialWS(strings.TrimPrefix(server.URL, "http://"), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msgCh := make(chan interface{}, 5)
+	session := NewWSSession(conn, WithWSMessage(), WithMessageCallback(func(session Session, message interface{}) {
+		msgCh <- message
+	}))
+	defer session.Close(nil)
+
+	_ = session.Send(`{"msg":"hello"}`)
+	_ = session.Send([]byte{1, 2, 3})
+	_ = session.Send(&WSMessage{Type: WSTextMessage, Data: []byte("world")})
+	// the empty frames are sent too
+	_ = session.Send("")
+	_ = session.Send(&WSMessage{Type: WSBinaryMessage})
+
+	for _, want := range []WSMessage{
+		{Type: WSTextMessage, Data: []byte(`{"msg":"hello"}`)},
+		{Type: WSBinaryMessage, Data: []byte{1, 2, 3}},
+		{Type: WSTextMessage, Data: []byte("world")},
+		{Type: WSTextMessage},
+		{Type: WSBinaryMessage},
+	} {
+		select {
+		case msg := <-msgCh:
+			m, ok := msg.(*WSMessage)
+			if !ok || m.Type != want.Type || !bytes.Equal(m.Data, want.Data) {
+				t.Fatalf("message %v, want %v", msg, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("wait message timeout")
+		}
+	}
+}
+
+func TestWSCloseCode(t *testing.T) {
+	serverClosed := make(chan error, 1)
+	server := httptest.NewServer(NewWSHandler(AcceptorHandlerFunc(func(conn net.Conn) {
+		NewWSSession(conn,
+			WithMessageCallback(func(session Session, message interface{}) {
+				_ = session.Send(message)
+				if string(message.([]byte)) == "bye" {
+					session.Close(NewWSCloseError(4000, "bye"))
+				}
+			}),
+			WithCloseCallback(func(session Session, reason error) {
+				serverClosed <- reason
+			}))
+	})))
+	defer server.Close()
+
+	conn, err := DialWS(strings.TrimPrefix(server.URL, "http://"), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msgCh := make(chan interface{}, 1)
+	clientClosed := make(chan error, 1)
+	session := NewWSSession(conn,
+		WithMessageCallback(func(session Session, message interface{}) {
+			msgCh <- message
+		}),
+		WithCloseCallback(func(session Session, reason error) {
+			clientClosed <- reason
+		}))
+
+	_ = session.Send([]byte("bye"))
+	select {
+	case msg := <-msgCh:
+		// the message sent before Close is not lost
+		if string(msg.([]byte)) != "bye" {
+			t.Fatalf("message %v", msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("wait message timeout")
+	}
+
+	for _, ch := range []chan error{clientClosed, serverClosed} {
+		select {
+		case reason := <-ch:
+			if e, ok := reason.(*websocket.CloseError); !ok || e.Code != 4000 || e.Text != "bye" {
+				t.Fatalf("close reason %v", reason)
+			}
+		case <-time.After(2 * wsCloseTimeout):
+			t.Fatal("wait close timeout")
+		}
+	}
+}
+
+func TestDefaultWSCloseCode(t *testing.T) {
+	if code, text := DefaultWSCloseCode(nil); code != websocket.CloseNormalClosure || text != "" {
+		t.Fatalf("nil: %d %q", code, text)
+	}
+	if code, _ := DefaultWSCloseCode(ErrReadTimeout); code != websocket.CloseGoingAway {
+		t.Fatalf("timeout: %d", code)
+	}
+	if code, text := DefaultWSCloseCode(errors.New(strings.Repeat("长", 50))); code != websocket.CloseInternalServerErr || len(text) > 123 || !utf8.ValidString(text) {
+		t.Fatalf("error: %d %q", code, text)
+	}
+	closeErr := &websocket.CloseError{Code: websocket.ClosePolicyViolation, Text: strings.Repeat("长", 50)}
+	if code, text := DefaultWSCloseCode(closeErr); code != websocket.ClosePolicyViolation || len(text) > 123 || !utf8.ValidString(text) {
+		t.Fatalf("close error: %d %q", code, text)
+	}
+}
+
+func TestWSCloseHandshake(t *testing.T) {
+	serverClosed := make(chan error, 1)
+	server := httptest.NewServer(NewWSHandler(AcceptorHandlerFunc(func(conn net.Conn) {
+		NewWSSession(conn,
+			WithMessageCallback(func(session Session, message interface{}) {}),
+			WithCloseCallback(func(session Session, reason error) {
+				serverClosed <- reason
+			}))
+	})))
+	defer server.Close()
+
+	conn, err := DialWS(strings.TrimPrefix(server.URL, "http://"), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientClosed := make(chan error, 1)
+	session := NewWSSession(conn,
+		WithMessageCallback(func(session Session, message interface{}) {}),
+		WithCloseCallback(func(session Session, reason error) {
+			clientClosed <- reason
+		}))
+
+	start := time.Now()
+	session.Close(nil)
+	select {
+	case reason := <-serverClosed:
+		if e, ok := reason.(*websocket.CloseError); !ok || e.Code != websocket.CloseNormalClosure {
+			t.Fatalf("server close reason %v", reason)
+		}
+	case <-time.After(2 * wsCloseTimeout):
+		t.Fatal("wait server close timeout")
+	}
+	select {
+	case <-clientClosed:
+		// closed by the reply, not the timeout
+		if d := time.Since(start); d >= wsCloseTimeout {
+			t.Fatalf("closed after %s", d)
+		}
+	case <-time.After(2 * wsCloseTimeout):
+		t.Fatal("wait client close timeout")
+	}
+}